@@ -0,0 +1,453 @@
+package wsocket
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// AsyncAPI specification versions of the generated documents.
+const (
+	// AsyncAPIVersion is the AsyncAPI 2.x version of the documents generated by AsyncAPI.
+	AsyncAPIVersion = "2.6.0"
+	// AsyncAPIV3Version is the AsyncAPI 3.x version of the documents generated by AsyncAPIV3.
+	AsyncAPIV3Version = "3.0.0"
+)
+
+// AsyncAPIInfo holds the general information of the generated AsyncAPI document.
+type AsyncAPIInfo struct {
+	Title       string
+	Version     string
+	Description string
+	// Server is the server URL, e.g. "example.com/ws". If empty, the document has no servers.
+	Server string
+	// Protocol is the server protocol. "ws" is used by default.
+	Protocol string
+	// Channel is the name of the channel the messages are sent over. "/" is used by default.
+	Channel string
+	// SecuritySchemes are the security schemes enforced by the server, by name.
+	// They are not derived from the server configuration and must match the credentials it reads.
+	SecuritySchemes map[string]AsyncAPISecurityScheme
+	// SpecVersion selects the document served by AsyncAPIHandler, AsyncAPIVersion (default) or AsyncAPIV3Version.
+	SpecVersion string
+}
+
+// AsyncAPISecurityScheme is an AsyncAPI security scheme object.
+// See https://www.asyncapi.com/docs/reference/specification/v2.6.0#securitySchemeObject.
+type AsyncAPISecurityScheme struct {
+	Type         string `json:"type"`
+	Description  string `json:"description,omitempty"`
+	Name         string `json:"name,omitempty"`
+	In           string `json:"in,omitempty"`
+	Scheme       string `json:"scheme,omitempty"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+}
+
+// AsyncAPIDocument is an AsyncAPI 2.x document describing the messages handled by a resolver.
+type AsyncAPIDocument struct {
+	AsyncAPI           string                     `json:"asyncapi"`
+	Info               AsyncAPIDocumentInfo       `json:"info"`
+	Servers            map[string]AsyncAPIServer  `json:"servers,omitempty"`
+	DefaultContentType string                     `json:"defaultContentType"`
+	Channels           map[string]AsyncAPIChannel `json:"channels"`
+	Components         AsyncAPIComponents         `json:"components"`
+}
+
+type AsyncAPIDocumentInfo struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+type AsyncAPIServer struct {
+	URL      string                `json:"url"`
+	Protocol string                `json:"protocol"`
+	Security []map[string][]string `json:"security,omitempty"`
+}
+
+type AsyncAPIChannel struct {
+	// Publish describes the messages the clients send to the server.
+	Publish *AsyncAPIOperation `json:"publish,omitempty"`
+	// Subscribe describes the messages the server sends to the clients.
+	Subscribe *AsyncAPIOperation `json:"subscribe,omitempty"`
+}
+
+type AsyncAPIOperation struct {
+	Message AsyncAPIOneOf `json:"message"`
+}
+
+type AsyncAPIOneOf struct {
+	OneOf []AsyncAPIRef `json:"oneOf"`
+}
+
+type AsyncAPIRef struct {
+	Ref string `json:"$ref"`
+}
+
+type AsyncAPIComponents struct {
+	Messages        map[string]AsyncAPIMessage        `json:"messages,omitempty"`
+	SecuritySchemes map[string]AsyncAPISecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+type AsyncAPIMessage struct {
+	Name    string                 `json:"name"`
+	Title   string                 `json:"title,omitempty"`
+	Payload map[string]interface{} `json:"payload"`
+}
+
+// AsyncAPIV3Document is an AsyncAPI 3.x document describing the messages handled by a resolver.
+// Operations are described from the server point of view: it receives the requests and sends the responses.
+type AsyncAPIV3Document struct {
+	AsyncAPI           string                         `json:"asyncapi"`
+	Info               AsyncAPIDocumentInfo           `json:"info"`
+	Servers            map[string]AsyncAPIV3Server    `json:"servers,omitempty"`
+	DefaultContentType string                         `json:"defaultContentType"`
+	Channels           map[string]AsyncAPIV3Channel   `json:"channels"`
+	Operations         map[string]AsyncAPIV3Operation `json:"operations"`
+	Components         AsyncAPIComponents             `json:"components"`
+}
+
+type AsyncAPIV3Server struct {
+	Host     string        `json:"host"`
+	Pathname string        `json:"pathname,omitempty"`
+	Protocol string        `json:"protocol"`
+	Security []AsyncAPIRef `json:"security,omitempty"`
+}
+
+type AsyncAPIV3Channel struct {
+	Address  string                 `json:"address"`
+	Messages map[string]AsyncAPIRef `json:"messages"`
+}
+
+type AsyncAPIV3Operation struct {
+	// Action is "receive" for the messages the clients send to the server and "send" for the messages the server sends.
+	Action   string        `json:"action"`
+	Channel  AsyncAPIRef   `json:"channel"`
+	Messages []AsyncAPIRef `json:"messages"`
+}
+
+// routeLister is implemented by resolvers that can describe their routes, e.g. JSONResolver.
+type routeLister interface {
+	Fields() []string
+	Routes() []RouteInfo
+}
+
+var asyncAPIKeyReplacer = regexp.MustCompile(`[^a-zA-Z0-9.\-_]`)
+
+// asyncAPIRoute is a route described by the generated documents.
+type asyncAPIRoute struct {
+	key     string
+	message AsyncAPIMessage
+	// responseKey and response are set if the route declares a response.
+	responseKey string
+	response    AsyncAPIMessage
+}
+
+// describeRoutes returns the routes of resolver sorted by name, with unique component keys.
+// The routes of the JSONResolvers added with JSONResolver.AddResolver are described in place of their route.
+func describeRoutes(resolver Resolver) []asyncAPIRoute {
+	if _, ok := resolver.(routeLister); !ok {
+		return nil
+	}
+	return appendRoutes(make([]asyncAPIRoute, 0), resolver, newAsyncAPIKeys(), nil)
+}
+
+// routeScope is a route of an outer resolver, its discriminator values are set in the messages of the nested resolver.
+type routeScope struct {
+	name   string
+	fields []string
+}
+
+func appendRoutes(routes []asyncAPIRoute, resolver Resolver, keys asyncAPIKeys, scopes []routeScope) []asyncAPIRoute {
+	lister, ok := resolver.(routeLister)
+	if !ok {
+		return routes
+	}
+
+	fields := lister.Fields()
+	for _, route := range lister.Routes() {
+		if nested, ok := route.Resolver.(routeLister); ok && len(nested.Routes()) > 0 {
+			nestedScopes := append(scopes[:len(scopes):len(scopes)], routeScope{name: route.Name, fields: fields})
+			routes = appendRoutes(routes, route.Resolver, keys, nestedScopes)
+			continue
+		}
+
+		name := route.Name
+		for i := len(scopes) - 1; i >= 0; i-- {
+			name = scopes[i].name + "." + name
+		}
+		described := asyncAPIRoute{
+			key: keys.reserve(name),
+			message: AsyncAPIMessage{
+				Name:    name,
+				Payload: requestPayload(route, fields, scopes),
+			},
+		}
+		if route.Response != nil {
+			described.responseKey = described.key + ".response"
+			described.response = AsyncAPIMessage{
+				Name:    name + " response",
+				Payload: schemaFromType(route.Response),
+			}
+		}
+		routes = append(routes, described)
+	}
+
+	return routes
+}
+
+// asyncAPIKeys allocates component keys. Characters not allowed in keys are replaced with '_',
+// a numeric suffix is added if the result is already used, e.g. by "order._action_" and "order.{action}".
+type asyncAPIKeys map[string]bool
+
+func newAsyncAPIKeys() asyncAPIKeys {
+	return make(asyncAPIKeys)
+}
+
+// reserve returns a unique key for the route name. The key with the ".response" suffix is reserved as well.
+func (k asyncAPIKeys) reserve(name string) string {
+	base := asyncAPIKeyReplacer.ReplaceAllString(name, "_")
+	key := base
+	for i := 2; k[key] || k[key+".response"]; i++ {
+		key = base + "_" + strconv.Itoa(i)
+	}
+	k[key] = true
+	k[key+".response"] = true
+	return key
+}
+
+// AsyncAPI returns an AsyncAPI 2.x document describing the messages handled by resolver.
+// Routes are described if the resolver is a JSONResolver, including the routes of the resolvers added with AddResolver.
+func AsyncAPI(resolver Resolver, info AsyncAPIInfo) *AsyncAPIDocument {
+	info = asyncAPIDefaults(info)
+
+	doc := &AsyncAPIDocument{
+		AsyncAPI: AsyncAPIVersion,
+		Info: AsyncAPIDocumentInfo{
+			Title:       info.Title,
+			Version:     info.Version,
+			Description: info.Description,
+		},
+		DefaultContentType: "application/json",
+		Channels:           make(map[string]AsyncAPIChannel),
+		Components: AsyncAPIComponents{
+			Messages:        make(map[string]AsyncAPIMessage),
+			SecuritySchemes: info.SecuritySchemes,
+		},
+	}
+
+	if info.Server != "" {
+		server := AsyncAPIServer{URL: info.Server, Protocol: info.Protocol}
+		for _, name := range securitySchemeNames(info.SecuritySchemes) {
+			server.Security = append(server.Security, map[string][]string{name: {}})
+		}
+		doc.Servers = map[string]AsyncAPIServer{"default": server}
+	}
+
+	routes := describeRoutes(resolver)
+	if routes == nil {
+		return doc
+	}
+
+	channel := AsyncAPIChannel{}
+	for _, route := range routes {
+		doc.Components.Messages[route.key] = route.message
+		if channel.Publish == nil {
+			channel.Publish = &AsyncAPIOperation{}
+		}
+		channel.Publish.Message.OneOf = append(channel.Publish.Message.OneOf, AsyncAPIRef{Ref: "#/components/messages/" + route.key})
+
+		if route.responseKey == "" {
+			continue
+		}
+		doc.Components.Messages[route.responseKey] = route.response
+		if channel.Subscribe == nil {
+			channel.Subscribe = &AsyncAPIOperation{}
+		}
+		channel.Subscribe.Message.OneOf = append(channel.Subscribe.Message.OneOf, AsyncAPIRef{Ref: "#/components/messages/" + route.responseKey})
+	}
+	doc.Channels[info.Channel] = channel
+
+	return doc
+}
+
+// AsyncAPIV3 returns an AsyncAPI 3.x document describing the messages handled by resolver.
+// Routes are described as by AsyncAPI. Every message has its own operation, named by its component key.
+func AsyncAPIV3(resolver Resolver, info AsyncAPIInfo) *AsyncAPIV3Document {
+	info = asyncAPIDefaults(info)
+
+	doc := &AsyncAPIV3Document{
+		AsyncAPI: AsyncAPIV3Version,
+		Info: AsyncAPIDocumentInfo{
+			Title:       info.Title,
+			Version:     info.Version,
+			Description: info.Description,
+		},
+		DefaultContentType: "application/json",
+		Channels:           make(map[string]AsyncAPIV3Channel),
+		Operations:         make(map[string]AsyncAPIV3Operation),
+		Components: AsyncAPIComponents{
+			Messages:        make(map[string]AsyncAPIMessage),
+			SecuritySchemes: info.SecuritySchemes,
+		},
+	}
+
+	if info.Server != "" {
+		server := AsyncAPIV3Server{Host: info.Server, Protocol: info.Protocol}
+		if i := strings.Index(info.Server, "/"); i >= 0 {
+			server.Host, server.Pathname = info.Server[:i], info.Server[i:]
+		}
+		for _, name := range securitySchemeNames(info.SecuritySchemes) {
+			server.Security = append(server.Security, AsyncAPIRef{Ref: "#/components/securitySchemes/" + name})
+		}
+		doc.Servers = map[string]AsyncAPIV3Server{"default": server}
+	}
+
+	const channelName = "default"
+	channel := AsyncAPIV3Channel{Address: info.Channel, Messages: make(map[string]AsyncAPIRef)}
+	addOperation := func(key, action string, message AsyncAPIMessage) {
+		doc.Components.Messages[key] = message
+		channel.Messages[key] = AsyncAPIRef{Ref: "#/components/messages/" + key}
+		doc.Operations[key] = AsyncAPIV3Operation{
+			Action:   action,
+			Channel:  AsyncAPIRef{Ref: "#/channels/" + channelName},
+			Messages: []AsyncAPIRef{{Ref: "#/channels/" + channelName + "/messages/" + key}},
+		}
+	}
+	for _, route := range describeRoutes(resolver) {
+		addOperation(route.key, "receive", route.message)
+		if route.responseKey != "" {
+			addOperation(route.responseKey, "send", route.response)
+		}
+	}
+	doc.Channels[channelName] = channel
+
+	return doc
+}
+
+func asyncAPIDefaults(info AsyncAPIInfo) AsyncAPIInfo {
+	if info.Protocol == "" {
+		info.Protocol = "ws"
+	}
+	if info.Channel == "" {
+		info.Channel = "/"
+	}
+	return info
+}
+
+func securitySchemeNames(schemes map[string]AsyncAPISecurityScheme) []string {
+	names := make([]string, 0, len(schemes))
+	for name := range schemes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// requestPayload returns the schema of the messages handled by the route.
+// The route schema is used as is, generated schemas are completed with the discriminator values of the route and its scopes.
+func requestPayload(route RouteInfo, fields []string, scopes []routeScope) map[string]interface{} {
+	if route.Schema != "" {
+		schema := make(map[string]interface{})
+		if err := json.Unmarshal([]byte(route.Schema), &schema); err == nil {
+			return schema
+		}
+	}
+
+	schema := map[string]interface{}{"type": "object"}
+	if route.Request != nil {
+		schema = schemaFromType(route.Request)
+	}
+	for _, scope := range scopes {
+		setDiscriminators(schema, scope.name, scope.fields)
+	}
+	setDiscriminators(schema, route.Name, fields)
+
+	return schema
+}
+
+// setDiscriminators sets the values of fields resolving to the route name in schema. Nothing is set for patterns.
+func setDiscriminators(schema map[string]interface{}, name string, fields []string) {
+	if isRoutePattern(name) {
+		return
+	}
+
+	values := []string{name}
+	if len(fields) > 1 {
+		values = splitCompositeKey(name, len(fields))
+	}
+	for i, field := range fields {
+		if i >= len(values) {
+			break
+		}
+		if strings.HasPrefix(field, "?") {
+			if values[i] == "present" {
+				setSchemaProperty(schema, strings.Split(field[1:], "."), map[string]interface{}{})
+			}
+			continue
+		}
+		setSchemaProperty(schema, strings.Split(field, "."), map[string]interface{}{"const": values[i]})
+	}
+}
+
+// setSchemaProperty sets the required property at path to value, creating the intermediate objects.
+func setSchemaProperty(schema map[string]interface{}, path []string, value map[string]interface{}) {
+	properties, ok := schema["properties"].(map[string]interface{})
+	if !ok {
+		properties = make(map[string]interface{})
+		schema["properties"] = properties
+	}
+
+	required, _ := schema["required"].([]string)
+	isRequired := false
+	for _, name := range required {
+		isRequired = isRequired || name == path[0]
+	}
+	if !isRequired {
+		schema["required"] = append(required, path[0])
+	}
+
+	if len(path) == 1 {
+		if existing, ok := properties[path[0]].(map[string]interface{}); ok {
+			for k, v := range value {
+				existing[k] = v
+			}
+			return
+		}
+		properties[path[0]] = value
+		return
+	}
+
+	child, ok := properties[path[0]].(map[string]interface{})
+	if !ok {
+		child = map[string]interface{}{"type": "object"}
+		properties[path[0]] = child
+	}
+	setSchemaProperty(child, path[1:], value)
+}
+
+// AsyncAPIHandler returns an HTTP handler serving the AsyncAPI document of resolver as JSON.
+// info.SpecVersion selects the AsyncAPI version of the document.
+// The document is generated on every request, so routes added later are included.
+func AsyncAPIHandler(resolver Resolver, info AsyncAPIInfo) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var document interface{}
+		if strings.HasPrefix(info.SpecVersion, "3.") {
+			document = AsyncAPIV3(resolver, info)
+		} else {
+			document = AsyncAPI(resolver, info)
+		}
+
+		doc, err := json.Marshal(document)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(doc)
+	})
+}
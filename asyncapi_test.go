@@ -0,0 +1,245 @@
+package wsocket
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testEvent struct {
+	Type       string            `json:"type"`
+	Tags       []string          `json:"tags,omitempty"`
+	Meta       map[string]int    `json:"meta,omitempty"`
+	CreatedAt  time.Time         `json:"created_at"`
+	Data       []byte            `json:"data"`
+	Raw        json.RawMessage   `json:"raw,omitempty"`
+	Parent     *testEvent        `json:"parent,omitempty"`
+	Ignored    string            `json:"-"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	unexported int
+}
+
+func TestAsyncAPI(t *testing.T) {
+	handler := func(ctx context.Context, msg []byte, rw ResponseWriter) error { return nil }
+
+	resolver := NewJSONResolver("type").
+		AddHandler("sum-request", handler, WithPayload(testSumRequest{}, testSumResponse{})).
+		AddHandler("event", handler, WithPayload(&testEvent{}, nil)).
+		AddHandler("validated", handler, WithSchema(`{"type": "object", "required": ["type"]}`)).
+		AddHandler("order.{action}", handler)
+
+	doc := AsyncAPI(resolver, AsyncAPIInfo{
+		Title:   "Test API",
+		Version: "1.0.0",
+		Server:  "localhost:8080/ws",
+		SecuritySchemes: map[string]AsyncAPISecurityScheme{
+			"token": {Type: "httpApiKey", Name: "token", In: "query"},
+		},
+	})
+
+	assert.Equal(t, AsyncAPIVersion, doc.AsyncAPI)
+	assert.Equal(t, "Test API", doc.Info.Title)
+	assert.Equal(t, AsyncAPIServer{
+		URL:      "localhost:8080/ws",
+		Protocol: "ws",
+		Security: []map[string][]string{{"token": {}}},
+	}, doc.Servers["default"])
+	assert.Equal(t, "query", doc.Components.SecuritySchemes["token"].In)
+
+	channel := doc.Channels["/"]
+	assert.Equal(t, []AsyncAPIRef{
+		{Ref: "#/components/messages/event"},
+		{Ref: "#/components/messages/order._action_"},
+		{Ref: "#/components/messages/sum-request"},
+		{Ref: "#/components/messages/validated"},
+	}, channel.Publish.Message.OneOf)
+	assert.Equal(t, []AsyncAPIRef{
+		{Ref: "#/components/messages/sum-request.response"},
+	}, channel.Subscribe.Message.OneOf)
+
+	assert.Equal(t, map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"type": map[string]interface{}{"type": "string", "const": "sum-request"},
+			"a":    map[string]interface{}{"type": "integer"},
+			"b":    map[string]interface{}{"type": "integer"},
+		},
+		"required": []string{"type", "a", "b"},
+	}, doc.Components.Messages["sum-request"].Payload)
+
+	eventProperties := doc.Components.Messages["event"].Payload["properties"].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{"type": "string", "format": "date-time"}, eventProperties["created_at"])
+	assert.Equal(t, map[string]interface{}{"type": "string", "contentEncoding": "base64"}, eventProperties["data"])
+	assert.Equal(t, map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}}, eventProperties["tags"])
+	assert.Equal(t, map[string]interface{}{"type": "object"}, eventProperties["parent"])
+	assert.Equal(t, map[string]interface{}{}, eventProperties["raw"])
+	assert.NotContains(t, eventProperties, "Ignored")
+	assert.NotContains(t, eventProperties, "unexported")
+
+	assert.Equal(t, map[string]interface{}{"type": "object", "required": []interface{}{"type"}}, doc.Components.Messages["validated"].Payload)
+	assert.Equal(t, map[string]interface{}{"type": "object"}, doc.Components.Messages["order._action_"].Payload)
+}
+
+func TestAsyncAPI_CompositeKeys(t *testing.T) {
+	handler := func(ctx context.Context, msg []byte, rw ResponseWriter) error { return nil }
+
+	resolver := NewJSONResolver("meta.channel", "event", "?id").
+		AddHandler("prices/update/present", handler)
+
+	doc := AsyncAPI(resolver, AsyncAPIInfo{Channel: "/ws"})
+
+	assert.Empty(t, doc.Servers)
+	assert.Equal(t, map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"meta": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"channel": map[string]interface{}{"const": "prices"},
+				},
+				"required": []string{"channel"},
+			},
+			"event": map[string]interface{}{"const": "update"},
+			"id":    map[string]interface{}{},
+		},
+		"required": []string{"meta", "event", "id"},
+	}, doc.Components.Messages["prices_update_present"].Payload)
+}
+
+func TestAsyncAPI_KeyCollision(t *testing.T) {
+	handler := func(ctx context.Context, msg []byte, rw ResponseWriter) error { return nil }
+
+	resolver := NewJSONResolver("type").
+		AddHandler("order._action_", handler, WithPayload(nil, testSumResponse{})).
+		AddHandler("order.{action}", handler)
+
+	doc := AsyncAPI(resolver, AsyncAPIInfo{})
+
+	assert.Equal(t, "order._action_", doc.Components.Messages["order._action_"].Name)
+	assert.Equal(t, "order._action_ response", doc.Components.Messages["order._action_.response"].Name)
+	assert.Equal(t, "order.{action}", doc.Components.Messages["order._action__2"].Name)
+}
+
+func TestAsyncAPI_NestedResolver(t *testing.T) {
+	handler := func(ctx context.Context, msg []byte, rw ResponseWriter) error { return nil }
+
+	resolver := NewJSONResolver("type").
+		AddHandler("ping", handler).
+		AddResolver("event", NewJSONResolver("data.type").
+			AddHandler("info", handler, WithPayload(nil, testSumResponse{})).
+			AddHandler("error", handler),
+		).
+		AddResolver("empty", NewJSONResolver("data.type"))
+
+	doc := AsyncAPI(resolver, AsyncAPIInfo{})
+
+	assert.Equal(t, []AsyncAPIRef{
+		{Ref: "#/components/messages/empty"},
+		{Ref: "#/components/messages/event.error"},
+		{Ref: "#/components/messages/event.info"},
+		{Ref: "#/components/messages/ping"},
+	}, doc.Channels["/"].Publish.Message.OneOf)
+	assert.Equal(t, "event.info response", doc.Components.Messages["event.info.response"].Name)
+	assert.Equal(t, map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"type": map[string]interface{}{"const": "event"},
+			"data": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"type": map[string]interface{}{"const": "info"},
+				},
+				"required": []string{"type"},
+			},
+		},
+		"required": []string{"type", "data"},
+	}, doc.Components.Messages["event.info"].Payload)
+
+	rw := &testResponseWriter{}
+	assert.NoError(t, NewJSONResolver("type").
+		AddResolver("event", NewJSONResolver("data.type").AddHandler("info", func(ctx context.Context, msg []byte, rw ResponseWriter) error {
+			return rw.WriteMessage(NewTextMessage([]byte("info")))
+		})).
+		Handle(context.Background(), []byte(`{"type": "event", "data": {"type": "info"}}`), rw))
+	assert.Equal(t, "info", string(rw.GetWrittenMessage().Message))
+}
+
+func TestAsyncAPIV3(t *testing.T) {
+	handler := func(ctx context.Context, msg []byte, rw ResponseWriter) error { return nil }
+
+	resolver := NewJSONResolver("type").
+		AddHandler("sum-request", handler, WithPayload(testSumRequest{}, testSumResponse{})).
+		AddHandler("ping", handler)
+
+	doc := AsyncAPIV3(resolver, AsyncAPIInfo{
+		Title:   "Test API",
+		Version: "1.0.0",
+		Server:  "localhost:8080/ws",
+		SecuritySchemes: map[string]AsyncAPISecurityScheme{
+			"token": {Type: "httpApiKey", Name: "token", In: "query"},
+		},
+	})
+
+	assert.Equal(t, AsyncAPIV3Version, doc.AsyncAPI)
+	assert.Equal(t, AsyncAPIV3Server{
+		Host:     "localhost:8080",
+		Pathname: "/ws",
+		Protocol: "ws",
+		Security: []AsyncAPIRef{{Ref: "#/components/securitySchemes/token"}},
+	}, doc.Servers["default"])
+
+	assert.Equal(t, AsyncAPIV3Channel{
+		Address: "/",
+		Messages: map[string]AsyncAPIRef{
+			"ping":                 {Ref: "#/components/messages/ping"},
+			"sum-request":          {Ref: "#/components/messages/sum-request"},
+			"sum-request.response": {Ref: "#/components/messages/sum-request.response"},
+		},
+	}, doc.Channels["default"])
+
+	assert.Equal(t, AsyncAPIV3Operation{
+		Action:   "receive",
+		Channel:  AsyncAPIRef{Ref: "#/channels/default"},
+		Messages: []AsyncAPIRef{{Ref: "#/channels/default/messages/sum-request"}},
+	}, doc.Operations["sum-request"])
+	assert.Equal(t, "send", doc.Operations["sum-request.response"].Action)
+	assert.Len(t, doc.Operations, 3)
+	assert.Contains(t, doc.Components.Messages, "sum-request.response")
+}
+
+func TestAsyncAPIHandler(t *testing.T) {
+	tests := []struct {
+		specVersion     string
+		expectedVersion string
+	}{
+		{specVersion: "", expectedVersion: AsyncAPIVersion},
+		{specVersion: AsyncAPIV3Version, expectedVersion: AsyncAPIV3Version},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expectedVersion, func(t *testing.T) {
+			resolver := NewJSONResolver("type")
+
+			server := httptest.NewServer(AsyncAPIHandler(resolver, AsyncAPIInfo{Title: "Test API", Version: "1.0.0", SpecVersion: tt.specVersion}))
+			defer server.Close()
+
+			resolver.AddHandler("ping", func(ctx context.Context, msg []byte, rw ResponseWriter) error { return nil })
+
+			resp, err := http.Get(server.URL)
+			assert.NoError(t, err)
+			defer resp.Body.Close()
+
+			assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+
+			doc := map[string]interface{}{}
+			assert.NoError(t, json.NewDecoder(resp.Body).Decode(&doc))
+			assert.Equal(t, tt.expectedVersion, doc["asyncapi"])
+			assert.Contains(t, doc["components"].(map[string]interface{})["messages"], "ping")
+		})
+	}
+}
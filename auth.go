@@ -0,0 +1,452 @@
+package wsocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Authentication message types.
+const (
+	// AuthMessageType is the type of the message authenticating a connection after the upgrade, e.g. {"type": "auth", "token": "eyJh..."}.
+	AuthMessageType = "auth"
+	// ReauthMessageType is the type of the message refreshing the credentials of a connection, e.g. {"type": "reauth", "token": "eyJh..."}.
+	ReauthMessageType = "reauth"
+	// AuthenticatedMessageType is the type of the reply to a successful "auth" or "reauth" message,
+	// e.g. {"type": "authenticated", "id": "alice", "expires_at": "2024-01-02T15:04:05Z"}.
+	AuthenticatedMessageType = "authenticated"
+	// ReauthRequiredMessageType is the type of the message sent by AuthExpiryChallenge when the credentials expire,
+	// e.g. {"type": "reauth_required", "deadline": "2024-01-02T15:04:35Z"}. The connection is closed if it doesn't reauthenticate before the deadline.
+	ReauthRequiredMessageType = "reauth_required"
+)
+
+// ErrorCodeUnauthenticated is the code of the ErrorReply sent when a message is received from an unauthenticated connection
+// or an "auth" or "reauth" message is rejected.
+const ErrorCodeUnauthenticated = "unauthenticated"
+
+// ErrUnauthenticated is returned when a message is received from an unauthenticated connection or credentials are rejected.
+// The client doesn't log it.
+var ErrUnauthenticated = errors.New("unauthenticated")
+
+// Principal is the authenticated identity of a connection.
+type Principal struct {
+	// ID identifies the principal, e.g. the user ID.
+	ID string
+	// Roles and Permissions are the roles and permissions granted to the principal.
+	Roles       []string
+	Permissions []string
+	// ExpiresAt is the time the credentials expire, zero if they don't expire.
+	ExpiresAt time.Time
+	// Claims holds additional information extracted from the credentials.
+	Claims map[string]interface{}
+}
+
+// HasRole reports whether the principal has the role.
+func (p Principal) HasRole(role string) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// HasPermission reports whether the principal has the permission.
+func (p Principal) HasPermission(permission string) bool {
+	for _, granted := range p.Permissions {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator validates credentials, e.g. a JWT, and returns the principal they identify.
+type Authenticator interface {
+	Authenticate(ctx context.Context, token string) (Principal, error)
+}
+
+// AuthenticatorFunc is a function implementing Authenticator.
+type AuthenticatorFunc func(ctx context.Context, token string) (Principal, error)
+
+func (f AuthenticatorFunc) Authenticate(ctx context.Context, token string) (Principal, error) {
+	return f(ctx, token)
+}
+
+// TokenSource extracts the credentials from the upgrade request.
+type TokenSource func(r *http.Request) string
+
+// TokenFromHeader extracts the credentials from the header, removing the "Bearer " prefix if present.
+func TokenFromHeader(name string) TokenSource {
+	return func(r *http.Request) string {
+		value := r.Header.Get(name)
+		if len(value) > len("Bearer ") && strings.EqualFold(value[:len("Bearer ")], "Bearer ") {
+			return value[len("Bearer "):]
+		}
+		return value
+	}
+}
+
+// TokenFromQuery extracts the credentials from the query parameter.
+func TokenFromQuery(name string) TokenSource {
+	return func(r *http.Request) string {
+		return r.URL.Query().Get(name)
+	}
+}
+
+// AuthExpiryAction is the action taken when the credentials of a connection expire.
+type AuthExpiryAction int
+
+const (
+	// AuthExpiryClose closes the connection with the policy violation close code (1008).
+	AuthExpiryClose AuthExpiryAction = iota
+	// AuthExpiryChallenge sends a "reauth_required" message and rejects the messages of the connection until it reauthenticates.
+	// The connection is closed if it doesn't reauthenticate within the grace period.
+	AuthExpiryChallenge
+)
+
+type principalContextKey struct{}
+
+// PrincipalFromContext returns the principal of the connection the message being handled was received from.
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+	principal, ok := ctx.Value(principalContextKey{}).(Principal)
+	return principal, ok
+}
+
+// AuthManager authenticates connections and keeps their credentials fresh.
+//
+// Connections are authenticated at the upgrade with AuthenticateRequest, or by the peer sending an "auth" message first.
+// Messages of unauthenticated connections are rejected with an ErrorReply with code ErrorCodeUnauthenticated,
+// and a connection that doesn't authenticate within the grace period is closed.
+// When the credentials expire, the connection is closed or challenged, see AuthExpiryAction.
+// The peer refreshes its credentials without reconnecting by sending a "reauth" message, it must identify the same principal.
+//
+// Handlers receive the principal from PrincipalFromContext. Only the connections created by Connect or added with Register are accepted.
+type AuthManager struct {
+	mu sync.Mutex
+
+	client        Client
+	authenticator Authenticator
+	action        AuthExpiryAction
+	grace         time.Duration
+
+	connections map[Connection]*authState
+}
+
+type authState struct {
+	principal     Principal
+	authenticated bool
+	timer         *time.Timer
+	// generation is incremented when the timer is replaced, so a stale timer doesn't act.
+	generation uint64
+}
+
+// NewAuthManager creates a new AuthManager instance and adds its middleware to the client.
+// Add the "auth" and "reauth" handlers to the resolver of the client with AddHandlers.
+// grace is how long a connection may stay unauthenticated: the time to send the first "auth" message,
+// and with AuthExpiryChallenge, the time to reauthenticate after the credentials expire.
+// If grace is not positive, NewAuthManager panics.
+func NewAuthManager(client Client, authenticator Authenticator, action AuthExpiryAction, grace time.Duration) *AuthManager {
+	if grace <= 0 {
+		panic(fmt.Sprintf("wsocket: invalid authentication grace period: %s", grace))
+	}
+
+	m := &AuthManager{
+		client:        client,
+		authenticator: authenticator,
+		action:        action,
+		grace:         grace,
+		connections:   make(map[Connection]*authState),
+	}
+	client.AddMiddleware(m.middleware)
+
+	return m
+}
+
+// AuthenticateRequest authenticates the upgrade request with the credentials extracted by source.
+// It returns an error wrapping ErrUnauthenticated if there are no credentials or they are rejected,
+// the upgrade can then be refused or the connection left to authenticate with an "auth" message.
+func (m *AuthManager) AuthenticateRequest(r *http.Request, source TokenSource) (Principal, error) {
+	token := source(r)
+	if token == "" {
+		return Principal{}, fmt.Errorf("%w: no credentials", ErrUnauthenticated)
+	}
+	return m.authenticate(r.Context(), token)
+}
+
+// Connect creates a connection for the socket and registers it, see Register.
+func (m *AuthManager) Connect(websocketConn *websocket.Conn, principal *Principal) Connection {
+	if websocketConn == nil {
+		return nil
+	}
+
+	// The connection is registered before its first message is handled, the middleware waits for the lock.
+	m.mu.Lock()
+	conn := m.client.NewConnection(websocketConn)
+	m.registerLocked(conn, principal)
+	m.mu.Unlock()
+
+	return conn
+}
+
+// Register starts authenticating a connection created elsewhere, e.g. a Session created by SessionManager.Connect.
+// If principal is nil, the peer must authenticate with an "auth" message within the grace period.
+// Registering the connection again replaces its principal, e.g. after a session is resumed with new credentials.
+// Messages received before the connection is registered are rejected.
+//
+// A Session is found by the middleware if the AuthManager is created after the SessionManager,
+// so its middleware receives the session instead of the socket connection.
+func (m *AuthManager) Register(conn Connection, principal *Principal) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.registerLocked(conn, principal)
+}
+
+func (m *AuthManager) registerLocked(conn Connection, principal *Principal) {
+	state := &authState{}
+	if principal != nil {
+		state.principal = *principal
+		state.authenticated = true
+	}
+
+	previous, registered := m.connections[conn]
+	if registered {
+		if previous.timer != nil {
+			previous.timer.Stop()
+		}
+		state.generation = previous.generation
+	}
+	m.connections[conn] = state
+	m.scheduleLocked(conn, state)
+
+	if !registered {
+		go m.removeOnClose(conn)
+	}
+}
+
+// stateLocked returns the connection the message being handled was received from and its state,
+// false if the connection is not registered. With a SessionManager, the connection is the session.
+func (m *AuthManager) stateLocked(ctx context.Context) (Connection, *authState, bool) {
+	conn, ok := ConnectionFromContext(ctx)
+	if !ok {
+		return nil, nil, false
+	}
+	state, ok := m.connections[conn]
+	return conn, state, ok
+}
+
+// Principal returns the principal of the connection, false if it is not authenticated.
+func (m *AuthManager) Principal(conn Connection) (Principal, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	state, ok := m.connections[conn]
+	if !ok || !state.authenticated {
+		return Principal{}, false
+	}
+	return state.principal, true
+}
+
+// AddHandlers adds the built-in "auth" and "reauth" handlers to jr.
+func (m *AuthManager) AddHandlers(jr *JSONResolver) *JSONResolver {
+	return jr.
+		AddHandler(AuthMessageType, m.handleAuth).
+		AddHandler(ReauthMessageType, m.handleAuth)
+}
+
+func (m *AuthManager) middleware(ctx context.Context, msg []byte) (context.Context, []byte, error) {
+	m.mu.Lock()
+	conn, state, ok := m.stateLocked(ctx)
+	var principal Principal
+	authenticated := ok && state.authenticated
+	if authenticated {
+		principal = state.principal
+	}
+	m.mu.Unlock()
+
+	if authenticated {
+		return context.WithValue(ctx, principalContextKey{}, principal), msg, nil
+	}
+
+	if ok {
+		jsonMsg, err := ParseJSONMessage(ctx, msg)
+		if err == nil {
+			if msgType := jsonMsg.GetString("type"); msgType == AuthMessageType || msgType == ReauthMessageType {
+				return ctx, msg, nil
+			}
+		}
+	}
+
+	if conn == nil {
+		return ctx, nil, ErrUnauthenticated
+	}
+	// The middleware runs in the read loop, it must not wait for the write queue.
+	// A peer that doesn't read the replies is closed instead.
+	err := tryWriteErrorReply(conn, NewErrorReply(ErrorCodeUnauthenticated, "authentication required", "", nil))
+	switch {
+	case err == nil:
+	case errors.Is(err, errWriteQueueFull):
+		go func() { _ = CloseWithReason(conn, websocket.ClosePolicyViolation, "authentication required") }()
+	default:
+		return ctx, nil, err
+	}
+	return ctx, nil, ErrUnauthenticated
+}
+
+func (m *AuthManager) handleAuth(ctx context.Context, msg []byte, rw ResponseWriter) error {
+	jsonMsg, err := ParseJSONMessage(ctx, msg)
+	if err != nil {
+		return err
+	}
+
+	principal, err := m.authenticate(ctx, jsonMsg.GetString("token"))
+	if err == nil {
+		err = m.update(ctx, principal)
+	}
+	if err != nil {
+		// The reason isn't sent to the peer, it may reveal details of the authenticator.
+		if replyErr := WriteErrorReply(rw, NewErrorReply(ErrorCodeUnauthenticated, "authentication failed", "", nil)); replyErr != nil {
+			return replyErr
+		}
+		return err
+	}
+
+	var expiresAt *time.Time
+	if !principal.ExpiresAt.IsZero() {
+		expiresAt = &principal.ExpiresAt
+	}
+	reply, err := json.Marshal(struct {
+		Type      string     `json:"type"`
+		ID        string     `json:"id"`
+		ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	}{Type: AuthenticatedMessageType, ID: principal.ID, ExpiresAt: expiresAt})
+	if err != nil {
+		return err
+	}
+	return rw.WriteMessage(NewTextMessage(reply).WithPriority(PriorityHigh))
+}
+
+// authenticate validates the token. Rejected credentials are reported with an error wrapping ErrUnauthenticated.
+func (m *AuthManager) authenticate(ctx context.Context, token string) (Principal, error) {
+	if token == "" {
+		return Principal{}, fmt.Errorf("%w: no credentials", ErrUnauthenticated)
+	}
+
+	principal, err := m.authenticator.Authenticate(ctx, token)
+	if err != nil {
+		return Principal{}, fmt.Errorf("%w: %v", ErrUnauthenticated, err)
+	}
+	if !principal.ExpiresAt.IsZero() && !time.Now().Before(principal.ExpiresAt) {
+		return Principal{}, fmt.Errorf("%w: credentials expired", ErrUnauthenticated)
+	}
+	return principal, nil
+}
+
+// update sets the principal of the connection the "auth" or "reauth" message was received from.
+// Once a connection is authenticated, its principal can't be changed.
+func (m *AuthManager) update(ctx context.Context, principal Principal) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	conn, state, ok := m.stateLocked(ctx)
+	if !ok {
+		return fmt.Errorf("%w: unknown connection", ErrUnauthenticated)
+	}
+	if state.principal.ID != "" && state.principal.ID != principal.ID {
+		return fmt.Errorf("%w: credentials identify another principal", ErrUnauthenticated)
+	}
+
+	state.principal = principal
+	state.authenticated = true
+	m.scheduleLocked(conn, state)
+	return nil
+}
+
+// scheduleLocked replaces the timer of the connection: an unauthenticated connection is closed after the grace period,
+// an authenticated one expires with its credentials.
+func (m *AuthManager) scheduleLocked(conn Connection, state *authState) {
+	if state.timer != nil {
+		state.timer.Stop()
+		state.timer = nil
+	}
+	state.generation++
+	generation := state.generation
+
+	if !state.authenticated {
+		state.timer = time.AfterFunc(m.grace, func() {
+			m.closeUnauthenticated(conn, generation)
+		})
+		return
+	}
+	if !state.principal.ExpiresAt.IsZero() {
+		state.timer = time.AfterFunc(time.Until(state.principal.ExpiresAt), func() {
+			m.expire(conn, generation)
+		})
+	}
+}
+
+func (m *AuthManager) closeUnauthenticated(conn Connection, generation uint64) {
+	m.mu.Lock()
+	state, ok := m.connections[conn]
+	stale := !ok || state.generation != generation || state.authenticated
+	m.mu.Unlock()
+
+	if !stale {
+		_ = CloseWithReason(conn, websocket.ClosePolicyViolation, "authentication required")
+	}
+}
+
+func (m *AuthManager) expire(conn Connection, generation uint64) {
+	// Without a challenge the peer can't reauthenticate, the connection is closed instead.
+	var challenge []byte
+	if m.action == AuthExpiryChallenge {
+		if msg, err := json.Marshal(struct {
+			Type     string    `json:"type"`
+			Deadline time.Time `json:"deadline"`
+		}{Type: ReauthRequiredMessageType, Deadline: time.Now().Add(m.grace)}); err == nil {
+			challenge = msg
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	state, ok := m.connections[conn]
+	if !ok || state.generation != generation {
+		return
+	}
+
+	if challenge == nil {
+		go func() { _ = CloseWithReason(conn, websocket.ClosePolicyViolation, "credentials expired") }()
+		return
+	}
+
+	state.authenticated = false
+	m.scheduleLocked(conn, state)
+
+	// The challenge is queued under the lock, so it can't be sent after the connection has reauthenticated.
+	if err := writeNonBlocking(conn, NewTextMessage(challenge).WithPriority(PriorityHigh)); err != nil && !errors.Is(err, ErrConnectionClosed) {
+		go func() { _ = CloseWithReason(conn, websocket.ClosePolicyViolation, "credentials expired") }()
+	}
+}
+
+func (m *AuthManager) removeOnClose(conn Connection) {
+	<-conn.Wait()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if state, ok := m.connections[conn]; ok {
+		if state.timer != nil {
+			state.timer.Stop()
+		}
+		delete(m.connections, conn)
+	}
+}
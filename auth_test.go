@@ -0,0 +1,224 @@
+package wsocket
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+type authReply struct {
+	Type    string `json:"type"`
+	ID      string `json:"id"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// testAuthenticator accepts tokens of the form "<user>" and "<user>:short", the latter expiring after 100ms.
+func testAuthenticator() Authenticator {
+	return AuthenticatorFunc(func(_ context.Context, token string) (Principal, error) {
+		switch token {
+		case "alice", "bob":
+			return Principal{ID: token}, nil
+		case "alice:short":
+			return Principal{ID: "alice", ExpiresAt: time.Now().Add(100 * time.Millisecond)}, nil
+		default:
+			return Principal{}, errors.New("invalid token")
+		}
+	})
+}
+
+func newAuthTestServer(t *testing.T, action AuthExpiryAction) (*AuthManager, *httptest.Server) {
+	resolver := NewJSONResolver("type").AddHandler("whoami", func(ctx context.Context, msg []byte, rw ResponseWriter) error {
+		principal, _ := PrincipalFromContext(ctx)
+		return rw.WriteMessage(NewTextMessage([]byte(`{"type":"whoami","id":"` + principal.ID + `"}`)))
+	})
+	client := NewClient(context.Background(), resolver, NoLogger(), 10)
+	manager := NewAuthManager(client, testAuthenticator(), action, 200*time.Millisecond)
+	manager.AddHandlers(resolver)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var principal *Principal
+		if p, err := manager.AuthenticateRequest(r, TokenFromQuery("token")); err == nil {
+			principal = &p
+		}
+
+		upgrader := websocket.Upgrader{}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		assert.NoError(t, err)
+		manager.Connect(conn, principal)
+	}))
+
+	return manager, server
+}
+
+func dialAuthTestServer(t *testing.T, server *httptest.Server, token string) *websocket.Conn {
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+server.URL[4:]+"?token="+token, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+	return conn
+}
+
+func sendAuthTest(t *testing.T, conn *websocket.Conn, msg string) authReply {
+	assert.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(msg)))
+	var reply authReply
+	assert.NoError(t, conn.ReadJSON(&reply))
+	return reply
+}
+
+func TestAuthManager_UpgradeAuthentication(t *testing.T) {
+	_, server := newAuthTestServer(t, AuthExpiryClose)
+	defer server.Close()
+
+	conn := dialAuthTestServer(t, server, "alice")
+	defer conn.Close()
+
+	assert.Equal(t, authReply{Type: "whoami", ID: "alice"}, sendAuthTest(t, conn, `{"type":"whoami"}`))
+}
+
+func TestAuthManager_FirstMessageAuthentication(t *testing.T) {
+	_, server := newAuthTestServer(t, AuthExpiryClose)
+	defer server.Close()
+
+	conn := dialAuthTestServer(t, server, "")
+	defer conn.Close()
+
+	assert.Equal(t, ErrorCodeUnauthenticated, sendAuthTest(t, conn, `{"type":"whoami"}`).Code)
+	assert.Equal(t, authReply{Type: "error", Code: ErrorCodeUnauthenticated, Message: "authentication failed"},
+		sendAuthTest(t, conn, `{"type":"auth","token":"invalid"}`), "Expected the rejection reason not to be sent")
+	assert.Equal(t, authReply{Type: AuthenticatedMessageType, ID: "bob"}, sendAuthTest(t, conn, `{"type":"auth","token":"bob"}`))
+	assert.Equal(t, authReply{Type: "whoami", ID: "bob"}, sendAuthTest(t, conn, `{"type":"whoami"}`))
+	assert.Equal(t, ErrorCodeUnauthenticated, sendAuthTest(t, conn, `{"type":"reauth","token":"alice"}`).Code,
+		"Expected reauthentication as another principal to be rejected")
+}
+
+func TestAuthManager_Register_Session(t *testing.T) {
+	resolver := NewJSONResolver("type").AddHandler("whoami", func(ctx context.Context, msg []byte, rw ResponseWriter) error {
+		principal, _ := PrincipalFromContext(ctx)
+		return rw.WriteMessage(NewTextMessage([]byte(`{"type":"whoami","id":"` + principal.ID + `"}`)))
+	})
+	client := NewClient(context.Background(), resolver, NoLogger(), 10)
+	sessions := NewSessionManager(client, nil, time.Second, NoLogger())
+	manager := NewAuthManager(client, testAuthenticator(), AuthExpiryClose, 200*time.Millisecond)
+	manager.AddHandlers(resolver)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := websocket.Upgrader{}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		assert.NoError(t, err)
+
+		session, err := sessions.Connect(r.Context(), conn, r.URL.Query().Get("resume"))
+		assert.NoError(t, err)
+		if _, ok := manager.Principal(session); !ok {
+			manager.Register(session, nil)
+		}
+	}))
+	defer server.Close()
+
+	dial := func(resume string) (*websocket.Conn, sessionReply) {
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+server.URL[4:]+"?resume="+resume, nil)
+		assert.NoError(t, err)
+		assert.NoError(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+
+		var reply sessionReply
+		assert.NoError(t, conn.ReadJSON(&reply))
+		return conn, reply
+	}
+
+	conn, first := dial("")
+	assert.Equal(t, authReply{Type: AuthenticatedMessageType, ID: "bob"}, sendAuthTest(t, conn, `{"type":"auth","token":"bob"}`))
+	assert.Equal(t, authReply{Type: "whoami", ID: "bob"}, sendAuthTest(t, conn, `{"type":"whoami"}`))
+	assert.NoError(t, conn.Close())
+
+	// The principal is kept for the session across reconnects.
+	conn, resumed := dial(first.Token)
+	defer conn.Close()
+	assert.True(t, resumed.Resumed)
+	assert.Equal(t, authReply{Type: "whoami", ID: "bob"}, sendAuthTest(t, conn, `{"type":"whoami"}`))
+}
+
+func TestAuthManager_AuthenticationTimeout(t *testing.T) {
+	_, server := newAuthTestServer(t, AuthExpiryClose)
+	defer server.Close()
+
+	conn := dialAuthTestServer(t, server, "")
+	defer conn.Close()
+
+	_, _, err := conn.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.ClosePolicyViolation), "Unexpected error: %v", err)
+}
+
+func TestAuthManager_RejectionQueueFull(t *testing.T) {
+	client := NewClient(context.Background(), NewJSONResolver("type"), NoLogger(), 10)
+	manager := NewAuthManager(client, testAuthenticator(), AuthExpiryClose, time.Minute)
+
+	conn := slowTestConnection{newTestConnection()}
+	manager.Register(conn, nil)
+
+	_, _, err := manager.middleware(withConnection(context.Background(), conn), []byte(`{"type":"whoami"}`))
+	assert.ErrorIs(t, err, ErrUnauthenticated)
+
+	select {
+	case <-conn.Wait():
+	case <-time.After(time.Second):
+		t.Fatal("Expected the connection that doesn't read its replies to be closed")
+	}
+}
+
+func TestAuthManager_Expiry(t *testing.T) {
+	t.Run("close", func(t *testing.T) {
+		_, server := newAuthTestServer(t, AuthExpiryClose)
+		defer server.Close()
+
+		conn := dialAuthTestServer(t, server, "alice:short")
+		defer conn.Close()
+
+		_, _, err := conn.ReadMessage()
+		assert.True(t, websocket.IsCloseError(err, websocket.ClosePolicyViolation), "Unexpected error: %v", err)
+	})
+
+	t.Run("challenge", func(t *testing.T) {
+		manager, server := newAuthTestServer(t, AuthExpiryChallenge)
+		defer server.Close()
+
+		conn := dialAuthTestServer(t, server, "alice:short")
+		defer conn.Close()
+
+		var challenge authReply
+		assert.NoError(t, conn.ReadJSON(&challenge))
+		assert.Equal(t, ReauthRequiredMessageType, challenge.Type)
+		assert.Equal(t, ErrorCodeUnauthenticated, sendAuthTest(t, conn, `{"type":"whoami"}`).Code)
+
+		assert.Equal(t, authReply{Type: AuthenticatedMessageType, ID: "alice"}, sendAuthTest(t, conn, `{"type":"reauth","token":"alice"}`))
+		assert.Equal(t, authReply{Type: "whoami", ID: "alice"}, sendAuthTest(t, conn, `{"type":"whoami"}`))
+
+		time.Sleep(300 * time.Millisecond)
+		assert.Equal(t, authReply{Type: "whoami", ID: "alice"}, sendAuthTest(t, conn, `{"type":"whoami"}`),
+			"Expected the connection to stay open after reauthentication")
+
+		manager.mu.Lock()
+		assert.Len(t, manager.connections, 1)
+		manager.mu.Unlock()
+	})
+}
+
+func TestTokenSource(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/ws?access_token=query", nil)
+	r.Header.Set("Authorization", "Bearer header")
+
+	assert.Equal(t, "header", TokenFromHeader("Authorization")(r))
+	assert.Equal(t, "query", TokenFromQuery("access_token")(r))
+
+	r.Header.Set("X-Token", "raw")
+	assert.Equal(t, "raw", TokenFromHeader("X-Token")(r))
+}
+
+func TestNewAuthManager_InvalidGrace(t *testing.T) {
+	client := NewClient(context.Background(), NewJSONResolver("type"), NoLogger(), 10)
+	assert.Panics(t, func() { NewAuthManager(client, testAuthenticator(), AuthExpiryClose, 0) })
+}
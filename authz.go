@@ -0,0 +1,187 @@
+package wsocket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// ErrorCodeForbidden is the code of the ErrorReply sent when a message is denied by an Authorizer.
+const ErrorCodeForbidden = "forbidden"
+
+// ErrForbidden is returned when a message is denied by an Authorizer.
+// The client doesn't log it, denials are logged by the Authorizer.
+var ErrForbidden = errors.New("forbidden")
+
+// Requirement is a condition the principal sending a message must meet, declared with WithAuthorization.
+type Requirement struct {
+	// AnyRole is satisfied if the principal has at least one of the roles.
+	AnyRole []string
+	// Permissions is satisfied if the principal has all of the permissions.
+	Permissions []string
+}
+
+// RequireRole requires the principal to have at least one of the roles.
+func RequireRole(roles ...string) Requirement {
+	return Requirement{AnyRole: roles}
+}
+
+// RequirePermission requires the principal to have all of the permissions.
+func RequirePermission(permissions ...string) Requirement {
+	return Requirement{Permissions: permissions}
+}
+
+// SatisfiedBy reports whether the principal meets the requirement.
+func (r Requirement) SatisfiedBy(principal Principal) bool {
+	if len(r.AnyRole) > 0 {
+		hasRole := false
+		for _, role := range r.AnyRole {
+			if principal.HasRole(role) {
+				hasRole = true
+				break
+			}
+		}
+		if !hasRole {
+			return false
+		}
+	}
+
+	for _, permission := range r.Permissions {
+		if !principal.HasPermission(permission) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r Requirement) String() string {
+	parts := make([]string, 0, 2)
+	if len(r.AnyRole) > 0 {
+		parts = append(parts, "role "+strings.Join(r.AnyRole, " or "))
+	}
+	if len(r.Permissions) > 0 {
+		parts = append(parts, "permission "+strings.Join(r.Permissions, " and "))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// AuthorizationRequest describes a message being authorized.
+type AuthorizationRequest struct {
+	// Route is the name of the route, it is a pattern if the route was added with one, e.g. "order.{action}".
+	Route string
+	// MessageType is the message type the message was resolved to, e.g. "order.created".
+	MessageType string
+	// Principal is the principal of the connection, see PrincipalFromContext. It is zero if Authenticated is false.
+	Principal     Principal
+	Authenticated bool
+	// Requirements are the requirements declared for the route with WithAuthorization.
+	Requirements []Requirement
+	Message      []byte
+}
+
+// AuthorizationPolicy decides whether a message is allowed. It returns an error describing the reason of a denial.
+type AuthorizationPolicy func(ctx context.Context, req AuthorizationRequest) error
+
+// DefaultAuthorizationPolicy allows the messages of authenticated principals meeting all the requirements of the route.
+// Custom policies can call it to add their own rules, e.g. on the message content.
+func DefaultAuthorizationPolicy(_ context.Context, req AuthorizationRequest) error {
+	if !req.Authenticated {
+		return errors.New("not authenticated")
+	}
+	for _, requirement := range req.Requirements {
+		if !requirement.SatisfiedBy(req.Principal) {
+			return fmt.Errorf("requires %s", requirement)
+		}
+	}
+	return nil
+}
+
+// AuthorizerStats holds the number of messages checked by an Authorizer.
+type AuthorizerStats struct {
+	Allowed uint64
+	Denied  uint64
+}
+
+// Authorizer restricts the messages a principal may send on the JSONResolver routes declared with WithAuthorization.
+// Denied messages are not passed to the handler, an ErrorReply with code ErrorCodeForbidden is sent instead,
+// the denial is logged and an error wrapping ErrForbidden is returned.
+// The principal is read with PrincipalFromContext, see AuthManager.
+type Authorizer struct {
+	policy AuthorizationPolicy
+	logger Logger
+
+	allowed uint64
+	denied  uint64
+}
+
+// NewAuthorizer creates a new Authorizer instance.
+// policy decides whether a message is allowed, if nil, DefaultAuthorizationPolicy is used.
+// logger is used to log denials for auditing. If nil, a default logger is used.
+func NewAuthorizer(policy AuthorizationPolicy, logger Logger) *Authorizer {
+	if policy == nil {
+		policy = DefaultAuthorizationPolicy
+	}
+	if logger == nil {
+		logger = DefaultLogger()
+	}
+
+	return &Authorizer{
+		policy: policy,
+		logger: logger,
+	}
+}
+
+// WithAuthorization authorizes the messages of a JSONResolver route with the Authorizer before they are validated and handled.
+// It runs before the other route options, e.g. WithRateLimit, whatever their order.
+// The principal must meet all the requirements, e.g. WithAuthorization(a, RequireRole("admin")).
+// Without requirements, only the policy is applied.
+func WithAuthorization(a *Authorizer, requirements ...Requirement) RouteOption {
+	return func(c *routeConfig) {
+		c.authorizers = append(c.authorizers, func(name string, next Handler) Handler {
+			return func(ctx context.Context, msg []byte, rw ResponseWriter) error {
+				if err := a.authorize(ctx, name, requirements, msg, rw); err != nil {
+					return err
+				}
+				return next(ctx, msg, rw)
+			}
+		})
+	}
+}
+
+// Stats returns the number of allowed and denied messages.
+func (a *Authorizer) Stats() AuthorizerStats {
+	return AuthorizerStats{
+		Allowed: atomic.LoadUint64(&a.allowed),
+		Denied:  atomic.LoadUint64(&a.denied),
+	}
+}
+
+func (a *Authorizer) authorize(ctx context.Context, route string, requirements []Requirement, msg []byte, rw ResponseWriter) error {
+	principal, authenticated := PrincipalFromContext(ctx)
+	msgType, ok := messageTypeFromContext(ctx)
+	if !ok {
+		msgType = route
+	}
+	reason := a.policy(ctx, AuthorizationRequest{
+		Route:         route,
+		MessageType:   msgType,
+		Principal:     principal,
+		Authenticated: authenticated,
+		Requirements:  requirements,
+		Message:       msg,
+	})
+	if reason == nil {
+		atomic.AddUint64(&a.allowed, 1)
+		return nil
+	}
+
+	atomic.AddUint64(&a.denied, 1)
+	a.logger.Printf("authorization denied: principal %q, message type %q: %v", principal.ID, msgType, reason)
+
+	if err := WriteErrorReply(rw, NewErrorReply(ErrorCodeForbidden, "not authorized", route, nil)); err != nil {
+		return fmt.Errorf("failed to write error reply: %w", err)
+	}
+	return fmt.Errorf("%w: %v", ErrForbidden, reason)
+}
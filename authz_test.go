@@ -0,0 +1,137 @@
+package wsocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testLogger records the logged lines.
+type testLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *testLogger) Printf(format string, v ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, fmt.Sprintf(format, v...))
+}
+
+func TestRequirement_SatisfiedBy(t *testing.T) {
+	principal := Principal{ID: "alice", Roles: []string{"editor"}, Permissions: []string{"read", "write"}}
+
+	assert.True(t, RequireRole("admin", "editor").SatisfiedBy(principal))
+	assert.False(t, RequireRole("admin").SatisfiedBy(principal))
+	assert.True(t, RequirePermission("read", "write").SatisfiedBy(principal))
+	assert.False(t, RequirePermission("read", "delete").SatisfiedBy(principal))
+	assert.True(t, Requirement{}.SatisfiedBy(principal))
+	assert.Equal(t, "role admin or editor, permission read and write",
+		Requirement{AnyRole: []string{"admin", "editor"}, Permissions: []string{"read", "write"}}.String())
+}
+
+func TestWithAuthorization(t *testing.T) {
+	tests := []struct {
+		name          string
+		principal     *Principal
+		policy        AuthorizationPolicy
+		expectedAllow bool
+	}{
+		{
+			name:          "admin",
+			principal:     &Principal{ID: "alice", Roles: []string{"admin"}},
+			expectedAllow: true,
+		},
+		{
+			name:      "missing role",
+			principal: &Principal{ID: "bob", Roles: []string{"user"}},
+		},
+		{
+			name: "unauthenticated",
+		},
+		{
+			name:      "custom policy",
+			principal: &Principal{ID: "alice", Roles: []string{"admin"}},
+			policy: func(ctx context.Context, req AuthorizationRequest) error {
+				if err := DefaultAuthorizationPolicy(ctx, req); err != nil {
+					return err
+				}
+				if req.Principal.ID != "carol" {
+					return errors.New("only carol may broadcast")
+				}
+				return nil
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := &testLogger{}
+			authorizer := NewAuthorizer(tt.policy, logger)
+
+			handled := false
+			resolver := NewJSONResolver("type").AddHandler("broadcast-announcement", func(ctx context.Context, msg []byte, rw ResponseWriter) error {
+				handled = true
+				return nil
+			}, WithAuthorization(authorizer, RequireRole("admin")))
+
+			ctx := context.Background()
+			if tt.principal != nil {
+				ctx = context.WithValue(ctx, principalContextKey{}, *tt.principal)
+			}
+			rw := &testResponseWriter{}
+			err := resolver.Handle(ctx, []byte(`{"type":"broadcast-announcement"}`), rw)
+			assert.Equal(t, tt.expectedAllow, handled)
+
+			if tt.expectedAllow {
+				assert.NoError(t, err)
+				assert.Nil(t, rw.msg)
+				assert.Empty(t, logger.lines)
+				assert.Equal(t, AuthorizerStats{Allowed: 1}, authorizer.Stats())
+				return
+			}
+
+			assert.ErrorIs(t, err, ErrForbidden)
+			if assert.NotNil(t, rw.msg) {
+				var reply ErrorReply
+				assert.NoError(t, json.Unmarshal(rw.msg.Message, &reply))
+				assert.Equal(t, ErrorCodeForbidden, reply.Code)
+				assert.Equal(t, "broadcast-announcement", reply.Route)
+			}
+			assert.Len(t, logger.lines, 1, "Expected the denial to be logged")
+			assert.Equal(t, AuthorizerStats{Denied: 1}, authorizer.Stats())
+		})
+	}
+}
+
+func TestWithAuthorization_Outermost(t *testing.T) {
+	logger := &testLogger{}
+	authorizer := NewAuthorizer(nil, logger)
+
+	middlewareCalled := false
+	recordMiddleware := func(c *routeConfig) {
+		c.middlewares = append(c.middlewares, func(_ string, next Handler) Handler {
+			return func(ctx context.Context, msg []byte, rw ResponseWriter) error {
+				middlewareCalled = true
+				return next(ctx, msg, rw)
+			}
+		})
+	}
+
+	resolver := NewJSONResolver("type").AddHandler("order.{action}", func(ctx context.Context, msg []byte, rw ResponseWriter) error {
+		return nil
+	}, recordMiddleware, WithAuthorization(authorizer, RequireRole("admin")))
+
+	err := resolver.Handle(context.Background(), []byte(`{"type":"order.cancel"}`), &testResponseWriter{})
+	assert.ErrorIs(t, err, ErrForbidden)
+	assert.False(t, middlewareCalled, "Expected the denied message not to reach the other route middlewares")
+
+	if assert.Len(t, logger.lines, 1) {
+		assert.Contains(t, logger.lines[0], `message type "order.cancel"`, "Expected the resolved message type to be logged")
+	}
+}
@@ -0,0 +1,178 @@
+package wsocket
+
+import (
+	"bytes"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/valyala/fastjson"
+)
+
+// WriteBatcher coalesces the queued text messages of a connection into a single JSON array message, e.g. [{"price":1.1},{"price":1.2}],
+// to reduce the number of frames and syscalls of busy connections. The peer must split the batches, e.g. with WithBatchUnpacking.
+// Only the text messages that are JSON values and don't override the compression with Message.WithCompression are coalesced,
+// the other messages are sent as they are and end the batch.
+type WriteBatcher struct {
+	maxSize    int
+	maxLatency time.Duration
+
+	batches  uint64
+	messages uint64
+	largest  uint64
+}
+
+// WriteBatchStats holds the number of batches written by a WriteBatcher.
+// A single message that isn't coalesced with others is counted as a batch of one.
+type WriteBatchStats struct {
+	Batches  uint64
+	Messages uint64
+	// Largest is the number of messages of the largest batch.
+	Largest uint64
+}
+
+// NewWriteBatcher creates a new WriteBatcher instance.
+// maxSize is the maximum number of messages in a batch.
+// maxLatency is how long the first message of a batch waits for more messages. If it is 0, only the messages already queued are batched.
+// If maxSize is less than 2 or maxLatency is negative, NewWriteBatcher panics.
+func NewWriteBatcher(maxSize int, maxLatency time.Duration) *WriteBatcher {
+	if maxSize < 2 {
+		panic(fmt.Sprintf("wsocket: invalid write batch size: %d", maxSize))
+	}
+	if maxLatency < 0 {
+		panic(fmt.Sprintf("wsocket: invalid write batch latency: %s", maxLatency))
+	}
+
+	return &WriteBatcher{
+		maxSize:    maxSize,
+		maxLatency: maxLatency,
+	}
+}
+
+// WithBatchUnpacking splits the JSON array messages written by a WriteBatcher into separate messages before they are passed to the middlewares and the resolver.
+// Every JSON array message is split, so it must not be used if the protocol has JSON array messages of its own.
+func WithBatchUnpacking() ClientOption {
+	return func(c *client) {
+		c.batchUnpacking = true
+	}
+}
+
+// WithWriteBatching batches the outgoing messages of every connection with the batcher.
+func WithWriteBatching(batcher *WriteBatcher) ClientOption {
+	return func(c *client) {
+		c.connConfig.batcher = batcher
+	}
+}
+
+// Stats returns the number and sizes of the written batches.
+func (b *WriteBatcher) Stats() WriteBatchStats {
+	return WriteBatchStats{
+		Batches:  atomic.LoadUint64(&b.batches),
+		Messages: atomic.LoadUint64(&b.messages),
+		Largest:  atomic.LoadUint64(&b.largest),
+	}
+}
+
+func (b *WriteBatcher) record(size int) {
+	atomic.AddUint64(&b.batches, 1)
+	atomic.AddUint64(&b.messages, uint64(size))
+	for {
+		largest := atomic.LoadUint64(&b.largest)
+		if uint64(size) <= largest || atomic.CompareAndSwapUint64(&b.largest, largest, uint64(size)) {
+			return
+		}
+	}
+}
+
+// batchable reports whether the message can be coalesced with others.
+func batchable(msg Message) bool {
+	return msg.writer == nil && msg.msgType == websocket.TextMessage && msg.compression == compressionDefault &&
+		fastjson.ValidateBytes(msg.Message) == nil
+}
+
+// writeBatch writes first together with the batchable messages queued after it.
+func (c *connection) writeBatch(first Message) error {
+	var timer <-chan time.Time
+	if c.batcher.maxLatency > 0 {
+		t := time.NewTimer(c.batcher.maxLatency)
+		defer t.Stop()
+		timer = t.C
+	}
+
+	batch := []Message{first}
+	var next *Message
+	for len(batch) < c.batcher.maxSize {
+		msg, ok := c.nextMessage(timer != nil, timer)
+		if !ok {
+			break
+		}
+		if !batchable(msg) {
+			next = &msg
+			break
+		}
+		batch = append(batch, msg)
+	}
+
+	c.batcher.record(len(batch))
+	if err := c.write(coalesce(batch)); err != nil {
+		return err
+	}
+
+	if next != nil {
+		return c.write(*next)
+	}
+	return nil
+}
+
+// unpackBatch splits a JSON array message into its elements.
+// It returns false if the message is not a JSON array.
+func unpackBatch(msg []byte) ([][]byte, bool) {
+	trimmed := bytes.TrimLeft(msg, " \t\r\n")
+	if len(trimmed) == 0 || trimmed[0] != '[' {
+		return nil, false
+	}
+
+	parser := jsonParserPool.Get()
+	defer jsonParserPool.Put(parser)
+
+	value, err := parser.ParseBytes(trimmed)
+	if err != nil {
+		return nil, false
+	}
+	items, err := value.Array()
+	if err != nil {
+		return nil, false
+	}
+
+	messages := make([][]byte, 0, len(items))
+	for _, item := range items {
+		messages = append(messages, item.MarshalTo(nil))
+	}
+	return messages, true
+}
+
+// coalesce joins the messages into a JSON array message. A single message is returned as it is.
+func coalesce(batch []Message) Message {
+	if len(batch) == 1 {
+		return batch[0]
+	}
+
+	size := len(batch) + 1
+	for _, msg := range batch {
+		size += len(msg.Message)
+	}
+
+	var buf bytes.Buffer
+	buf.Grow(size)
+	buf.WriteByte('[')
+	for i, msg := range batch {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.Write(msg.Message)
+	}
+	buf.WriteByte(']')
+
+	return NewTextMessage(buf.Bytes())
+}
@@ -0,0 +1,142 @@
+package wsocket
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewWriteBatcher_Invalid(t *testing.T) {
+	assert.Panics(t, func() { NewWriteBatcher(1, 0) })
+	assert.Panics(t, func() { NewWriteBatcher(10, -time.Second) })
+}
+
+func TestCoalesce(t *testing.T) {
+	single := NewTextMessage([]byte(`{"a":1}`))
+	assert.Equal(t, single, coalesce([]Message{single}))
+
+	batch := coalesce([]Message{single, NewTextMessage([]byte(`{"b":2}`)), NewTextMessage([]byte(`"c"`))})
+	assert.Equal(t, NewTextMessage([]byte(`[{"a":1},{"b":2},"c"]`)), batch)
+}
+
+func TestUnpackBatch(t *testing.T) {
+	tests := []struct {
+		name             string
+		msg              string
+		expectedMessages []string
+		expectedOK       bool
+	}{
+		{
+			name:             "batch",
+			msg:              ` [{"a":1}, {"b":[2,3]}, "c"]`,
+			expectedMessages: []string{`{"a":1}`, `{"b":[2,3]}`, `"c"`},
+			expectedOK:       true,
+		},
+		{
+			name:       "object",
+			msg:        `{"a":1}`,
+			expectedOK: false,
+		},
+		{
+			name:       "invalid json",
+			msg:        `[{"a":1}`,
+			expectedOK: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			messages, ok := unpackBatch([]byte(tt.msg))
+			assert.Equal(t, tt.expectedOK, ok)
+
+			var actual []string
+			for _, msg := range messages {
+				actual = append(actual, string(msg))
+			}
+			assert.Equal(t, tt.expectedMessages, actual)
+		})
+	}
+}
+
+func TestConnection_WriteBatching_JSONArray(t *testing.T) {
+	received := make(chan []string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := websocket.Upgrader{}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		assert.NoError(t, err)
+		defer conn.Close()
+
+		messages := make([]string, 0, 4)
+		for i := 0; i < 4; i++ {
+			_, msg, err := conn.ReadMessage()
+			assert.NoError(t, err)
+			messages = append(messages, string(msg))
+		}
+		received <- messages
+	}))
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+server.URL[4:], nil)
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	batcher := NewWriteBatcher(3, 200*time.Millisecond)
+	wsConn := newConnection(NoLogger(), conn, connectionConfig{writeChanSize: 10, batcher: batcher})
+
+	for _, msg := range []string{`{"n":1}`, `{"n":2}`, `{"n":3}`} {
+		assert.NoError(t, wsConn.WriteMessage(NewTextMessage([]byte(msg))))
+	}
+	assert.NoError(t, wsConn.WriteMessage(NewBinaryMessage([]byte("binary"))))
+	assert.NoError(t, wsConn.WriteMessage(NewTextMessage([]byte("plain text"))))
+	assert.NoError(t, wsConn.WriteMessage(NewTextMessage([]byte(`{"n":4}`)).WithCompression(false)))
+
+	select {
+	case messages := <-received:
+		assert.Equal(t, []string{`[{"n":1},{"n":2},{"n":3}]`, "binary", "plain text", `{"n":4}`}, messages)
+	case <-time.After(time.Second):
+		t.Fatal("Expected the server to receive the messages")
+	}
+	assert.Equal(t, WriteBatchStats{Batches: 1, Messages: 3, Largest: 3}, batcher.Stats())
+}
+
+func TestHandleConnection_BatchUnpacking(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := websocket.Upgrader{}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		assert.NoError(t, err)
+		defer conn.Close()
+
+		err = conn.WriteMessage(websocket.TextMessage, []byte(`[{"type":"tick"},{"type":"tick"}]`))
+		assert.NoError(t, err)
+		err = conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"tick"}`))
+		assert.NoError(t, err)
+
+		_, _, _ = conn.ReadMessage()
+	}))
+	defer server.Close()
+
+	clientConn, _, err := websocket.DefaultDialer.Dial("ws"+server.URL[4:], nil)
+	assert.NoError(t, err)
+	defer clientConn.Close()
+
+	var calls int32
+	resolver := NewJSONResolver("type").
+		AddHandler("tick", func(ctx context.Context, msg []byte, rw ResponseWriter) error {
+			atomic.AddInt32(&calls, 1)
+			return nil
+		})
+
+	client := NewClient(context.Background(), resolver, NoLogger(), 10, WithBatchUnpacking())
+	conn := client.NewConnection(clientConn)
+	defer conn.Close()
+
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&calls) == 3
+	}, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,181 @@
+package wsocket
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// BinaryHeader describes where the opcode is located in a binary frame.
+type BinaryHeader struct {
+	// Offset is the number of bytes preceding the opcode.
+	Offset int
+	// Width is the opcode size in bytes. It must be between 1 and 4.
+	Width int
+	// LittleEndian reads the opcode in little-endian byte order. Big-endian (network order) is used by default.
+	LittleEndian bool
+	// Strip removes the first Offset+Width bytes from the message before it is passed to the handler.
+	Strip bool
+}
+
+// BinaryResolver resolves binary messages by an opcode read from the message header.
+type BinaryResolver struct {
+	mu sync.RWMutex
+
+	header         BinaryHeader
+	handlers       map[uint32]Handler
+	streamHandlers map[uint32]StreamHandler
+	rangeHandlers  []binaryRangeHandler
+	defaultHandler Handler
+}
+
+type binaryRangeHandler struct {
+	from, to uint32
+	handler  Handler
+}
+
+type binaryOpcodeContextKey struct{}
+
+// NewBinaryResolver creates a new BinaryResolver instance.
+// header describes the opcode layout. For example, BinaryHeader{Width: 2} reads the opcode from the first two bytes in big-endian order.
+// If header.Width is not between 1 and 4, or header.Offset is negative, NewBinaryResolver panics.
+func NewBinaryResolver(header BinaryHeader) *BinaryResolver {
+	if header.Width < 1 || header.Width > 4 {
+		panic(fmt.Sprintf("wsocket: invalid binary header width: %d", header.Width))
+	}
+	if header.Offset < 0 {
+		panic(fmt.Sprintf("wsocket: invalid binary header offset: %d", header.Offset))
+	}
+
+	return &BinaryResolver{
+		header:         header,
+		handlers:       make(map[uint32]Handler),
+		streamHandlers: make(map[uint32]StreamHandler),
+	}
+}
+
+// AddHandler adds a handler for an opcode.
+func (r *BinaryResolver) AddHandler(opcode uint32, handler Handler) *BinaryResolver {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.handlers[opcode] = handler
+
+	return r
+}
+
+// AddStreamHandler adds a handler reading the messages with the opcode as a stream, e.g. file uploads.
+// Stream handlers are used only if the resolver is passed to WithStreaming as the StreamSelector.
+func (r *BinaryResolver) AddStreamHandler(opcode uint32, handler StreamHandler) *BinaryResolver {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.streamHandlers[opcode] = handler
+
+	return r
+}
+
+// AddRangeHandler adds a handler for all opcodes between from and to inclusive.
+// Exact handlers added by AddHandler take precedence over ranges.
+// Overlapping ranges are checked in the order they are added.
+func (r *BinaryResolver) AddRangeHandler(from, to uint32, handler Handler) *BinaryResolver {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rangeHandlers = append(r.rangeHandlers, binaryRangeHandler{from: from, to: to, handler: handler})
+
+	return r
+}
+
+// SetDefaultHandler sets a handler for opcodes that don't match any other handler.
+func (r *BinaryResolver) SetDefaultHandler(handler Handler) *BinaryResolver {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.defaultHandler = handler
+
+	return r
+}
+
+func (r *BinaryResolver) Handle(ctx context.Context, msg []byte, rw ResponseWriter) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	end := r.header.Offset + r.header.Width
+	if len(msg) < end {
+		return fmt.Errorf("message is too short to contain an opcode: %d bytes", len(msg))
+	}
+
+	opcode := r.readOpcode(msg[r.header.Offset:end])
+
+	handler := r.resolve(opcode)
+	if handler == nil {
+		return fmt.Errorf("unknown opcode %d", opcode)
+	}
+
+	if r.header.Strip {
+		msg = msg[end:]
+	}
+
+	return handler(context.WithValue(ctx, binaryOpcodeContextKey{}, opcode), msg, rw)
+}
+
+// HeaderSize implements StreamSelector.
+func (r *BinaryResolver) HeaderSize() int {
+	return r.header.Offset + r.header.Width
+}
+
+// Select implements StreamSelector. It returns the stream handler added for the opcode of the message.
+func (r *BinaryResolver) Select(_ int, header []byte) StreamHandler {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	end := r.header.Offset + r.header.Width
+	if len(header) < end {
+		return nil
+	}
+
+	opcode := r.readOpcode(header[r.header.Offset:end])
+	handler, ok := r.streamHandlers[opcode]
+	if !ok {
+		return nil
+	}
+
+	strip := r.header.Strip
+	return func(ctx context.Context, body io.Reader, rw ResponseWriter) error {
+		if strip {
+			if _, err := io.CopyN(io.Discard, body, int64(end)); err != nil {
+				return err
+			}
+		}
+		return handler(context.WithValue(ctx, binaryOpcodeContextKey{}, opcode), body, rw)
+	}
+}
+
+func (r *BinaryResolver) resolve(opcode uint32) Handler {
+	if handler, ok := r.handlers[opcode]; ok {
+		return handler
+	}
+	for _, rh := range r.rangeHandlers {
+		if opcode >= rh.from && opcode <= rh.to {
+			return rh.handler
+		}
+	}
+	return r.defaultHandler
+}
+
+func (r *BinaryResolver) readOpcode(b []byte) uint32 {
+	var opcode uint32
+	for i := range b {
+		if r.header.LittleEndian {
+			opcode |= uint32(b[i]) << (8 * i)
+		} else {
+			opcode = opcode<<8 | uint32(b[i])
+		}
+	}
+	return opcode
+}
+
+// BinaryOpcodeFromContext returns the opcode resolved by a BinaryResolver.
+// It is useful when a single handler is registered for a range of opcodes or as the default handler.
+func BinaryOpcodeFromContext(ctx context.Context) (uint32, bool) {
+	opcode, ok := ctx.Value(binaryOpcodeContextKey{}).(uint32)
+	return opcode, ok
+}
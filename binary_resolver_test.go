@@ -0,0 +1,126 @@
+package wsocket
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBinaryResolver_Handle(t *testing.T) {
+	writeOpcode := func(name string) Handler {
+		return func(ctx context.Context, msg []byte, rw ResponseWriter) error {
+			opcode, ok := BinaryOpcodeFromContext(ctx)
+			assert.True(t, ok)
+			return rw.WriteMessage(NewBinaryMessage([]byte(fmt.Sprintf("%s:%d:%x", name, opcode, msg))))
+		}
+	}
+
+	tests := []struct {
+		name           string
+		header         BinaryHeader
+		inputMessage   []byte
+		expectedResult string
+		expectedError  bool
+	}{
+		{
+			name:           "Single Byte Opcode",
+			header:         BinaryHeader{Width: 1},
+			inputMessage:   []byte{0x01, 0xaa},
+			expectedResult: "exact:1:01aa",
+		},
+		{
+			name:           "Big Endian Opcode",
+			header:         BinaryHeader{Width: 2},
+			inputMessage:   []byte{0x00, 0x01, 0xaa},
+			expectedResult: "exact:1:0001aa",
+		},
+		{
+			name:           "Little Endian Opcode",
+			header:         BinaryHeader{Width: 2, LittleEndian: true},
+			inputMessage:   []byte{0x01, 0x00, 0xaa},
+			expectedResult: "exact:1:0100aa",
+		},
+		{
+			name:           "Offset And Strip",
+			header:         BinaryHeader{Offset: 1, Width: 4, Strip: true},
+			inputMessage:   []byte{0xff, 0x00, 0x00, 0x00, 0x01, 0xaa, 0xbb},
+			expectedResult: "exact:1:aabb",
+		},
+		{
+			name:           "Range",
+			header:         BinaryHeader{Width: 1, Strip: true},
+			inputMessage:   []byte{0x15, 0xaa},
+			expectedResult: "range:21:aa",
+		},
+		{
+			name:           "Default",
+			header:         BinaryHeader{Width: 1, Strip: true},
+			inputMessage:   []byte{0x30},
+			expectedResult: "default:48:",
+		},
+		{
+			name:          "Too Short",
+			header:        BinaryHeader{Offset: 1, Width: 2},
+			inputMessage:  []byte{0x00, 0x01},
+			expectedError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resolver := NewBinaryResolver(test.header).
+				AddHandler(1, writeOpcode("exact")).
+				AddRangeHandler(0x10, 0x1f, writeOpcode("range")).
+				SetDefaultHandler(writeOpcode("default"))
+
+			rw := &testResponseWriter{}
+
+			err := resolver.Handle(context.Background(), test.inputMessage, rw)
+			if test.expectedError {
+				assert.Error(t, err, "Expected an error")
+			} else {
+				assert.NoError(t, err, "Expected no error")
+				message := rw.GetWrittenMessage()
+				assert.NotNilf(t, message, "Expected a message to be written")
+				assert.Equal(t, test.expectedResult, string(message.Message))
+			}
+		})
+	}
+
+	t.Run("Unknown Opcode Without Default", func(t *testing.T) {
+		resolver := NewBinaryResolver(BinaryHeader{Width: 1}).AddHandler(1, writeOpcode("exact"))
+		err := resolver.Handle(context.Background(), []byte{0x02}, &testResponseWriter{})
+		assert.Error(t, err)
+	})
+
+	t.Run("Invalid Width", func(t *testing.T) {
+		assert.Panics(t, func() { NewBinaryResolver(BinaryHeader{Width: 5}) })
+	})
+}
+
+func TestBinaryResolver_Select(t *testing.T) {
+	var gotOpcode uint32
+	var gotBody []byte
+	resolver := NewBinaryResolver(BinaryHeader{Width: 2, Strip: true}).
+		AddStreamHandler(0x0102, func(ctx context.Context, r io.Reader, rw ResponseWriter) error {
+			gotOpcode, _ = BinaryOpcodeFromContext(ctx)
+			var err error
+			gotBody, err = io.ReadAll(r)
+			return err
+		})
+
+	assert.Equal(t, 2, resolver.HeaderSize())
+	assert.Nil(t, resolver.Select(websocket.BinaryMessage, []byte{0x01}), "Expected no handler for a short header")
+	assert.Nil(t, resolver.Select(websocket.BinaryMessage, []byte{0x01, 0x03}), "Expected no handler for an unknown opcode")
+
+	handler := resolver.Select(websocket.BinaryMessage, []byte{0x01, 0x02})
+	assert.NotNil(t, handler)
+	assert.NoError(t, handler(context.Background(), bytes.NewReader([]byte{0x01, 0x02, 'h', 'i'}), &testResponseWriter{}))
+	assert.Equal(t, uint32(0x0102), gotOpcode)
+	assert.Equal(t, []byte("hi"), gotBody)
+}
@@ -0,0 +1,119 @@
+package wsocket
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+)
+
+// Broker distributes published messages between the nodes of a cluster, e.g. several replicas behind a load balancer.
+// Adapters for Redis, NATS and PostgreSQL are available as separate modules in the broker directory,
+// e.g. github.com/jaxmef/wsocket/broker/redisbroker.
+type Broker interface {
+	// Publish sends msg to every node subscribed to the broker, including the publishing one.
+	Publish(ctx context.Context, msg BrokerMessage) error
+	// Subscribe calls handler for every message published by any node until ctx is canceled.
+	// The subscription is active when Subscribe returns.
+	Subscribe(ctx context.Context, handler func(msg BrokerMessage)) error
+}
+
+// BrokerMessage is a message distributed by a Broker.
+type BrokerMessage struct {
+	// NodeID identifies the node that published the message, so the node can ignore its own messages.
+	NodeID string
+	// Topic is the topic the message is published to.
+	Topic string
+	// Message is the published message.
+	Message Message
+}
+
+type brokerMessageJSON struct {
+	NodeID  string `json:"node"`
+	Topic   string `json:"topic"`
+	MsgType int    `json:"msg_type"`
+	Data    []byte `json:"data"`
+}
+
+// MarshalBinary encodes the message to be sent by a Broker.
+// The encoding is JSON, so it can be used by text-only transports.
+func (m BrokerMessage) MarshalBinary() ([]byte, error) {
+	return json.Marshal(brokerMessageJSON{
+		NodeID:  m.NodeID,
+		Topic:   m.Topic,
+		MsgType: m.Message.msgType,
+		Data:    m.Message.Message,
+	})
+}
+
+// UnmarshalBinary decodes a message encoded by MarshalBinary.
+func (m *BrokerMessage) UnmarshalBinary(data []byte) error {
+	decoded := brokerMessageJSON{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+
+	m.NodeID = decoded.NodeID
+	m.Topic = decoded.Topic
+	m.Message = Message{msgType: decoded.MsgType, Message: decoded.Data}
+	return nil
+}
+
+// NewNodeID returns a random node ID.
+func NewNodeID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// InProcessBroker is a Broker delivering messages within a single process.
+// It connects several PubSub instances of the same process and is useful for tests.
+type InProcessBroker struct {
+	mu       sync.RWMutex
+	nextID   int
+	handlers map[int]func(msg BrokerMessage)
+}
+
+// NewInProcessBroker creates a new InProcessBroker instance.
+func NewInProcessBroker() *InProcessBroker {
+	return &InProcessBroker{
+		handlers: make(map[int]func(msg BrokerMessage)),
+	}
+}
+
+// Publish calls every subscribed handler synchronously.
+func (b *InProcessBroker) Publish(_ context.Context, msg BrokerMessage) error {
+	b.mu.RLock()
+	handlers := make([]func(msg BrokerMessage), 0, len(b.handlers))
+	for _, handler := range b.handlers {
+		handlers = append(handlers, handler)
+	}
+	b.mu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(msg)
+	}
+
+	return nil
+}
+
+func (b *InProcessBroker) Subscribe(ctx context.Context, handler func(msg BrokerMessage)) error {
+	b.mu.Lock()
+	id := b.nextID
+	b.nextID++
+	b.handlers[id] = handler
+	b.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+
+		b.mu.Lock()
+		delete(b.handlers, id)
+		b.mu.Unlock()
+	}()
+
+	return nil
+}
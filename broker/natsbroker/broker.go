@@ -0,0 +1,69 @@
+// Package natsbroker implements wsocket.Broker on top of NATS.
+package natsbroker
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jaxmef/wsocket"
+	"github.com/nats-io/nats.go"
+)
+
+// Broker distributes messages through a NATS subject.
+type Broker struct {
+	conn    *nats.Conn
+	subject string
+	logger  wsocket.Logger
+}
+
+// New creates a new Broker instance.
+// conn is used to publish and subscribe, subject is the NATS subject shared by all nodes.
+// logger is used to log invalid messages. If nil, a default logger is used.
+func New(conn *nats.Conn, subject string, logger wsocket.Logger) *Broker {
+	if logger == nil {
+		logger = wsocket.DefaultLogger()
+	}
+
+	return &Broker{
+		conn:    conn,
+		subject: subject,
+		logger:  logger,
+	}
+}
+
+func (b *Broker) Publish(_ context.Context, msg wsocket.BrokerMessage) error {
+	data, err := msg.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	return b.conn.Publish(b.subject, data)
+}
+
+func (b *Broker) Subscribe(ctx context.Context, handler func(msg wsocket.BrokerMessage)) error {
+	sub, err := b.conn.Subscribe(b.subject, func(natsMsg *nats.Msg) {
+		msg := wsocket.BrokerMessage{}
+		if err := msg.UnmarshalBinary(natsMsg.Data); err != nil {
+			b.logger.Printf("failed to decode broker message: %v", err)
+			return
+		}
+		handler(msg)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to subject %q: %w", b.subject, err)
+	}
+	// Make sure the server registered the subscription before returning.
+	if err := b.conn.Flush(); err != nil {
+		_ = sub.Unsubscribe()
+		return fmt.Errorf("failed to subscribe to subject %q: %w", b.subject, err)
+	}
+
+	go func() {
+		<-ctx.Done()
+		if err := sub.Unsubscribe(); err != nil && err != nats.ErrConnectionClosed {
+			b.logger.Printf("failed to unsubscribe from subject %q: %v", b.subject, err)
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,44 @@
+package natsbroker
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jaxmef/wsocket"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestBroker runs against a local NATS server, e.g. NATS_URL=nats://localhost:4222.
+func TestBroker(t *testing.T) {
+	url := os.Getenv("NATS_URL")
+	if url == "" {
+		t.Skip("NATS_URL is not set")
+	}
+
+	conn, err := nats.Connect(url)
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	broker := New(conn, "wsocket.test", wsocket.NoLogger())
+
+	received := make(chan wsocket.BrokerMessage, 1)
+	assert.NoError(t, broker.Subscribe(ctx, func(msg wsocket.BrokerMessage) {
+		received <- msg
+	}))
+
+	msg := wsocket.BrokerMessage{NodeID: "node-1", Topic: "prices.EURUSD", Message: wsocket.NewTextMessage([]byte("1.1"))}
+	assert.NoError(t, broker.Publish(ctx, msg))
+
+	select {
+	case got := <-received:
+		assert.Equal(t, msg, got)
+	case <-time.After(time.Second):
+		t.Fatal("message not received")
+	}
+}
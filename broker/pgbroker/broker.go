@@ -0,0 +1,90 @@
+// Package pgbroker implements wsocket.Broker on top of PostgreSQL LISTEN/NOTIFY.
+package pgbroker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jaxmef/wsocket"
+)
+
+// MaxPayloadSize is the maximum size of an encoded message, limited by the NOTIFY payload size.
+const MaxPayloadSize = 7999
+
+// Broker distributes messages through a PostgreSQL notification channel.
+// Every subscription holds a dedicated connection taken from the pool.
+type Broker struct {
+	pool    *pgxpool.Pool
+	channel string
+	logger  wsocket.Logger
+}
+
+// New creates a new Broker instance.
+// pool is used to notify and listen, channel is the notification channel shared by all nodes.
+// logger is used to log invalid messages. If nil, a default logger is used.
+func New(pool *pgxpool.Pool, channel string, logger wsocket.Logger) *Broker {
+	if logger == nil {
+		logger = wsocket.DefaultLogger()
+	}
+
+	return &Broker{
+		pool:    pool,
+		channel: channel,
+		logger:  logger,
+	}
+}
+
+// Publish sends the message with pg_notify.
+// Messages larger than MaxPayloadSize after encoding are rejected.
+func (b *Broker) Publish(ctx context.Context, msg wsocket.BrokerMessage) error {
+	data, err := msg.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	if len(data) > MaxPayloadSize {
+		return fmt.Errorf("encoded message is too large: %d bytes", len(data))
+	}
+
+	_, err = b.pool.Exec(ctx, "SELECT pg_notify($1, $2)", b.channel, string(data))
+	return err
+}
+
+func (b *Broker) Subscribe(ctx context.Context, handler func(msg wsocket.BrokerMessage)) error {
+	poolConn, err := b.pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to acquire connection: %w", err)
+	}
+	// The listening connection is removed from the pool, so its LISTEN state never leaks to other users.
+	conn := poolConn.Hijack()
+
+	if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{b.channel}.Sanitize()); err != nil {
+		_ = conn.Close(context.Background())
+		return fmt.Errorf("failed to listen to channel %q: %w", b.channel, err)
+	}
+
+	go func() {
+		defer conn.Close(context.Background())
+
+		for {
+			notification, err := conn.WaitForNotification(ctx)
+			if err != nil {
+				if !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
+					b.logger.Printf("failed to wait for notification on channel %q: %v", b.channel, err)
+				}
+				return
+			}
+
+			msg := wsocket.BrokerMessage{}
+			if err := msg.UnmarshalBinary([]byte(notification.Payload)); err != nil {
+				b.logger.Printf("failed to decode broker message: %v", err)
+				continue
+			}
+			handler(msg)
+		}
+	}()
+
+	return nil
+}
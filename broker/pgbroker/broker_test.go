@@ -0,0 +1,48 @@
+package pgbroker
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jaxmef/wsocket"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestBroker runs against a local PostgreSQL server, e.g. DATABASE_URL=postgres://postgres@localhost:5432/postgres.
+func TestBroker(t *testing.T) {
+	url := os.Getenv("DATABASE_URL")
+	if url == "" {
+		t.Skip("DATABASE_URL is not set")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pool, err := pgxpool.New(ctx, url)
+	assert.NoError(t, err)
+	defer pool.Close()
+
+	broker := New(pool, "wsocket_test", wsocket.NoLogger())
+
+	received := make(chan wsocket.BrokerMessage, 1)
+	assert.NoError(t, broker.Subscribe(ctx, func(msg wsocket.BrokerMessage) {
+		received <- msg
+	}))
+
+	msg := wsocket.BrokerMessage{NodeID: "node-1", Topic: "prices.EURUSD", Message: wsocket.NewTextMessage([]byte("1.1"))}
+	assert.NoError(t, broker.Publish(ctx, msg))
+
+	select {
+	case got := <-received:
+		assert.Equal(t, msg, got)
+	case <-time.After(time.Second):
+		t.Fatal("message not received")
+	}
+
+	tooLarge := wsocket.BrokerMessage{Topic: "prices", Message: wsocket.NewTextMessage([]byte(strings.Repeat("a", MaxPayloadSize)))}
+	assert.Error(t, broker.Publish(ctx, tooLarge))
+}
@@ -0,0 +1,75 @@
+// Package redisbroker implements wsocket.Broker on top of Redis pub/sub.
+package redisbroker
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jaxmef/wsocket"
+	"github.com/redis/go-redis/v9"
+)
+
+// Broker distributes messages through a Redis pub/sub channel.
+type Broker struct {
+	client  redis.UniversalClient
+	channel string
+	logger  wsocket.Logger
+}
+
+// New creates a new Broker instance.
+// client is used to publish and subscribe, channel is the Redis channel shared by all nodes.
+// logger is used to log invalid messages. If nil, a default logger is used.
+func New(client redis.UniversalClient, channel string, logger wsocket.Logger) *Broker {
+	if logger == nil {
+		logger = wsocket.DefaultLogger()
+	}
+
+	return &Broker{
+		client:  client,
+		channel: channel,
+		logger:  logger,
+	}
+}
+
+func (b *Broker) Publish(ctx context.Context, msg wsocket.BrokerMessage) error {
+	data, err := msg.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	return b.client.Publish(ctx, b.channel, data).Err()
+}
+
+func (b *Broker) Subscribe(ctx context.Context, handler func(msg wsocket.BrokerMessage)) error {
+	sub := b.client.Subscribe(ctx, b.channel)
+	// Wait for the subscription confirmation, so no message published afterwards is missed.
+	if _, err := sub.Receive(ctx); err != nil {
+		_ = sub.Close()
+		return fmt.Errorf("failed to subscribe to channel %q: %w", b.channel, err)
+	}
+
+	go func() {
+		defer sub.Close()
+
+		ch := sub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case redisMsg, ok := <-ch:
+				if !ok {
+					return
+				}
+
+				msg := wsocket.BrokerMessage{}
+				if err := msg.UnmarshalBinary([]byte(redisMsg.Payload)); err != nil {
+					b.logger.Printf("failed to decode broker message: %v", err)
+					continue
+				}
+				handler(msg)
+			}
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,106 @@
+package redisbroker
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/jaxmef/wsocket"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBroker(t *testing.T) {
+	server := miniredis.RunT(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+
+	broker := New(client, "wsocket", wsocket.NoLogger())
+
+	var mu sync.Mutex
+	received := make([]wsocket.BrokerMessage, 0)
+	err := broker.Subscribe(ctx, func(msg wsocket.BrokerMessage) {
+		mu.Lock()
+		defer mu.Unlock()
+		received = append(received, msg)
+	})
+	assert.NoError(t, err)
+
+	// Invalid messages published by other clients are skipped.
+	assert.NoError(t, client.Publish(ctx, "wsocket", "invalid").Err())
+
+	msg := wsocket.BrokerMessage{NodeID: "node-1", Topic: "prices.EURUSD", Message: wsocket.NewTextMessage([]byte("1.1"))}
+	assert.NoError(t, broker.Publish(ctx, msg))
+
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(received) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	mu.Lock()
+	assert.Equal(t, msg, received[0])
+	mu.Unlock()
+}
+
+func TestBroker_PubSub(t *testing.T) {
+	server := miniredis.RunT(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	newNode := func(nodeID string) *wsocket.PubSub {
+		client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+		t.Cleanup(func() { _ = client.Close() })
+
+		ps := wsocket.NewPubSub(wsocket.NoLogger())
+		assert.NoError(t, ps.UseBroker(ctx, New(client, "wsocket", wsocket.NoLogger()), nodeID))
+		return ps
+	}
+
+	node1 := newNode("node-1")
+	node2 := newNode("node-2")
+
+	conn := &testConnection{closed: make(chan struct{})}
+	assert.NoError(t, node2.Subscribe(conn, "prices.*"))
+
+	assert.Equal(t, 0, node1.Publish("prices.EURUSD", wsocket.NewTextMessage([]byte("1.1"))))
+
+	assert.Eventually(t, func() bool {
+		return conn.count() == 1
+	}, time.Second, 10*time.Millisecond)
+}
+
+type testConnection struct {
+	mu       sync.Mutex
+	messages []wsocket.Message
+	closed   chan struct{}
+}
+
+func (c *testConnection) WriteMessage(msg wsocket.Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.messages = append(c.messages, msg)
+	return nil
+}
+
+func (c *testConnection) Close() error {
+	close(c.closed)
+	return nil
+}
+
+func (c *testConnection) Wait() <-chan struct{} {
+	return c.closed
+}
+
+func (c *testConnection) count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.messages)
+}
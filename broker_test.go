@@ -0,0 +1,118 @@
+package wsocket
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBrokerMessage_MarshalBinary(t *testing.T) {
+	msg := BrokerMessage{NodeID: "node-1", Topic: "prices.EURUSD", Message: NewBinaryMessage([]byte{0x01, 0x02})}
+
+	data, err := msg.MarshalBinary()
+	assert.NoError(t, err)
+
+	decoded := BrokerMessage{}
+	assert.NoError(t, decoded.UnmarshalBinary(data))
+	assert.Equal(t, msg, decoded)
+	assert.Equal(t, websocket.BinaryMessage, decoded.Message.msgType)
+
+	assert.Error(t, decoded.UnmarshalBinary([]byte("invalid")))
+}
+
+func TestPubSub_UseBroker(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	broker := NewInProcessBroker()
+
+	node1 := NewPubSub(NoLogger())
+	assert.NoError(t, node1.UseBroker(ctx, broker, "node-1"))
+	node2 := NewPubSub(NoLogger())
+	assert.NoError(t, node2.UseBroker(ctx, broker, ""))
+
+	conn1 := newTestConnection()
+	conn2 := newTestConnection()
+	assert.NoError(t, node1.Subscribe(conn1, "prices.*"))
+	assert.NoError(t, node2.Subscribe(conn2, "prices.*"))
+
+	assert.Equal(t, 1, node1.Publish("prices.EURUSD", NewTextMessage([]byte("1.1"))))
+	assert.Equal(t, 1, node2.Publish("prices.GBPUSD", NewTextMessage([]byte("1.3"))))
+
+	assert.Eventually(t, func() bool {
+		return len(conn1.Messages()) == 2 && len(conn2.Messages()) == 2
+	}, time.Second, 10*time.Millisecond)
+	assert.ElementsMatch(t, []string{"1.1", "1.3"}, conn1.Messages(), "Expected no echo of own messages")
+	assert.ElementsMatch(t, []string{"1.1", "1.3"}, conn2.Messages(), "Expected no echo of own messages")
+
+	cancel()
+	assert.Eventually(t, func() bool {
+		node1.mu.RLock()
+		defer node1.mu.RUnlock()
+		return node1.brokerQueue == nil
+	}, time.Second, 10*time.Millisecond, "Expected publishing through the broker to stop")
+	assert.Equal(t, 1, node1.Publish("prices.EURUSD", NewTextMessage([]byte("1.2"))))
+	time.Sleep(50 * time.Millisecond)
+	assert.Len(t, conn2.Messages(), 2)
+}
+
+// blockingBroker is a Broker whose Publish blocks until its context is done.
+type blockingBroker struct {
+	publishing chan struct{}
+	published  chan error
+}
+
+func (b *blockingBroker) Publish(ctx context.Context, _ BrokerMessage) error {
+	select {
+	case b.publishing <- struct{}{}:
+	default:
+	}
+	<-ctx.Done()
+	b.published <- ctx.Err()
+	return ctx.Err()
+}
+
+func (b *blockingBroker) Subscribe(context.Context, func(msg BrokerMessage)) error {
+	return nil
+}
+
+func TestPubSub_UseBroker_Blocking(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	broker := &blockingBroker{publishing: make(chan struct{}, 1), published: make(chan error, 1)}
+
+	ps := NewPubSub(NoLogger())
+	assert.NoError(t, ps.UseBroker(ctx, broker, "node-1"))
+
+	conn := newTestConnection()
+	assert.NoError(t, ps.Subscribe(conn, "prices.*"))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 2*brokerQueueSize; i++ {
+			ps.Publish("prices.EURUSD", NewTextMessage([]byte("1.1")))
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected Publish not to wait for the broker")
+	}
+	assert.Len(t, conn.Messages(), 2*brokerQueueSize)
+
+	select {
+	case <-broker.publishing:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the message to be published to the broker")
+	}
+	cancel()
+	select {
+	case err := <-broker.published:
+		assert.ErrorIs(t, err, context.Canceled, "Expected the broker publishing to be canceled with the UseBroker context")
+	case <-time.After(time.Second):
+		t.Fatal("Expected the broker publishing to be canceled")
+	}
+}
@@ -0,0 +1,81 @@
+package wsocket
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// CBORResolver resolves CBOR encoded messages by the value of a map field.
+// It mirrors JSONResolver, but reads binary CBOR frames directly.
+type CBORResolver struct {
+	mu sync.RWMutex
+
+	field    string
+	handlers map[string]Handler
+}
+
+// NewCBORResolver creates a new CBORResolver instance.
+// field is the name of the map key in the CBOR message that is used to resolve the handler.
+// For example, if field is "t", the message {"t": "temp", "v": 21.5} is resolved to the handler registered for "temp".
+// If the field is nested, use dot notation, e.g. "meta.t".
+// Integer field values are matched by their decimal representation, e.g. {"t": 7} is resolved to the handler registered for "7".
+func NewCBORResolver(field string) *CBORResolver {
+	return &CBORResolver{
+		field:    field,
+		handlers: make(map[string]Handler),
+	}
+}
+
+// AddHandler adds a handler for a message type.
+// name is the value of the field that is used to resolve the handler.
+func (r *CBORResolver) AddHandler(name string, handler Handler) *CBORResolver {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.handlers[name] = handler
+
+	return r
+}
+
+func (r *CBORResolver) Handle(ctx context.Context, msg []byte, rw ResponseWriter) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	fieldValue, ok := cborGetString(msg, strings.Split(r.field, "."))
+	if !ok || fieldValue == "" {
+		return fmt.Errorf("failed to get field %q from message", r.field)
+	}
+
+	handler, ok := r.handlers[fieldValue]
+	if !ok {
+		return fmt.Errorf("unknown message type '%q'", fieldValue)
+	}
+
+	return handler(ctx, msg, rw)
+}
+
+// cborGetString walks the path through nested CBOR maps, decoding only the maps on the path.
+func cborGetString(msg []byte, path []string) (string, bool) {
+	raw := cbor.RawMessage(msg)
+	for _, key := range path {
+		var m map[string]cbor.RawMessage
+		if err := cbor.Unmarshal(raw, &m); err != nil {
+			return "", false
+		}
+		var ok bool
+		raw, ok = m[key]
+		if !ok {
+			return "", false
+		}
+	}
+
+	var value interface{}
+	if err := cbor.Unmarshal(raw, &value); err != nil {
+		return "", false
+	}
+
+	return discriminatorString(value)
+}
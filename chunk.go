@@ -0,0 +1,170 @@
+package wsocket
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Chunked messages are sent as binary messages starting with a header:
+// the chunkMagic bytes, the message ID and the chunk index as big-endian uint32, and the flags byte.
+const (
+	chunkMagic      = "\xffWSC"
+	chunkHeaderSize = len(chunkMagic) + 4 + 4 + 1
+
+	chunkFlagFinal = 1
+)
+
+// DefaultChunkSize is the chunk size used by WriteChunked if chunkSize is not positive.
+const DefaultChunkSize = 32 * 1024
+
+// ErrChunkedMessageTooBig is returned when a reassembled message exceeds the limit set by WithChunkReassembly.
+var ErrChunkedMessageTooBig = errors.New("chunked message too big")
+
+var chunkedMessageID uint32
+
+// WriteChunked reads r to the end and writes it as a sequence of binary chunk messages of at most chunkSize bytes of payload.
+// A Client created with WithChunkReassembly reassembles the chunks and handles them as a single message.
+// Chunks are written with WriteMessage, so the messages written in between are interleaved with the chunks.
+func WriteChunked(rw ResponseWriter, r io.Reader, chunkSize int) error {
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+
+	id := atomic.AddUint32(&chunkedMessageID, 1)
+
+	var index uint32
+	var pending []byte
+	for {
+		chunk := make([]byte, chunkHeaderSize+chunkSize)
+		n, err := io.ReadFull(r, chunk[chunkHeaderSize:])
+		eof := errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+		if err != nil && !eof {
+			return err
+		}
+
+		// The pending chunk is final if nothing follows it.
+		if pending != nil {
+			if n == 0 && eof {
+				return writeChunk(rw, pending, id, index, true)
+			}
+			if err := writeChunk(rw, pending, id, index, false); err != nil {
+				return err
+			}
+			index++
+		}
+
+		pending = chunk[:chunkHeaderSize+n]
+		if eof {
+			return writeChunk(rw, pending, id, index, true)
+		}
+	}
+}
+
+func writeChunk(rw ResponseWriter, chunk []byte, id, index uint32, final bool) error {
+	copy(chunk, chunkMagic)
+	binary.BigEndian.PutUint32(chunk[len(chunkMagic):], id)
+	binary.BigEndian.PutUint32(chunk[len(chunkMagic)+4:], index)
+	if final {
+		chunk[chunkHeaderSize-1] = chunkFlagFinal
+	}
+
+	return rw.WriteMessage(NewBinaryMessage(chunk))
+}
+
+// isChunk reports whether the message is a chunk written by WriteChunked. Chunks are binary messages.
+func isChunk(msgType int, msg []byte) bool {
+	return msgType == websocket.BinaryMessage && len(msg) >= chunkHeaderSize && string(msg[:len(chunkMagic)]) == chunkMagic
+}
+
+// chunkedMessageTTL is the time an incomplete chunked message is kept without receiving its next chunk.
+const chunkedMessageTTL = time.Minute
+
+// chunkAssembler reassembles chunked messages of a connection.
+// It is used only by the reading goroutine of the connection.
+type chunkAssembler struct {
+	maxSize  int64
+	size     int64
+	ttl      time.Duration
+	now      func() time.Time
+	messages map[uint32]*chunkedMessage
+}
+
+type chunkedMessage struct {
+	next uint32
+	buf  bytes.Buffer
+	// updated is the time the last chunk was added.
+	updated time.Time
+}
+
+func newChunkAssembler(maxSize int64) *chunkAssembler {
+	return &chunkAssembler{
+		maxSize:  maxSize,
+		ttl:      chunkedMessageTTL,
+		now:      time.Now,
+		messages: make(map[uint32]*chunkedMessage),
+	}
+}
+
+// add adds a chunk and returns the reassembled message once the final chunk is added.
+// The chunks of a message that are out of order are dropped with an error.
+// The incomplete messages that expired are dropped first.
+func (a *chunkAssembler) add(chunk []byte) ([]byte, bool, error) {
+	now := a.now()
+	a.expire(now)
+
+	id := binary.BigEndian.Uint32(chunk[len(chunkMagic):])
+	index := binary.BigEndian.Uint32(chunk[len(chunkMagic)+4:])
+	final := chunk[chunkHeaderSize-1]&chunkFlagFinal != 0
+	payload := chunk[chunkHeaderSize:]
+
+	msg, ok := a.messages[id]
+	if !ok {
+		msg = &chunkedMessage{}
+		a.messages[id] = msg
+	}
+
+	if index != msg.next {
+		a.drop(id)
+		return nil, false, fmt.Errorf("unexpected chunk %d of message %d, expected %d", index, id, msg.next)
+	}
+
+	if a.maxSize > 0 && a.size+int64(len(payload)) > a.maxSize {
+		a.drop(id)
+		return nil, false, ErrChunkedMessageTooBig
+	}
+
+	a.size += int64(len(payload))
+	msg.buf.Write(payload)
+	msg.next++
+	msg.updated = now
+
+	if !final {
+		return nil, false, nil
+	}
+
+	a.drop(id)
+	return msg.buf.Bytes(), true, nil
+}
+
+// expire drops the incomplete messages that didn't receive a chunk within the TTL.
+func (a *chunkAssembler) expire(now time.Time) {
+	for id, msg := range a.messages {
+		if now.Sub(msg.updated) >= a.ttl {
+			a.drop(id)
+		}
+	}
+}
+
+func (a *chunkAssembler) drop(id uint32) {
+	if msg, ok := a.messages[id]; ok {
+		a.size -= int64(msg.buf.Len())
+		delete(a.messages, id)
+	}
+}
@@ -0,0 +1,152 @@
+package wsocket
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteChunked(t *testing.T) {
+	tests := []struct {
+		name           string
+		msg            string
+		chunkSize      int
+		expectedChunks int
+	}{
+		{
+			name:           "empty message",
+			msg:            "",
+			chunkSize:      4,
+			expectedChunks: 1,
+		},
+		{
+			name:           "shorter than a chunk",
+			msg:            "abc",
+			chunkSize:      4,
+			expectedChunks: 1,
+		},
+		{
+			name:           "multiple of the chunk size",
+			msg:            "abcdefgh",
+			chunkSize:      4,
+			expectedChunks: 2,
+		},
+		{
+			name:           "partial last chunk",
+			msg:            "abcdefghij",
+			chunkSize:      4,
+			expectedChunks: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := newTestConnection()
+			assert.NoError(t, WriteChunked(conn, strings.NewReader(tt.msg), tt.chunkSize))
+
+			chunks := conn.Messages()
+			assert.Len(t, chunks, tt.expectedChunks)
+
+			assembler := newChunkAssembler(0)
+			for i, chunk := range chunks {
+				assert.True(t, isChunk(conn.messages[i].msgType, []byte(chunk)))
+				assert.LessOrEqual(t, len(chunk), chunkHeaderSize+tt.chunkSize)
+
+				msg, complete, err := assembler.add([]byte(chunk))
+				assert.NoError(t, err)
+				assert.Equal(t, i == len(chunks)-1, complete)
+				if complete {
+					assert.Equal(t, tt.msg, string(msg))
+				}
+			}
+			assert.Empty(t, assembler.messages)
+		})
+	}
+}
+
+func TestChunkAssembler_Add(t *testing.T) {
+	conn := newTestConnection()
+	assert.NoError(t, WriteChunked(conn, strings.NewReader("abcdefghij"), 4))
+	chunks := conn.Messages()
+
+	assembler := newChunkAssembler(0)
+	_, _, err := assembler.add([]byte(chunks[1]))
+	assert.Error(t, err, "Expected an error for an out of order chunk")
+	assert.Empty(t, assembler.messages)
+
+	assembler = newChunkAssembler(6)
+	_, complete, err := assembler.add([]byte(chunks[0]))
+	assert.NoError(t, err)
+	assert.False(t, complete)
+	_, _, err = assembler.add([]byte(chunks[1]))
+	assert.ErrorIs(t, err, ErrChunkedMessageTooBig)
+	assert.Empty(t, assembler.messages)
+	assert.Equal(t, int64(0), assembler.size)
+}
+
+func TestChunkAssembler_Expire(t *testing.T) {
+	conn := newTestConnection()
+	assert.NoError(t, WriteChunked(conn, strings.NewReader("abcdefghij"), 4))
+	chunks := conn.Messages()
+
+	now := time.Now()
+	assembler := newChunkAssembler(0)
+	assembler.now = func() time.Time { return now }
+
+	_, _, err := assembler.add([]byte(chunks[0]))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), assembler.size)
+
+	now = now.Add(chunkedMessageTTL)
+	_, _, err = assembler.add([]byte(chunks[1]))
+	assert.Error(t, err, "Expected the incomplete message to expire")
+	assert.Empty(t, assembler.messages)
+	assert.Equal(t, int64(0), assembler.size)
+}
+
+func TestIsChunk(t *testing.T) {
+	conn := newTestConnection()
+	assert.NoError(t, WriteChunked(conn, strings.NewReader("abc"), 4))
+	chunk := conn.messages[0].Message
+
+	assert.True(t, isChunk(websocket.BinaryMessage, chunk))
+	assert.False(t, isChunk(websocket.TextMessage, chunk), "Expected text messages not to be chunks")
+	assert.False(t, isChunk(websocket.BinaryMessage, chunk[:chunkHeaderSize-1]))
+}
+
+func TestHandleConnection_ChunkReassembly(t *testing.T) {
+	payload := bytes.Repeat([]byte("0123456789"), 100)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := websocket.Upgrader{}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		assert.NoError(t, err)
+
+		wsConn := newConnection(NoLogger(), conn, connectionConfig{writeChanSize: 10})
+		assert.NoError(t, WriteChunked(wsConn, bytes.NewReader(payload), 64))
+		assert.NoError(t, wsConn.WriteMessage(NewTextMessage(payload)))
+	}))
+	defer server.Close()
+
+	clientConn, _, err := websocket.DefaultDialer.Dial("ws"+server.URL[4:], nil)
+	assert.NoError(t, err)
+	defer clientConn.Close()
+
+	resolver := &testResolver{expectedMessage: payload, t: t}
+	client := NewClient(context.Background(), resolver, NoLogger(), 10, WithChunkReassembly(0))
+	conn := client.NewConnection(clientConn)
+	defer conn.Close()
+
+	assert.Eventually(t, func() bool {
+		resolver.callsMutex.Lock()
+		defer resolver.callsMutex.Unlock()
+		return resolver.calls == 2
+	}, time.Second, 10*time.Millisecond)
+}
@@ -1,14 +1,22 @@
 package wsocket
 
 import (
+	"bufio"
 	"context"
 	"errors"
+	"io"
 	"net"
+	"net/http"
 	"sync"
 
 	"github.com/gorilla/websocket"
 )
 
+// Client creates the connections of a resolver.
+//
+// Connections created by NewConnection don't know the handshake, so the negotiated compression is unknown,
+// see CompressionNegotiated. Pass the handshake header with the package-level NewConnectionWithHandshake instead,
+// the clients created by NewClient implement HandshakeConnector.
 type Client interface {
 	AddMiddleware(middleware Middleware)
 	NewConnection(conn *websocket.Conn) Connection
@@ -18,10 +26,50 @@ type client struct {
 	mu          sync.RWMutex
 	middlewares []Middleware
 
-	ctx           context.Context
-	resolver      Resolver
-	logger        Logger
-	writeChanSize int
+	ctx        context.Context
+	resolver   Resolver
+	logger     Logger
+	connConfig connectionConfig
+
+	readLimit       int64
+	streamSelector  StreamSelector
+	streamReadLimit int64
+
+	chunkReassembly bool
+	chunkMaxSize    int64
+	batchUnpacking  bool
+}
+
+// ClientOption configures a client created by NewClient.
+type ClientOption func(*client)
+
+// WithReadLimit sets the maximum size of a message in bytes.
+// A connection sending a bigger message is closed with websocket.CloseMessageTooBig (1009).
+// By default, the size of messages is not limited.
+func WithReadLimit(limit int64) ClientOption {
+	return func(c *client) {
+		c.readLimit = limit
+	}
+}
+
+// WithStreaming reads the messages selected by selector as a stream and passes them to the selected StreamHandler.
+// limit is the maximum size of a streamed message in bytes, 0 means no limit. It replaces the limit set by WithReadLimit for streamed messages.
+func WithStreaming(selector StreamSelector, limit int64) ClientOption {
+	return func(c *client) {
+		c.streamSelector = selector
+		c.streamReadLimit = limit
+	}
+}
+
+// WithChunkReassembly reassembles the messages written by WriteChunked before they are passed to the middlewares and the resolver.
+// maxSize is the maximum total size in bytes of the incomplete chunked messages of a connection, 0 means no limit.
+// A connection exceeding it is closed with websocket.CloseMessageTooBig (1009).
+// An incomplete message is dropped if none of its chunks is received for a minute.
+func WithChunkReassembly(maxSize int64) ClientOption {
+	return func(c *client) {
+		c.chunkReassembly = true
+		c.chunkMaxSize = maxSize
+	}
 }
 
 type Middleware func(ctx context.Context, msg []byte) (context.Context, []byte, error)
@@ -30,19 +78,24 @@ type Middleware func(ctx context.Context, msg []byte) (context.Context, []byte,
 // ctx is used to cancel the client.
 // resolver is used to resolve incoming messages.
 // logger is used to log errors. If nil, a default logger is used. You can use NoLogger to disable logging.
-func NewClient(ctx context.Context, resolver Resolver, logger Logger, writeChanSize int) Client {
+func NewClient(ctx context.Context, resolver Resolver, logger Logger, writeChanSize int, opts ...ClientOption) Client {
 	if logger == nil {
 		logger = DefaultLogger()
 	}
 
-	return &client{
-		mu:            sync.RWMutex{},
-		ctx:           ctx,
-		resolver:      resolver,
-		logger:        logger,
-		middlewares:   make([]Middleware, 0),
-		writeChanSize: writeChanSize,
+	c := &client{
+		mu:          sync.RWMutex{},
+		ctx:         ctx,
+		resolver:    resolver,
+		logger:      logger,
+		middlewares: make([]Middleware, 0),
+		connConfig:  connectionConfig{writeChanSize: writeChanSize},
 	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
 }
 
 // AddMiddleware adds a middleware to the client.
@@ -57,12 +110,29 @@ func (c *client) AddMiddleware(middleware Middleware) {
 // websocketConn is used to read and write messages.
 // If websocketConn is nil, nil is returned.
 // The connection is automatically closed when the client is canceled.
+// Use NewConnectionWithHandshake if compression is enabled.
 func (c *client) NewConnection(websocketConn *websocket.Conn) Connection {
+	return c.newConnection(websocketConn, c.connConfig)
+}
+
+// NewConnectionWithHandshake creates a new connection instance like NewConnection.
+// header is the handshake header the negotiated compression is read from, see CompressionNegotiated.
+func (c *client) NewConnectionWithHandshake(websocketConn *websocket.Conn, header http.Header) Connection {
+	config := c.connConfig
+	config.handshake = header
+	if config.handshake == nil {
+		config.handshake = http.Header{}
+	}
+	return c.newConnection(websocketConn, config)
+}
+
+func (c *client) newConnection(websocketConn *websocket.Conn, config connectionConfig) Connection {
 	if websocketConn == nil {
 		return nil
 	}
 
-	conn := newConnection(c.logger, websocketConn, c.writeChanSize)
+	websocketConn.SetReadLimit(c.connectionReadLimit())
+	conn := newConnection(c.logger, websocketConn, config)
 
 	go c.handleConnection(conn)
 
@@ -70,6 +140,11 @@ func (c *client) NewConnection(websocketConn *websocket.Conn) Connection {
 }
 
 func (c *client) handleConnection(conn *connection) {
+	var chunks *chunkAssembler
+	if c.chunkReassembly {
+		chunks = newChunkAssembler(c.chunkMaxSize)
+	}
+
 	defer func() {
 		if err := conn.conn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
 			c.logger.Printf("failed to close connection: %v", err)
@@ -82,37 +157,164 @@ func (c *client) handleConnection(conn *connection) {
 		case <-c.ctx.Done():
 			return
 		default:
-			_, msg, err := conn.conn.ReadMessage()
+			msgType, msg, streamed, err := c.readMessage(conn)
 			if err != nil {
 				if errors.Is(err, net.ErrClosed) || websocket.IsCloseError(err, websocket.CloseNormalClosure) {
 					return
 				}
+				if errors.Is(err, websocket.ErrReadLimit) {
+					c.logger.Printf("message exceeds the read limit, closing connection")
+					return
+				}
 				c.logger.Printf("failed to read message: %v", err)
 				return
 			}
+			if streamed {
+				continue
+			}
+
+			if chunks != nil && isChunk(msgType, msg) {
+				var complete bool
+				msg, complete, err = chunks.add(msg)
+				if errors.Is(err, ErrChunkedMessageTooBig) {
+					c.logger.Printf("chunked message exceeds the size limit, closing connection")
+					_ = c.closeMessageTooBig(conn)
+					return
+				}
+				if err != nil {
+					c.logger.Printf("failed to reassemble chunked message: %v", err)
+					continue
+				}
+				if !complete {
+					continue
+				}
+			}
+
+			if c.batchUnpacking {
+				if messages, ok := unpackBatch(msg); ok {
+					for _, msg := range messages {
+						go c.handleMessage(msg, conn)
+					}
+					continue
+				}
+			}
 
 			go c.handleMessage(msg, conn)
 		}
 	}
 }
 
+// connectionReadLimit returns the read limit of the websocket connection, the bigger of the message and the stream limits.
+// The smaller one is enforced by readMessage.
+func (c *client) connectionReadLimit() int64 {
+	if c.streamSelector == nil {
+		return c.readLimit
+	}
+	if c.readLimit == 0 || c.streamReadLimit == 0 {
+		return 0
+	}
+	if c.streamReadLimit > c.readLimit {
+		return c.streamReadLimit
+	}
+	return c.readLimit
+}
+
+// readMessage reads the next message.
+// If the message is selected for streaming, it is handled before readMessage returns and streamed is true.
+func (c *client) readMessage(conn *connection) (msgType int, msg []byte, streamed bool, err error) {
+	msgType, r, err := conn.conn.NextReader()
+	if err != nil {
+		return 0, nil, false, err
+	}
+
+	if c.streamSelector != nil {
+		headerSize := c.streamSelector.HeaderSize()
+		br := bufio.NewReaderSize(r, headerSize)
+		header, err := br.Peek(headerSize)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return 0, nil, false, err
+		}
+
+		if handler := c.streamSelector.Select(msgType, header); handler != nil {
+			return msgType, nil, true, c.handleStream(conn, handler, br)
+		}
+		r = br
+	}
+
+	var limited *limitedReader
+	if c.readLimit > 0 {
+		limited = newLimitedReader(r, c.readLimit)
+		r = limited
+	}
+
+	msg, err = io.ReadAll(r)
+	if err != nil {
+		if limited != nil && limited.exceeded {
+			return 0, nil, false, c.closeMessageTooBig(conn)
+		}
+		return 0, nil, false, err
+	}
+
+	return msgType, msg, false, nil
+}
+
+func (c *client) handleStream(conn *connection, handler StreamHandler, r io.Reader) error {
+	var limited *limitedReader
+	if c.streamReadLimit > 0 {
+		limited = newLimitedReader(r, c.streamReadLimit)
+		r = limited
+	}
+
+	err := handler(withConnection(context.Background(), conn), r, conn)
+	if limited != nil && limited.exceeded {
+		return c.closeMessageTooBig(conn)
+	}
+	if err != nil {
+		// If the websocket connection failed, the next read returns the error.
+		c.logger.Printf("failed to handle stream: %v", err)
+	}
+
+	return nil
+}
+
+// closeMessageTooBig closes the connection the same way gorilla/websocket does when its read limit is exceeded.
+func (c *client) closeMessageTooBig(conn *connection) error {
+	if err := conn.CloseWithReason(websocket.CloseMessageTooBig, "message too big"); err != nil {
+		c.logger.Printf("failed to close connection: %v", err)
+	}
+	return websocket.ErrReadLimit
+}
+
 func (c *client) handleMessage(msg []byte, conn *connection) {
-	ctx, msg, err := c.runMiddlewares(msg)
+	ctx, cache := withJSONCache(withConnection(context.Background(), conn))
+	defer cache.release()
+
+	ctx, msg, err := c.runMiddlewares(ctx, msg)
 	if err != nil {
-		c.logger.Printf("failed to run middlewares: %v", err)
+		if !isExpectedError(err) {
+			c.logger.Printf("failed to run middlewares: %v", err)
+		}
 		return
 	}
 
 	err = c.resolver.Handle(ctx, msg, conn)
 	if err != nil {
-		c.logger.Printf("failed to handle message: %v", err)
+		if !isExpectedError(err) {
+			c.logger.Printf("failed to handle message: %v", err)
+		}
 		return
 	}
 }
 
-func (c *client) runMiddlewares(msg []byte) (context.Context, []byte, error) {
-	ctx := context.Background()
+// isExpectedError reports whether the error is a part of the normal operation and is not logged, e.g. a throttled message.
+func isExpectedError(err error) bool {
+	return errors.Is(err, ErrRateLimited) ||
+		errors.Is(err, ErrDuplicateMessage) ||
+		errors.Is(err, ErrUnauthenticated) ||
+		errors.Is(err, ErrForbidden)
+}
 
+func (c *client) runMiddlewares(ctx context.Context, msg []byte) (context.Context, []byte, error) {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
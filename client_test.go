@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
@@ -214,3 +215,114 @@ func (r *testResolver) Handle(_ context.Context, msg []byte, rw ResponseWriter)
 	assert.Equal(r.t, r.expectedMessage, msg)
 	return nil
 }
+
+func TestHandleConnection_ReadLimit(t *testing.T) {
+	closeErr := make(chan error, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := websocket.Upgrader{}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		assert.NoError(t, err)
+		defer conn.Close()
+
+		err = conn.WriteMessage(websocket.TextMessage, []byte(strings.Repeat("a", 100)))
+		assert.NoError(t, err)
+
+		_, _, err = conn.ReadMessage()
+		closeErr <- err
+	}))
+	defer server.Close()
+
+	wsURL := strings.Replace(server.URL, "http://", "ws://", 1)
+	clientConn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	assert.NoError(t, err)
+	defer clientConn.Close()
+
+	resolver := &testResolver{t: t}
+	client := NewClient(context.Background(), resolver, NoLogger(), 10, WithReadLimit(10))
+	conn := client.NewConnection(clientConn)
+
+	<-conn.Wait()
+
+	assert.True(t, websocket.IsCloseError(<-closeErr, websocket.CloseMessageTooBig))
+	resolver.callsMutex.Lock()
+	assert.Equal(t, 0, resolver.calls)
+	resolver.callsMutex.Unlock()
+}
+
+func TestHandleConnection_Streaming(t *testing.T) {
+	tests := []struct {
+		name          string
+		streamLimit   int64
+		expectedBody  string
+		expectedClose int
+	}{
+		{
+			name:         "streamed message bigger than read limit",
+			streamLimit:  1000,
+			expectedBody: strings.Repeat("a", 100),
+		},
+		{
+			name:          "streamed message bigger than stream limit",
+			streamLimit:   50,
+			expectedClose: websocket.CloseMessageTooBig,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			closeErr := make(chan error, 1)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				upgrader := websocket.Upgrader{}
+				conn, err := upgrader.Upgrade(w, r, nil)
+				assert.NoError(t, err)
+				defer conn.Close()
+
+				err = conn.WriteMessage(websocket.BinaryMessage, append([]byte{0x01}, strings.Repeat("a", 100)...))
+				assert.NoError(t, err)
+				err = conn.WriteMessage(websocket.TextMessage, []byte("Hello, client!"))
+				assert.NoError(t, err)
+
+				_, _, err = conn.ReadMessage()
+				closeErr <- err
+			}))
+			defer server.Close()
+
+			wsURL := strings.Replace(server.URL, "http://", "ws://", 1)
+			clientConn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+			assert.NoError(t, err)
+			defer clientConn.Close()
+
+			bodies := make(chan string, 1)
+			streams := NewBinaryResolver(BinaryHeader{Width: 1, Strip: true}).
+				AddStreamHandler(0x01, func(ctx context.Context, r io.Reader, rw ResponseWriter) error {
+					body, err := io.ReadAll(r)
+					if err != nil {
+						return err
+					}
+					bodies <- string(body)
+					return nil
+				})
+
+			resolver := &testResolver{expectedMessage: []byte("Hello, client!"), t: t}
+			client := NewClient(context.Background(), resolver, NoLogger(), 10,
+				WithReadLimit(20),
+				WithStreaming(streams, tt.streamLimit),
+			)
+			conn := client.NewConnection(clientConn)
+
+			if tt.expectedClose != 0 {
+				<-conn.Wait()
+				assert.True(t, websocket.IsCloseError(<-closeErr, tt.expectedClose))
+				return
+			}
+
+			assert.Equal(t, tt.expectedBody, <-bodies)
+			assert.Eventually(t, func() bool {
+				resolver.callsMutex.Lock()
+				defer resolver.callsMutex.Unlock()
+				return resolver.calls == 1
+			}, time.Second, 10*time.Millisecond)
+			assert.NoError(t, conn.Close())
+		})
+	}
+}
@@ -0,0 +1,433 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/format"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// generator generates Go code from an AsyncAPI spec.
+type generator struct {
+	spec    *spec
+	pkg     string
+	imports map[string]bool
+
+	// types holds the generated type declarations by type name.
+	types     map[string]string
+	typeOrder []string
+	// refs holds the type names of the referenced components.schemas.
+	refs map[string]string
+}
+
+// reservedNames are the identifiers declared by the generated code besides the message types.
+var reservedNames = map[string]bool{
+	"DiscriminatorField": true, "Server": true, "NewResolver": true, "Client": true, "NewClient": true,
+}
+
+type route struct {
+	name     string
+	value    string
+	request  string
+	response string
+}
+
+func generate(s *spec, pkg string) ([]byte, error) {
+	g := &generator{
+		spec:    s,
+		pkg:     pkg,
+		imports: map[string]bool{"context": true, "encoding/json": true, "github.com/jaxmef/wsocket": true},
+		types:   make(map[string]string),
+		refs:    make(map[string]string),
+	}
+
+	requests, responses, err := g.collectMessages()
+	if err != nil {
+		return nil, err
+	}
+
+	field, err := discriminatorField(requests)
+	if err != nil {
+		return nil, err
+	}
+
+	fieldName, err := discriminatorGoField(requests, field)
+	if err != nil {
+		return nil, err
+	}
+
+	routes := make([]route, 0, len(requests))
+	routeNames := make(map[string]bool, len(requests))
+	for _, m := range requests {
+		// The request type is named like the route if possible, so the route name must not be used by a type either.
+		r := route{
+			name: uniqueName(goName(m.key), func(name string) bool {
+				return routeNames[name] || g.isUsed(name)
+			}),
+			value: fmt.Sprint(m.Payload.Properties[field].Const),
+		}
+		routeNames[r.name] = true
+		if r.request, err = g.typeFor(r.name, m.Payload); err != nil {
+			return nil, fmt.Errorf("message %q: %w", m.key, err)
+		}
+		if resp, ok := responses[m.key+".response"]; ok {
+			if r.response, err = g.typeFor(goName(resp.key), resp.Payload); err != nil {
+				return nil, fmt.Errorf("message %q: %w", resp.key, err)
+			}
+		}
+		routes = append(routes, r)
+	}
+
+	return g.render(field, fieldName, routes)
+}
+
+// collectMessages returns the messages sent by the clients sorted by key and the messages sent by the server by key.
+func (g *generator) collectMessages() ([]namedMessage, map[string]namedMessage, error) {
+	published, subscribed, err := g.spec.exchanged()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	requests := make([]namedMessage, 0, len(published))
+	seen := make(map[string]bool)
+	for _, m := range published {
+		if !seen[m.key] {
+			seen[m.key] = true
+			requests = append(requests, m)
+		}
+	}
+	responses := make(map[string]namedMessage, len(subscribed))
+	for _, m := range subscribed {
+		responses[m.key] = m
+	}
+
+	sort.Slice(requests, func(i, j int) bool {
+		return requests[i].key < requests[j].key
+	})
+	if len(requests) == 0 {
+		return nil, nil, fmt.Errorf("no messages published by clients")
+	}
+
+	return requests, responses, nil
+}
+
+// discriminatorField returns the top-level property that has a const value in every message, "type" is preferred.
+func discriminatorField(messages []namedMessage) (string, error) {
+	candidates := make(map[string]int)
+	for _, m := range messages {
+		if m.Payload == nil {
+			return "", fmt.Errorf("message %q has no payload", m.key)
+		}
+		for name, prop := range m.Payload.Properties {
+			if prop.Const != nil {
+				candidates[name]++
+			}
+		}
+	}
+
+	fields := make([]string, 0)
+	for name, count := range candidates {
+		if count == len(messages) {
+			fields = append(fields, name)
+		}
+	}
+	if len(fields) == 0 {
+		return "", fmt.Errorf("no discriminator: messages must share a top-level property with a const value")
+	}
+
+	sort.Strings(fields)
+	for _, name := range fields {
+		if name == "type" {
+			return name, nil
+		}
+	}
+	return fields[0], nil
+}
+
+// discriminatorGoField returns the name of the struct field holding the discriminator, it must be a string in all requests.
+func discriminatorGoField(messages []namedMessage, field string) (string, error) {
+	for _, m := range messages {
+		if m.Payload.Properties[field].typeName() != "string" {
+			return "", fmt.Errorf("discriminator %q of message %q must be a string", field, m.key)
+		}
+	}
+	return goName(field), nil
+}
+
+// typeFor returns the Go type for the schema, declaring named types for objects.
+func (g *generator) typeFor(name string, sc *schema) (string, error) {
+	if sc == nil {
+		g.imports["encoding/json"] = true
+		return "json.RawMessage", nil
+	}
+
+	if sc.Ref != "" {
+		const prefix = "#/components/schemas/"
+		if !strings.HasPrefix(sc.Ref, prefix) {
+			return "", fmt.Errorf("unsupported schema reference %q", sc.Ref)
+		}
+		refName := strings.TrimPrefix(sc.Ref, prefix)
+		ref, ok := g.spec.Components.Schemas[refName]
+		if !ok {
+			return "", fmt.Errorf("unknown schema reference %q", sc.Ref)
+		}
+		if typeName, ok := g.refs[refName]; ok {
+			return typeName, nil
+		}
+		if ref.typeName() == "object" && len(ref.Properties) > 0 {
+			// The name is recorded before the struct is declared, so recursive references resolve to it.
+			typeName := g.typeName(goName(refName))
+			g.refs[refName] = typeName
+			return typeName, g.declareStruct(typeName, ref)
+		}
+		typeName, err := g.typeFor(goName(refName), ref)
+		if err != nil {
+			return "", err
+		}
+		g.refs[refName] = typeName
+		return typeName, nil
+	}
+
+	switch sc.typeName() {
+	case "string":
+		switch {
+		case sc.Format == "date-time":
+			g.imports["time"] = true
+			return "time.Time", nil
+		case sc.ContentEncoding == "base64":
+			return "[]byte", nil
+		}
+		return "string", nil
+	case "integer":
+		return "int", nil
+	case "number":
+		return "float64", nil
+	case "boolean":
+		return "bool", nil
+	case "array":
+		item, err := g.typeFor(name+"Item", sc.Items)
+		if err != nil {
+			return "", err
+		}
+		return "[]" + item, nil
+	case "object":
+		if len(sc.Properties) == 0 {
+			return g.mapType(name, sc)
+		}
+		name = g.typeName(name)
+		return name, g.declareStruct(name, sc)
+	default:
+		return "json.RawMessage", nil
+	}
+}
+
+func (g *generator) mapType(name string, sc *schema) (string, error) {
+	additional := &schema{}
+	if len(sc.AdditionalProperties) > 0 && string(sc.AdditionalProperties) != "true" {
+		if err := json.Unmarshal(sc.AdditionalProperties, additional); err != nil {
+			return "", fmt.Errorf("invalid additionalProperties of %s: %w", name, err)
+		}
+	}
+	value, err := g.typeFor(name+"Value", additional)
+	if err != nil {
+		return "", err
+	}
+	return "map[string]" + value, nil
+}
+
+// typeName reserves a type name, a numeric suffix is added if base is already used,
+// e.g. by the message "order-item" and the "item" property of the message "order".
+func (g *generator) typeName(base string) string {
+	name := uniqueName(base, g.isUsed)
+	g.types[name] = ""
+	return name
+}
+
+// isUsed reports whether name is a declared type or another identifier of the generated code.
+func (g *generator) isUsed(name string) bool {
+	_, ok := g.types[name]
+	return ok || reservedNames[name]
+}
+
+// declareStruct declares a struct with a name reserved by typeName.
+func (g *generator) declareStruct(name string, sc *schema) error {
+	required := make(map[string]bool, len(sc.Required))
+	for _, r := range sc.Required {
+		required[r] = true
+	}
+
+	props := make([]string, 0, len(sc.Properties))
+	for prop := range sc.Properties {
+		props = append(props, prop)
+	}
+	sort.Strings(props)
+
+	b := &strings.Builder{}
+	if sc.Description != "" {
+		fmt.Fprintf(b, "// %s %s\n", name, sc.Description)
+	}
+	fmt.Fprintf(b, "type %s struct {\n", name)
+	for _, prop := range props {
+		fieldName := goName(prop)
+		fieldType, err := g.typeFor(name+fieldName, sc.Properties[prop])
+		if err != nil {
+			return err
+		}
+		if fieldType == name || (!required[prop] && g.isStruct(fieldType)) {
+			fieldType = "*" + fieldType
+		}
+		tag := prop
+		if !required[prop] {
+			tag += ",omitempty"
+		}
+		fmt.Fprintf(b, "\t%s %s `json:%q`\n", fieldName, fieldType, tag)
+	}
+	b.WriteString("}\n")
+
+	g.types[name] = b.String()
+	g.typeOrder = append(g.typeOrder, name)
+	return nil
+}
+
+func (g *generator) isStruct(typeName string) bool {
+	_, ok := g.types[typeName]
+	return ok || typeName == "time.Time"
+}
+
+func (g *generator) render(field, fieldName string, routes []route) ([]byte, error) {
+	b := &bytes.Buffer{}
+	b.WriteString("// Code generated by wsocket-gen. DO NOT EDIT.\n\n")
+	fmt.Fprintf(b, "package %s\n\n", g.pkg)
+
+	for _, r := range routes {
+		if r.response == "" {
+			g.imports["fmt"] = true
+		}
+	}
+
+	imports := make([]string, 0, len(g.imports))
+	for imp := range g.imports {
+		imports = append(imports, imp)
+	}
+	sort.Strings(imports)
+	b.WriteString("import (\n")
+	for _, imp := range imports {
+		if strings.Contains(imp, ".") {
+			continue
+		}
+		fmt.Fprintf(b, "\t%q\n", imp)
+	}
+	b.WriteString("\n")
+	for _, imp := range imports {
+		if strings.Contains(imp, ".") {
+			fmt.Fprintf(b, "\t%q\n", imp)
+		}
+	}
+	b.WriteString(")\n\n")
+
+	b.WriteString("// DiscriminatorField is the message field the messages are resolved by.\n")
+	fmt.Fprintf(b, "const DiscriminatorField = %q\n\n", field)
+
+	b.WriteString("// Message types.\nconst (\n")
+	for _, r := range routes {
+		fmt.Fprintf(b, "\tMessageType%s = %q\n", r.name, r.value)
+	}
+	b.WriteString(")\n\n")
+
+	sort.Strings(g.typeOrder)
+	for _, name := range g.typeOrder {
+		b.WriteString(g.types[name])
+		b.WriteString("\n")
+	}
+
+	b.WriteString("// Server handles the messages sent by the clients.\n")
+	b.WriteString("// If a method returns a non-nil response, it is written to the connection.\n")
+	b.WriteString("type Server interface {\n")
+	for _, r := range routes {
+		if r.response != "" {
+			fmt.Fprintf(b, "\t%s(ctx context.Context, req *%s, rw wsocket.ResponseWriter) (*%s, error)\n", r.name, r.request, r.response)
+		} else {
+			fmt.Fprintf(b, "\t%s(ctx context.Context, req *%s, rw wsocket.ResponseWriter) error\n", r.name, r.request)
+		}
+	}
+	b.WriteString("}\n\n")
+
+	b.WriteString("// NewResolver creates a JSONResolver routing the messages to s.\n")
+	b.WriteString("func NewResolver(s Server) *wsocket.JSONResolver {\n")
+	b.WriteString("\tr := wsocket.NewJSONResolver(DiscriminatorField)\n")
+	for _, r := range routes {
+		if r.response != "" {
+			fmt.Fprintf(b, "\twsocket.AddTypedHandler[%s, %s](r, MessageType%s, s.%s)\n", r.request, r.response, r.name, r.name)
+			continue
+		}
+		// Routes without a response are added without a response type, so none is documented.
+		fmt.Fprintf(b, "\tr.AddHandler(MessageType%s, func(ctx context.Context, msg []byte, rw wsocket.ResponseWriter) error {\n", r.name)
+		fmt.Fprintf(b, "\t\treq := new(%s)\n", r.request)
+		b.WriteString("\t\tif err := json.Unmarshal(msg, req); err != nil {\n")
+		b.WriteString("\t\t\treturn fmt.Errorf(\"failed to decode message: %w\", err)\n\t\t}\n")
+		fmt.Fprintf(b, "\t\treturn s.%s(ctx, req, rw)\n", r.name)
+		fmt.Fprintf(b, "\t}, wsocket.WithPayload(%s{}, nil))\n", r.request)
+	}
+	b.WriteString("\treturn r\n}\n\n")
+
+	b.WriteString("// Client sends the messages handled by Server.\n")
+	b.WriteString("type Client struct {\n\tw wsocket.ResponseWriter\n}\n\n")
+	b.WriteString("// NewClient creates a client writing the messages to w, usually a wsocket.Connection.\n")
+	b.WriteString("func NewClient(w wsocket.ResponseWriter) *Client {\n\treturn &Client{w: w}\n}\n\n")
+	for _, r := range routes {
+		fmt.Fprintf(b, "// %s sends the %q message. The discriminator field is set automatically.\n", r.name, r.value)
+		fmt.Fprintf(b, "func (c *Client) %s(req *%s) error {\n", r.name, r.request)
+		fmt.Fprintf(b, "\treq.%s = MessageType%s\n", fieldName, r.name)
+		b.WriteString("\treturn c.write(req)\n}\n\n")
+	}
+	b.WriteString("func (c *Client) write(v interface{}) error {\n")
+	b.WriteString("\tmsg, err := json.Marshal(v)\n\tif err != nil {\n\t\treturn err\n\t}\n")
+	b.WriteString("\treturn c.w.WriteMessage(wsocket.NewTextMessage(msg))\n}\n")
+
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("failed to format generated code: %w", err)
+	}
+	return src, nil
+}
+
+// uniqueName returns base, or base with a numeric suffix if it is already used.
+func uniqueName(base string, used func(string) bool) string {
+	name := base
+	for i := 2; used(name); i++ {
+		name = base + strconv.Itoa(i)
+	}
+	return name
+}
+
+var commonInitialisms = map[string]string{
+	"id": "ID", "url": "URL", "uri": "URI", "api": "API", "json": "JSON", "http": "HTTP", "ip": "IP", "uuid": "UUID",
+}
+
+// goName converts a message or property name such as "sum-request" or "user_id" to an exported Go identifier.
+func goName(s string) string {
+	words := strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	b := &strings.Builder{}
+	for _, w := range words {
+		if initialism, ok := commonInitialisms[strings.ToLower(w)]; ok {
+			b.WriteString(initialism)
+			continue
+		}
+		runes := []rune(w)
+		runes[0] = unicode.ToUpper(runes[0])
+		b.WriteString(string(runes))
+	}
+
+	name := b.String()
+	if name == "" || unicode.IsDigit([]rune(name)[0]) {
+		name = "X" + name
+	}
+	return name
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/jaxmef/wsocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerate(t *testing.T) {
+	data, err := os.ReadFile("testdata/asyncapi.yaml")
+	assert.NoError(t, err)
+
+	s, err := parseSpec(data)
+	assert.NoError(t, err)
+
+	src, err := generate(s, "api")
+	assert.NoError(t, err)
+
+	compileGenerated(t, src)
+
+	code := string(src)
+	assert.Contains(t, code, `const DiscriminatorField = "type"`)
+	assert.Contains(t, code, `MessageTypeSumRequest = "sum-request"`)
+	assert.Contains(t, code, "SumRequest(ctx context.Context, req *SumRequest, rw wsocket.ResponseWriter) (*SumRequestResponse, error)")
+	assert.Contains(t, code, "Event(ctx context.Context, req *Event, rw wsocket.ResponseWriter) error")
+	assert.Contains(t, code, "wsocket.AddTypedHandler[SumRequest, SumRequestResponse](r, MessageTypeSumRequest, s.SumRequest)")
+	assert.Contains(t, code, "r.AddHandler(MessageTypeEvent, func(ctx context.Context, msg []byte, rw wsocket.ResponseWriter) error {")
+	assert.Contains(t, code, "}, wsocket.WithPayload(Event{}, nil))")
+	assert.NotContains(t, code, "struct{}")
+	assert.Contains(t, code, "req.Type = MessageTypeEvent")
+	assert.Contains(t, code, "CreatedAt *time.Time `json:\"created_at,omitempty\"`")
+	assert.Contains(t, code, "Parent     *EventData         `json:\"parent,omitempty\"`")
+	assert.Contains(t, code, "Attributes map[string]float64 `json:\"attributes,omitempty\"`")
+	assert.Contains(t, code, "UserID     string             `json:\"user_id,omitempty\"`")
+}
+
+type sumRequest struct {
+	Type string `json:"type"`
+	A    int    `json:"a"`
+	B    int    `json:"b"`
+}
+
+type sumResponse struct {
+	Type   string `json:"type"`
+	Result int    `json:"result"`
+}
+
+func TestGenerate_FromClientDocument(t *testing.T) {
+	resolver := wsocket.NewJSONResolver("type")
+	wsocket.AddTypedHandler(resolver, "sum-request", func(ctx context.Context, req *sumRequest, rw wsocket.ResponseWriter) (*sumResponse, error) {
+		return &sumResponse{Type: "sum-response", Result: req.A + req.B}, nil
+	})
+
+	doc := wsocket.AsyncAPI(resolver, wsocket.AsyncAPIInfo{Title: "Calculator", Version: "1.0.0"})
+	data, err := json.Marshal(doc)
+	assert.NoError(t, err)
+
+	s, err := parseSpec(data)
+	assert.NoError(t, err)
+
+	src, err := generate(s, "calculator")
+	assert.NoError(t, err)
+	compileGenerated(t, src)
+	assert.Contains(t, string(src), "package calculator")
+	assert.Contains(t, string(src), "wsocket.AddTypedHandler[SumRequest, SumRequestResponse](r, MessageTypeSumRequest, s.SumRequest)")
+}
+
+func TestGenerate_FromV3Document(t *testing.T) {
+	resolver := wsocket.NewJSONResolver("type")
+	wsocket.AddTypedHandler(resolver, "sum-request", func(ctx context.Context, req *sumRequest, rw wsocket.ResponseWriter) (*sumResponse, error) {
+		return &sumResponse{Type: "sum-response", Result: req.A + req.B}, nil
+	})
+	resolver.AddHandler("ping", func(ctx context.Context, msg []byte, rw wsocket.ResponseWriter) error {
+		return nil
+	})
+
+	doc := wsocket.AsyncAPIV3(resolver, wsocket.AsyncAPIInfo{Title: "Calculator", Version: "1.0.0"})
+	data, err := json.Marshal(doc)
+	assert.NoError(t, err)
+
+	s, err := parseSpec(data)
+	assert.NoError(t, err)
+
+	src, err := generate(s, "calculator")
+	assert.NoError(t, err)
+	compileGenerated(t, src)
+
+	code := string(src)
+	assert.Contains(t, code, "wsocket.AddTypedHandler[SumRequest, SumRequestResponse](r, MessageTypeSumRequest, s.SumRequest)")
+	assert.Contains(t, code, `MessageTypePing       = "ping"`)
+	assert.Contains(t, code, "Type string `json:\"type\"`")
+}
+
+func TestGenerate_NameCollisions(t *testing.T) {
+	const doc = `{"asyncapi": "2.6.0", "channels": {"/": {"publish": {"message": {"oneOf": [
+		{"name": "order", "payload": {"type": "object", "required": ["type"], "properties": {
+			"type": {"type": "string", "const": "order"},
+			"item": {"type": "object", "properties": {"sku": {"type": "string"}}}
+		}}},
+		{"name": "order-item", "payload": {"type": "object", "required": ["type"], "properties": {
+			"type": {"type": "string", "const": "order-item"},
+			"quantity": {"type": "integer"}
+		}}},
+		{"name": "order_item", "payload": {"type": "object", "required": ["type"], "properties": {
+			"type": {"type": "string", "const": "order_item"}
+		}}},
+		{"name": "client", "payload": {"type": "object", "required": ["type"], "properties": {
+			"type": {"type": "string", "const": "client"}
+		}}}
+	]}}}}}`
+
+	s, err := parseSpec([]byte(doc))
+	assert.NoError(t, err)
+
+	src, err := generate(s, "api")
+	assert.NoError(t, err)
+	compileGenerated(t, src)
+
+	code := string(src)
+	assert.Contains(t, code, "Item *OrderItem `json:\"item,omitempty\"`")
+	assert.Contains(t, code, "OrderItem2(ctx context.Context, req *OrderItem2, rw wsocket.ResponseWriter) error")
+	assert.Contains(t, code, "OrderItem3(ctx context.Context, req *OrderItem3, rw wsocket.ResponseWriter) error")
+	assert.Contains(t, code, "Client2(ctx context.Context, req *Client2, rw wsocket.ResponseWriter) error")
+}
+
+func TestGenerate_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		spec string
+	}{
+		{
+			name: "Unsupported Version",
+			spec: `{"asyncapi": "1.2.0"}`,
+		},
+		{
+			name: "No Messages",
+			spec: `{"asyncapi": "2.6.0", "channels": {}}`,
+		},
+		{
+			name: "No Discriminator",
+			spec: `{"asyncapi": "2.6.0", "channels": {"/": {"publish": {"message": {"name": "ping", "payload": {"type": "object", "properties": {"type": {"type": "string"}}}}}}}}`,
+		},
+		{
+			name: "Non-String Discriminator",
+			spec: `{"asyncapi": "2.6.0", "channels": {"/": {"publish": {"message": {"name": "ping", "payload": {"type": "object", "properties": {"type": {"type": "integer", "const": 1}}}}}}}}`,
+		},
+		{
+			name: "Unknown Operation Channel",
+			spec: `{"asyncapi": "3.0.0", "operations": {"ping": {"action": "receive", "channel": {"$ref": "#/channels/missing"}}}}`,
+		},
+		{
+			name: "Unknown Reference",
+			spec: `{"asyncapi": "2.6.0", "channels": {"/": {"publish": {"message": {"$ref": "#/components/messages/missing"}}}}}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := parseSpec([]byte(test.spec))
+			if err == nil {
+				_, err = generate(s, "api")
+			}
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestGoName(t *testing.T) {
+	assert.Equal(t, "SumRequest", goName("sum-request"))
+	assert.Equal(t, "UserID", goName("user_id"))
+	assert.Equal(t, "OrderCreated", goName("order.created"))
+	assert.Equal(t, "X2fa", goName("2fa"))
+}
+
+// compileGenerated builds the generated code as a package of this module, so it is checked against the current wsocket API.
+// The code is written to a temporary directory and overlaid on a package directory that does not exist.
+func compileGenerated(t *testing.T, src []byte) {
+	t.Helper()
+
+	goBin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command not found")
+	}
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "api.gen.go")
+	if !assert.NoError(t, os.WriteFile(file, src, 0o600)) {
+		return
+	}
+	wd, err := os.Getwd()
+	if !assert.NoError(t, err) {
+		return
+	}
+	pkgDir := filepath.Join(wd, "generated_"+filepath.Base(dir))
+	overlay, err := json.Marshal(map[string]interface{}{
+		"Replace": map[string]string{filepath.Join(pkgDir, "api.gen.go"): file},
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	overlayFile := filepath.Join(dir, "overlay.json")
+	if !assert.NoError(t, os.WriteFile(overlayFile, overlay, 0o600)) {
+		return
+	}
+
+	out, err := exec.Command(goBin, "build", "-overlay", overlayFile, pkgDir).CombinedOutput()
+	assert.NoError(t, err, "Expected the generated code to compile:\n%s\n%s", out, src)
+}
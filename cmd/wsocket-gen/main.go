@@ -0,0 +1,55 @@
+// Command wsocket-gen generates typed routes and clients from an AsyncAPI 2.x or 3.x document.
+//
+// The messages published by the clients must share a top-level discriminator property with a const value,
+// as in the documents generated by wsocket.AsyncAPI. A message sent by the server is used as the
+// response of the message with the same key and the ".response" suffix.
+//
+// Usage:
+//
+//	wsocket-gen -in asyncapi.yaml -out api.gen.go -package api
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+func main() {
+	in := flag.String("in", "", "AsyncAPI document in JSON or YAML format")
+	out := flag.String("out", "", "output file, stdout if empty")
+	pkg := flag.String("package", "api", "package name of the generated code")
+	flag.Parse()
+
+	if err := run(*in, *out, *pkg); err != nil {
+		fmt.Fprintf(os.Stderr, "wsocket-gen: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(in, out, pkg string) error {
+	if in == "" {
+		return fmt.Errorf("-in is required")
+	}
+
+	data, err := os.ReadFile(in)
+	if err != nil {
+		return err
+	}
+
+	s, err := parseSpec(data)
+	if err != nil {
+		return err
+	}
+
+	src, err := generate(s, pkg)
+	if err != nil {
+		return err
+	}
+
+	if out == "" {
+		_, err = os.Stdout.Write(src)
+		return err
+	}
+	return os.WriteFile(out, src, 0o644)
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// spec is the subset of an AsyncAPI 2.x or 3.x document used by the generator.
+type spec struct {
+	AsyncAPI string             `json:"asyncapi"`
+	Channels map[string]channel `json:"channels"`
+	// Operations holds the AsyncAPI 3.x operations, described from the server point of view.
+	Operations map[string]operationV3 `json:"operations"`
+	Components struct {
+		Messages map[string]*message `json:"messages"`
+		Schemas  map[string]*schema  `json:"schemas"`
+	} `json:"components"`
+}
+
+type channel struct {
+	// Publish holds the messages the clients send to the server.
+	Publish *operation `json:"publish"`
+	// Subscribe holds the messages the server sends to the clients.
+	Subscribe *operation `json:"subscribe"`
+	// Messages holds the AsyncAPI 3.x messages of the channel by key.
+	Messages map[string]*message `json:"messages"`
+}
+
+type operation struct {
+	Message message `json:"message"`
+}
+
+type operationV3 struct {
+	// Action is "receive" for the messages the clients send to the server and "send" for the messages the server sends.
+	Action   string      `json:"action"`
+	Channel  reference   `json:"channel"`
+	Messages []reference `json:"messages"`
+}
+
+type reference struct {
+	Ref string `json:"$ref"`
+}
+
+type message struct {
+	Ref     string     `json:"$ref"`
+	Name    string     `json:"name"`
+	Payload *schema    `json:"payload"`
+	OneOf   []*message `json:"oneOf"`
+}
+
+type schema struct {
+	Ref                  string             `json:"$ref"`
+	Type                 interface{}        `json:"type"`
+	Format               string             `json:"format"`
+	ContentEncoding      string             `json:"contentEncoding"`
+	Description          string             `json:"description"`
+	Const                interface{}        `json:"const"`
+	Properties           map[string]*schema `json:"properties"`
+	Required             []string           `json:"required"`
+	Items                *schema            `json:"items"`
+	AdditionalProperties json.RawMessage    `json:"additionalProperties"`
+}
+
+// parseSpec parses an AsyncAPI document in JSON or YAML format.
+func parseSpec(data []byte) (*spec, error) {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) == 0 || trimmed[0] != '{' {
+		var doc interface{}
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("failed to parse YAML: %w", err)
+		}
+		var err error
+		if data, err = json.Marshal(doc); err != nil {
+			return nil, fmt.Errorf("failed to convert YAML to JSON: %w", err)
+		}
+	}
+
+	s := &spec{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("failed to parse JSON: %w", err)
+	}
+	if !strings.HasPrefix(s.AsyncAPI, "2.") && !strings.HasPrefix(s.AsyncAPI, "3.") {
+		return nil, fmt.Errorf("unsupported AsyncAPI version %q, only 2.x and 3.x are supported", s.AsyncAPI)
+	}
+
+	return s, nil
+}
+
+// namedMessage is a message resolved from a channel operation.
+type namedMessage struct {
+	// key is the name of the message in components.messages, or the message name for inline messages.
+	key string
+	*message
+}
+
+// isV3 reports whether the document uses the AsyncAPI 3.x layout of channels and operations.
+func (s *spec) isV3() bool {
+	return strings.HasPrefix(s.AsyncAPI, "3.")
+}
+
+// exchanged returns the messages the clients send to the server and the messages the server sends to the clients.
+func (s *spec) exchanged() (published, subscribed []namedMessage, err error) {
+	if s.isV3() {
+		return s.exchangedV3()
+	}
+
+	channelNames := make([]string, 0, len(s.Channels))
+	for name := range s.Channels {
+		channelNames = append(channelNames, name)
+	}
+	sort.Strings(channelNames)
+
+	for _, name := range channelNames {
+		ch := s.Channels[name]
+
+		pub, err := s.messages(ch.Publish)
+		if err != nil {
+			return nil, nil, fmt.Errorf("channel %q: %w", name, err)
+		}
+		published = append(published, pub...)
+
+		sub, err := s.messages(ch.Subscribe)
+		if err != nil {
+			return nil, nil, fmt.Errorf("channel %q: %w", name, err)
+		}
+		subscribed = append(subscribed, sub...)
+	}
+
+	return published, subscribed, nil
+}
+
+// exchangedV3 returns the messages of the AsyncAPI 3.x operations, "receive" operations are the messages sent by the clients.
+func (s *spec) exchangedV3() (published, subscribed []namedMessage, err error) {
+	opNames := make([]string, 0, len(s.Operations))
+	for name := range s.Operations {
+		opNames = append(opNames, name)
+	}
+	sort.Strings(opNames)
+
+	for _, name := range opNames {
+		op := s.Operations[name]
+
+		list, err := s.operationMessages(op)
+		if err != nil {
+			return nil, nil, fmt.Errorf("operation %q: %w", name, err)
+		}
+		switch op.Action {
+		case "receive":
+			published = append(published, list...)
+		case "send":
+			subscribed = append(subscribed, list...)
+		default:
+			return nil, nil, fmt.Errorf("operation %q: unsupported action %q", name, op.Action)
+		}
+	}
+
+	return published, subscribed, nil
+}
+
+// operationMessages returns the messages of an AsyncAPI 3.x operation, or all messages of its channel if none are listed.
+func (s *spec) operationMessages(op operationV3) ([]namedMessage, error) {
+	const channelPrefix = "#/channels/"
+	if !strings.HasPrefix(op.Channel.Ref, channelPrefix) {
+		return nil, fmt.Errorf("unsupported channel reference %q", op.Channel.Ref)
+	}
+	channelName := strings.TrimPrefix(op.Channel.Ref, channelPrefix)
+	ch, ok := s.Channels[channelName]
+	if !ok {
+		return nil, fmt.Errorf("unknown channel reference %q", op.Channel.Ref)
+	}
+
+	keys := make([]string, 0, len(op.Messages))
+	if len(op.Messages) == 0 {
+		for key := range ch.Messages {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+	}
+	for _, ref := range op.Messages {
+		prefix := channelPrefix + channelName + "/messages/"
+		if !strings.HasPrefix(ref.Ref, prefix) {
+			return nil, fmt.Errorf("unsupported message reference %q", ref.Ref)
+		}
+		keys = append(keys, strings.TrimPrefix(ref.Ref, prefix))
+	}
+
+	result := make([]namedMessage, 0, len(keys))
+	for _, key := range keys {
+		m, ok := ch.Messages[key]
+		if !ok {
+			return nil, fmt.Errorf("unknown message %q of channel %q", key, channelName)
+		}
+		if m.Ref == "" {
+			// Inline messages of a channel are keyed like the messages in components.messages.
+			result = append(result, namedMessage{key: key, message: m})
+			continue
+		}
+		resolved, err := s.resolveMessage(m)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, resolved)
+	}
+
+	return result, nil
+}
+
+// messages returns the messages of an AsyncAPI 2.x operation, resolving references to components.messages.
+func (s *spec) messages(op *operation) ([]namedMessage, error) {
+	if op == nil {
+		return nil, nil
+	}
+
+	list := op.Message.OneOf
+	if len(list) == 0 {
+		list = []*message{&op.Message}
+	}
+
+	result := make([]namedMessage, 0, len(list))
+	for _, m := range list {
+		resolved, err := s.resolveMessage(m)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, resolved)
+	}
+
+	return result, nil
+}
+
+// resolveMessage resolves a reference to components.messages, inline messages are keyed by their name.
+func (s *spec) resolveMessage(m *message) (namedMessage, error) {
+	key := m.Name
+	if ref := m.Ref; ref != "" {
+		const prefix = "#/components/messages/"
+		if !strings.HasPrefix(ref, prefix) {
+			return namedMessage{}, fmt.Errorf("unsupported message reference %q", ref)
+		}
+		key = strings.TrimPrefix(ref, prefix)
+		var ok bool
+		if m, ok = s.Components.Messages[key]; !ok {
+			return namedMessage{}, fmt.Errorf("unknown message reference %q", ref)
+		}
+	}
+	if key == "" {
+		return namedMessage{}, fmt.Errorf("message without name")
+	}
+	return namedMessage{key: key, message: m}, nil
+}
+
+func (sc *schema) typeName() string {
+	switch t := sc.Type.(type) {
+	case string:
+		return t
+	case []interface{}:
+		// A type list such as ["string", "null"] is reduced to the first non-null type.
+		for _, item := range t {
+			if name, ok := item.(string); ok && name != "null" {
+				return name
+			}
+		}
+	}
+	if len(sc.Properties) > 0 {
+		return "object"
+	}
+	// A const without a type, as generated for discriminators, has the type of its value.
+	switch sc.Const.(type) {
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	}
+	return ""
+}
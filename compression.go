@@ -0,0 +1,112 @@
+package wsocket
+
+import (
+	"compress/flate"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+// CompressionState describes the permessage-deflate compression of a connection.
+type CompressionState struct {
+	// Negotiated reports whether the peer accepted permessage-deflate during the handshake.
+	// The websocket.Upgrader or websocket.Dialer must have EnableCompression set to negotiate it.
+	// It is known only for the connections created with NewConnectionWithHandshake, otherwise it is false.
+	Negotiated bool
+	// Enabled reports whether outgoing messages are compressed, i.e. compression is enabled by WithCompressionLevel
+	// and, if the handshake is known, negotiated. gorilla/websocket sends the messages uncompressed if it is not negotiated.
+	Enabled bool
+	// Level is the compression level used for outgoing messages.
+	Level int
+	// MinSize is the size in bytes below which outgoing messages are sent uncompressed.
+	MinSize int
+}
+
+// CompressionReporter is implemented by the connections that report their compression, e.g. the connections created by a Client.
+type CompressionReporter interface {
+	// Compression returns the permessage-deflate compression state of the connection.
+	Compression() CompressionState
+}
+
+// ConnectionCompression returns the compression state of conn, or the zero state if conn is not a CompressionReporter.
+func ConnectionCompression(conn Connection) CompressionState {
+	if reporter, ok := conn.(CompressionReporter); ok {
+		return reporter.Compression()
+	}
+	return CompressionState{}
+}
+
+// compressionOverride is the per-message compression set by Message.WithCompression.
+type compressionOverride int8
+
+const (
+	compressionDefault compressionOverride = iota
+	compressionOn
+	compressionOff
+)
+
+// WithCompressionLevel compresses outgoing messages with permessage-deflate if it is negotiated for the connection.
+// level is a compress/flate level between flate.HuffmanOnly and flate.BestCompression.
+// Messages smaller than minSize bytes are sent uncompressed, because compressing them costs more than it saves,
+// unless overridden with Message.WithCompression. Messages written by NextWriter are always compressed.
+// If level is invalid, WithCompressionLevel panics.
+func WithCompressionLevel(level int, minSize int) ClientOption {
+	if level < flate.HuffmanOnly || level > flate.BestCompression {
+		panic(fmt.Sprintf("wsocket: invalid compression level: %d", level))
+	}
+
+	return func(c *client) {
+		c.connConfig.compression = true
+		c.connConfig.compressionLevel = level
+		c.connConfig.compressionMinSize = minSize
+	}
+}
+
+// CompressionNegotiated reports whether permessage-deflate is listed in the Sec-WebSocket-Extensions header.
+// On the client, pass the response header returned by websocket.Dialer. On the server, pass the request header
+// if the websocket.Upgrader has EnableCompression set, it accepts the extension whenever it is offered.
+func CompressionNegotiated(header http.Header) bool {
+	for _, value := range header.Values("Sec-WebSocket-Extensions") {
+		for _, extension := range strings.Split(value, ",") {
+			name := strings.TrimSpace(strings.SplitN(extension, ";", 2)[0])
+			if strings.EqualFold(name, "permessage-deflate") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// HandshakeConnector is implemented by the clients created by NewClient.
+type HandshakeConnector interface {
+	// NewConnectionWithHandshake creates a connection like NewConnection.
+	// header is the handshake header the negotiated compression is read from, see CompressionNegotiated.
+	NewConnectionWithHandshake(conn *websocket.Conn, header http.Header) Connection
+}
+
+// NewConnectionWithHandshake creates a connection of c with the handshake header if c is a HandshakeConnector.
+// Otherwise, the connection is created with NewConnection.
+func NewConnectionWithHandshake(c Client, conn *websocket.Conn, header http.Header) Connection {
+	if connector, ok := c.(HandshakeConnector); ok {
+		return connector.NewConnectionWithHandshake(conn, header)
+	}
+	return c.NewConnection(conn)
+}
+
+// compressMessage reports whether the message should be compressed.
+func (s CompressionState) compressMessage(msg Message) bool {
+	if !s.Enabled {
+		return false
+	}
+
+	switch msg.compression {
+	case compressionOn:
+		return true
+	case compressionOff:
+		return false
+	default:
+		return len(msg.Message) >= s.MinSize
+	}
+}
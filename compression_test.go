@@ -0,0 +1,161 @@
+package wsocket
+
+import (
+	"compress/flate"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithCompressionLevel_InvalidLevel(t *testing.T) {
+	assert.Panics(t, func() {
+		WithCompressionLevel(flate.BestCompression+1, 0)
+	})
+}
+
+func TestCompressionState_CompressMessage(t *testing.T) {
+	enabled := CompressionState{Negotiated: true, Enabled: true, MinSize: 10}
+
+	tests := []struct {
+		name     string
+		state    CompressionState
+		msg      Message
+		expected bool
+	}{
+		{
+			name:     "disabled",
+			state:    CompressionState{Negotiated: true},
+			msg:      NewTextMessage([]byte(strings.Repeat("a", 100))),
+			expected: false,
+		},
+		{
+			name:     "below min size",
+			state:    enabled,
+			msg:      NewTextMessage([]byte("short")),
+			expected: false,
+		},
+		{
+			name:     "above min size",
+			state:    enabled,
+			msg:      NewTextMessage([]byte(strings.Repeat("a", 100))),
+			expected: true,
+		},
+		{
+			name:     "forced on",
+			state:    enabled,
+			msg:      NewTextMessage([]byte("short")).WithCompression(true),
+			expected: true,
+		},
+		{
+			name:     "forced off",
+			state:    enabled,
+			msg:      NewTextMessage([]byte(strings.Repeat("a", 100))).WithCompression(false),
+			expected: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tt.state.compressMessage(tt.msg))
+		})
+	}
+}
+
+func TestConnection_Compression(t *testing.T) {
+	tests := []struct {
+		name               string
+		dialerCompression  bool
+		expectedNegotiated bool
+	}{
+		{
+			name:               "negotiated",
+			dialerCompression:  true,
+			expectedNegotiated: true,
+		},
+		{
+			name:               "not negotiated",
+			dialerCompression:  false,
+			expectedNegotiated: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload := strings.Repeat("compressible ", 100)
+			received := make(chan string, 1)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				upgrader := websocket.Upgrader{EnableCompression: true}
+				conn, err := upgrader.Upgrade(w, r, nil)
+				assert.NoError(t, err)
+				defer conn.Close()
+
+				_, msg, err := conn.ReadMessage()
+				assert.NoError(t, err)
+				received <- string(msg)
+			}))
+			defer server.Close()
+
+			dialer := websocket.Dialer{EnableCompression: tt.dialerCompression}
+			clientConn, resp, err := dialer.Dial("ws"+server.URL[4:], nil)
+			assert.NoError(t, err)
+			defer clientConn.Close()
+
+			client := NewClient(context.Background(), NewJSONResolver("type"), NoLogger(), 10, WithCompressionLevel(flate.BestSpeed, 64))
+			conn := NewConnectionWithHandshake(client, clientConn, resp.Header)
+
+			assert.Equal(t, CompressionState{
+				Negotiated: tt.expectedNegotiated,
+				Enabled:    tt.expectedNegotiated,
+				Level:      flate.BestSpeed,
+				MinSize:    64,
+			}, ConnectionCompression(conn))
+
+			assert.NoError(t, conn.WriteMessage(NewTextMessage([]byte(payload))))
+			assert.Equal(t, payload, <-received)
+		})
+	}
+}
+
+func TestCompressionNegotiated(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   http.Header
+		expected bool
+	}{
+		{
+			name:     "nil header",
+			header:   nil,
+			expected: false,
+		},
+		{
+			name:     "permessage-deflate",
+			header:   http.Header{"Sec-Websocket-Extensions": {"permessage-deflate; server_no_context_takeover; client_no_context_takeover"}},
+			expected: true,
+		},
+		{
+			name:     "listed after another extension",
+			header:   http.Header{"Sec-Websocket-Extensions": {"x-custom, Permessage-Deflate"}},
+			expected: true,
+		},
+		{
+			name:     "other extension",
+			header:   http.Header{"Sec-Websocket-Extensions": {"x-webkit-deflate-frame"}},
+			expected: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, CompressionNegotiated(tt.header))
+		})
+	}
+}
+
+func TestConnectionCompression_Unknown(t *testing.T) {
+	assert.Equal(t, CompressionState{}, ConnectionCompression(newTestConnection()))
+}
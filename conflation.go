@@ -0,0 +1,63 @@
+package wsocket
+
+// WithConflationKey returns a copy of the message conflated by the key.
+// While a message with the key is queued, writing another message with the same key replaces it in place,
+// so a slow connection receives only the latest value per key, e.g. the latest price per instrument, without blocking the writer.
+// The replacing message keeps the queue position and the priority of the queued one.
+func (m Message) WithConflationKey(key string) Message {
+	m.conflationKey = key
+	return m
+}
+
+// writeConflated queues the message or replaces the queued message with the same conflation key.
+// The queue holds a placeholder carrying only the key, the message itself is kept in conflated until it is written.
+// If block is false and the queue is full, the message is discarded and errWriteQueueFull is returned.
+func (c *connection) writeConflated(message Message, block bool) error {
+	select {
+	case <-c.closedChan:
+		return ErrConnectionClosed
+	default:
+	}
+
+	key := message.conflationKey
+	placeholder := Message{conflationKey: key}
+
+	c.conflationMu.Lock()
+	if _, queued := c.conflated[key]; queued {
+		c.conflated[key] = message
+		c.conflationMu.Unlock()
+		return nil
+	}
+	c.conflated[key] = message
+
+	if !block {
+		// The placeholder is queued under the lock, so no other writer replaces a message that is discarded.
+		err := c.enqueue(message.priority.lane(), placeholder, false)
+		if err != nil {
+			delete(c.conflated, key)
+		}
+		c.conflationMu.Unlock()
+		return err
+	}
+	c.conflationMu.Unlock()
+
+	// Waiting for the queue under the lock would block the writer resolving the placeholders.
+	// The placeholder can only fail to be queued if the connection is closed, the replacing messages are discarded with it.
+	err := c.enqueue(message.priority.lane(), placeholder, true)
+	if err != nil {
+		c.conflationMu.Lock()
+		delete(c.conflated, key)
+		c.conflationMu.Unlock()
+	}
+	return err
+}
+
+// resolveConflated replaces a conflation placeholder taken from the queue with the latest message for its key.
+func (c *connection) resolveConflated(placeholder Message) Message {
+	c.conflationMu.Lock()
+	defer c.conflationMu.Unlock()
+
+	msg := c.conflated[placeholder.conflationKey]
+	delete(c.conflated, placeholder.conflationKey)
+	return msg
+}
@@ -0,0 +1,77 @@
+package wsocket
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnection_WriteConflated(t *testing.T) {
+	c := newTestQueue(DefaultStarvationLimit, 2)
+
+	assert.NoError(t, c.WriteMessage(NewTextMessage([]byte("EURUSD 1.1")).WithConflationKey("EURUSD")))
+	assert.NoError(t, c.WriteMessage(NewTextMessage([]byte("GBPUSD 1.3")).WithConflationKey("GBPUSD")))
+	// The queue is full, but conflated messages replace the queued ones without blocking.
+	assert.NoError(t, c.WriteMessage(NewTextMessage([]byte("EURUSD 1.2")).WithConflationKey("EURUSD")))
+	assert.NoError(t, c.WriteMessage(NewTextMessage([]byte("EURUSD 1.3")).WithConflationKey("EURUSD")))
+
+	actual := make([]string, 0, 2)
+	for {
+		msg, ok := c.nextMessage(false, nil)
+		if !ok {
+			break
+		}
+		actual = append(actual, string(msg.Message))
+	}
+	assert.Equal(t, []string{"EURUSD 1.3", "GBPUSD 1.3"}, actual)
+	assert.Empty(t, c.conflated)
+
+	assert.NoError(t, c.WriteMessage(NewTextMessage([]byte("EURUSD 1.4")).WithConflationKey("EURUSD")))
+	msg, ok := c.nextMessage(false, nil)
+	assert.True(t, ok)
+	assert.Equal(t, "EURUSD 1.4", string(msg.Message), "Expected a new message to be queued after the previous one is written")
+
+	close(c.closedChan)
+	assert.ErrorIs(t, c.WriteMessage(NewTextMessage([]byte("EURUSD 1.5")).WithConflationKey("EURUSD")), ErrConnectionClosed)
+}
+
+func TestConnection_WriteConflated_QueueFull(t *testing.T) {
+	c := newTestQueue(DefaultStarvationLimit, 1)
+	assert.NoError(t, c.tryWriteMessage(NewTextMessage([]byte("EURUSD 1.1")).WithConflationKey("EURUSD")))
+
+	// Every writer either replaces the queued message or gets an error, no accepted message is discarded.
+	var mu sync.Mutex
+	accepted := make(map[string]bool)
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := "EURUSD"
+			if i%2 == 0 {
+				key = "GBPUSD"
+			}
+			msg := key + " " + strconv.Itoa(i)
+			err := c.tryWriteMessage(NewTextMessage([]byte(msg)).WithConflationKey(key))
+			if err != nil {
+				assert.True(t, errors.Is(err, errWriteQueueFull))
+				return
+			}
+			mu.Lock()
+			accepted[msg] = true
+			mu.Unlock()
+		}(i)
+	}
+	wg.Wait()
+
+	assert.NotContains(t, c.conflated, "GBPUSD", "Expected the message that didn't fit into the queue to be discarded")
+	msg, ok := c.nextMessage(false, nil)
+	assert.True(t, ok)
+	assert.True(t, string(msg.Message) == "EURUSD 1.1" || accepted[string(msg.Message)])
+	for m := range accepted {
+		assert.Contains(t, m, "EURUSD", "Expected only the queued key to accept messages")
+	}
+}
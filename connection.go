@@ -1,8 +1,15 @@
 package wsocket
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"net/http"
+	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
@@ -11,9 +18,36 @@ type ResponseWriter interface {
 	// WriteMessage writes a message to the connection.
 	// If the message type is 0, it is set to websocket.TextMessage.
 	// If the message type is not websocket.TextMessage, websocket.BinaryMessage or websocket.CloseMessage, an error is returned.
+	// If the connection is closed, ErrConnectionClosed is returned.
 	WriteMessage(msg Message) error
 }
 
+// StreamWriter is implemented by the ResponseWriters that can write a message without buffering it,
+// e.g. the connections created by a Client.
+type StreamWriter interface {
+	// NextWriter returns a writer for the next message of the type, websocket.TextMessage or websocket.BinaryMessage.
+	// The message is sent in order with the messages written by WriteMessage, which wait until the writer is closed.
+	// The writer must be closed to send the message. A writer nothing is written to for 30 seconds is closed
+	// by the connection, so a forgotten writer doesn't block it, and the later writes fail.
+	NextWriter(msgType int) (io.WriteCloser, error)
+}
+
+// NextWriter returns a writer for the next message of rw of the type, websocket.TextMessage or websocket.BinaryMessage.
+// If rw is a StreamWriter, the message is streamed to the connection. Otherwise, it is buffered and written
+// with WriteMessage when the writer is closed. The writer must be closed to send the message.
+func NextWriter(rw ResponseWriter, msgType int) (io.WriteCloser, error) {
+	if w, ok := rw.(StreamWriter); ok {
+		return w.NextWriter(msgType)
+	}
+
+	switch msgType {
+	case websocket.TextMessage, websocket.BinaryMessage:
+		return &bufferedMessageWriter{msg: Message{msgType: msgType}, rw: rw}, nil
+	default:
+		return nil, fmt.Errorf("invalid message type: %d", msgType)
+	}
+}
+
 type Connection interface {
 	ResponseWriter
 
@@ -25,29 +59,155 @@ type Connection interface {
 	Wait() <-chan struct{}
 }
 
+// ReasonCloser is implemented by the connections that can be closed with a close code and a reason,
+// e.g. the connections created by a Client and Session.
+type ReasonCloser interface {
+	// CloseWithReason closes the connection with a close code, e.g. websocket.ClosePolicyViolation, and a reason.
+	CloseWithReason(code int, reason string) error
+}
+
+// CloseWithReason closes conn with the close code and the reason if it implements ReasonCloser.
+// Otherwise, conn is closed with Close.
+func CloseWithReason(conn Connection, code int, reason string) error {
+	if closer, ok := conn.(ReasonCloser); ok {
+		return closer.CloseWithReason(code, reason)
+	}
+	return conn.Close()
+}
+
+// closeWriteTimeout is the time allowed to write the close message.
+const closeWriteTimeout = time.Second
+
+// ErrConnectionClosed is returned when a message is written to a closed connection.
+var ErrConnectionClosed = errors.New("connection closed")
+
+// ErrDuplicateMessage is returned when a message that was already handled is received again,
+// e.g. by ReliableReceiver and Deduplicator. The client doesn't log it.
+var ErrDuplicateMessage = errors.New("duplicate message")
+
+type connectionContextKey struct{}
+
+// ConnectionFromContext returns the connection the message being handled was received from.
+func ConnectionFromContext(ctx context.Context) (Connection, bool) {
+	conn, ok := ctx.Value(connectionContextKey{}).(Connection)
+	return conn, ok
+}
+
+func withConnection(ctx context.Context, conn Connection) context.Context {
+	return context.WithValue(ctx, connectionContextKey{}, conn)
+}
+
+// connectionConfig holds the settings of the connections created by a client.
+type connectionConfig struct {
+	// writeChanSize is the size of the write queue of every priority.
+	writeChanSize   int
+	starvationLimit int
+
+	compression        bool
+	compressionLevel   int
+	compressionMinSize int
+	// handshake is the handshake header of the connection, nil if it is not known.
+	handshake http.Header
+
+	batcher *WriteBatcher
+	acks    *AckDelivery
+
+	// writerIdleTimeout is the time a writer returned by NextWriter is kept open without writes.
+	// defaultWriterIdleTimeout is used if it is 0.
+	writerIdleTimeout time.Duration
+}
+
+// defaultWriterIdleTimeout is the time a writer returned by NextWriter is kept open without writes.
+const defaultWriterIdleTimeout = 30 * time.Second
+
+// errWriterIdle is returned by the writes to a writer closed by the connection after the idle timeout.
+var errWriterIdle = errors.New("message writer closed after idle timeout")
+
 type connection struct {
 	logger Logger
 
-	conn       *websocket.Conn
-	closedChan chan struct{}
+	conn        *websocket.Conn
+	closedChan  chan struct{}
+	compression CompressionState
 
-	writeChan chan Message
+	// lanes are the write queues, from the highest priority to the lowest.
+	// skipped and starvationLimit are used only by the message writer.
+	lanes           [laneCount]chan Message
+	skipped         [laneCount]int
+	starvationLimit int
+	batcher         *WriteBatcher
+	acks            *AckDelivery
+
+	writerIdleTimeout time.Duration
+
+	conflationMu sync.Mutex
+	conflated    map[string]Message
 }
 
-func newConnection(logger Logger, conn *websocket.Conn, writeChanSize int) *connection {
+func newConnection(logger Logger, conn *websocket.Conn, config connectionConfig) *connection {
 	c := &connection{
 		logger:     logger,
 		conn:       conn,
 		closedChan: make(chan struct{}),
-		writeChan:  make(chan Message, writeChanSize),
+		batcher:    config.batcher,
+		acks:       config.acks,
+		conflated:  make(map[string]Message),
 	}
 
+	for lane := range c.lanes {
+		c.lanes[lane] = make(chan Message, config.writeChanSize)
+	}
+	c.starvationLimit = config.starvationLimit
+	if c.starvationLimit == 0 {
+		c.starvationLimit = DefaultStarvationLimit
+	}
+	c.writerIdleTimeout = config.writerIdleTimeout
+	if c.writerIdleTimeout == 0 {
+		c.writerIdleTimeout = defaultWriterIdleTimeout
+	}
+
+	c.compression.Negotiated = CompressionNegotiated(config.handshake)
+	if config.compression {
+		c.compression.Enabled = c.compression.Negotiated || config.handshake == nil
+		c.compression.Level = config.compressionLevel
+		c.compression.MinSize = config.compressionMinSize
+		// The level is validated by WithCompressionLevel.
+		_ = conn.SetCompressionLevel(config.compressionLevel)
+	}
+	conn.EnableWriteCompression(c.compression.Enabled)
+
 	go c.messageWriter()
 
 	return c
 }
 
 func (c *connection) WriteMessage(message Message) error {
+	return c.writeMessage(message, true)
+}
+
+// errWriteQueueFull is returned by tryWriteMessage when the write queue of the message priority is full.
+var errWriteQueueFull = errors.New("write queue full")
+
+// queueWriter is implemented by the connections that can queue a message without waiting for the write queue.
+type queueWriter interface {
+	// tryWriteMessage writes the message like WriteMessage, but returns errWriteQueueFull instead of waiting.
+	tryWriteMessage(msg Message) error
+}
+
+// writeNonBlocking writes msg to rw without waiting for the write queue if rw is a queueWriter.
+func writeNonBlocking(rw ResponseWriter, msg Message) error {
+	if w, ok := rw.(queueWriter); ok {
+		return w.tryWriteMessage(msg)
+	}
+	return rw.WriteMessage(msg)
+}
+
+func (c *connection) tryWriteMessage(message Message) error {
+	return c.writeMessage(message, false)
+}
+
+// writeMessage validates the message and queues it. If block is false and the queue is full, errWriteQueueFull is returned.
+func (c *connection) writeMessage(message Message, block bool) error {
 	if message.msgType == 0 {
 		message.msgType = websocket.TextMessage
 	}
@@ -58,27 +218,218 @@ func (c *connection) WriteMessage(message Message) error {
 		return fmt.Errorf("invalid message type: %d", message.msgType)
 	}
 
-	c.writeChan <- message
-	return nil
+	if message.conflationKey != "" {
+		return c.writeConflated(message, block)
+	}
+	return c.enqueue(message.priority.lane(), message, block)
 }
 
-func (c *connection) messageWriter() {
+// enqueue adds the message to the write queue of the lane. If block is false and the queue is full, errWriteQueueFull is returned.
+func (c *connection) enqueue(lane int, message Message, block bool) error {
+	if !block {
+		select {
+		case c.lanes[lane] <- message:
+			return nil
+		case <-c.closedChan:
+			return ErrConnectionClosed
+		default:
+			return errWriteQueueFull
+		}
+	}
+
+	select {
+	case c.lanes[lane] <- message:
+		return nil
+	case <-c.closedChan:
+		return ErrConnectionClosed
+	}
+}
+
+// writerRequest is a request of NextWriter waiting in the write queue.
+type writerRequest struct {
+	msgType int
+	result  chan writerResult
+}
+
+type writerResult struct {
+	w   *connectionWriter
+	err error
+}
+
+func (c *connection) NextWriter(msgType int) (io.WriteCloser, error) {
+	switch msgType {
+	case websocket.TextMessage, websocket.BinaryMessage:
+		// Do nothing
+	default:
+		return nil, fmt.Errorf("invalid message type: %d", msgType)
+	}
+
+	req := &writerRequest{
+		msgType: msgType,
+		result:  make(chan writerResult, 1),
+	}
+
+	select {
+	case c.lanes[laneNormal] <- Message{msgType: msgType, writer: req}:
+	case <-c.closedChan:
+		return nil, ErrConnectionClosed
+	}
+
+	select {
+	case result := <-req.result:
+		if result.err != nil {
+			return nil, result.err
+		}
+		return result.w, nil
+	case <-c.closedChan:
+		return nil, ErrConnectionClosed
+	}
+}
+
+// connectionWriter releases the message writer when it is closed.
+type connectionWriter struct {
+	mu sync.Mutex
+	w  io.WriteCloser
+	// err is returned by the writes after the writer is released.
+	err  error
+	done chan struct{}
+	// written is signaled on every write to keep the writer open.
+	written chan struct{}
+}
+
+func newConnectionWriter(w io.WriteCloser) *connectionWriter {
+	return &connectionWriter{
+		w:       w,
+		done:    make(chan struct{}),
+		written: make(chan struct{}, 1),
+	}
+}
+
+func (w *connectionWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.err != nil {
+		return 0, w.err
+	}
+	select {
+	case w.written <- struct{}{}:
+	default:
+	}
+	return w.w.Write(p)
+}
+
+func (w *connectionWriter) Close() error {
+	return w.release(io.ErrClosedPipe)
+}
+
+// release closes the message writer. The later writes return err.
+func (w *connectionWriter) release(err error) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.err != nil {
+		return w.err
+	}
+	w.err = err
+	// The message writer continues once done is closed, so the websocket writer must be closed first.
+	closeErr := w.w.Close()
+	close(w.done)
+	return closeErr
+}
+
+// bufferedMessageWriter buffers a message and writes it to rw when it is closed.
+// It is returned by NextWriter for the ResponseWriters that are not StreamWriters.
+type bufferedMessageWriter struct {
+	buf bytes.Buffer
+	msg Message
+	rw  ResponseWriter
+}
+
+func (w *bufferedMessageWriter) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+func (w *bufferedMessageWriter) Close() error {
+	w.msg.Message = w.buf.Bytes()
+	return w.rw.WriteMessage(w.msg)
+}
+
+// serveWriter hands the websocket writer to NextWriter and waits until it is closed.
+// If nothing is written to the writer for writerIdleTimeout, it is closed, so the other messages are not blocked.
+func (c *connection) serveWriter(req *writerRequest) error {
+	ws, err := c.conn.NextWriter(req.msgType)
+	if err != nil {
+		req.result <- writerResult{err: err}
+		return err
+	}
+	w := newConnectionWriter(ws)
+	req.result <- writerResult{w: w}
+
+	idle := time.NewTimer(c.writerIdleTimeout)
+	defer idle.Stop()
 	for {
 		select {
+		case <-w.done:
+			return nil
+		case <-w.written:
+			if !idle.Stop() {
+				<-idle.C
+			}
+			idle.Reset(c.writerIdleTimeout)
+		case <-idle.C:
+			c.logger.Printf("message writer not closed within %s, closing it", c.writerIdleTimeout)
+			// The message written so far is sent, an error means the websocket connection failed.
+			return w.release(errWriterIdle)
 		case <-c.closedChan:
+			_ = w.release(ErrConnectionClosed)
+			return nil
+		}
+	}
+}
+
+func (c *connection) messageWriter() {
+	for {
+		msg, ok := c.nextMessage(true, nil)
+		if !ok {
+			return
+		}
+
+		var err error
+		if c.batcher != nil && batchable(msg) {
+			err = c.writeBatch(msg)
+		} else {
+			err = c.write(msg)
+		}
+		if err != nil {
+			c.logger.Printf("failed to write message: %v", err)
 			return
-		case msg := <-c.writeChan:
-			err := c.conn.WriteMessage(msg.msgType, msg.Message)
-			if err != nil {
-				c.logger.Printf("failed to write message: %v", err)
-				return
-			}
 		}
 	}
 }
 
+// write writes a message taken from the write queue.
+func (c *connection) write(msg Message) error {
+	if msg.writer != nil {
+		if c.compression.Enabled {
+			c.conn.EnableWriteCompression(true)
+		}
+		return c.serveWriter(msg.writer)
+	}
+
+	if c.compression.Enabled {
+		c.conn.EnableWriteCompression(c.compression.compressMessage(msg))
+	}
+	return c.conn.WriteMessage(msg.msgType, msg.Message)
+}
+
 func (c *connection) Close() error {
-	err := c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	return c.CloseWithReason(websocket.CloseNormalClosure, "")
+}
+
+func (c *connection) CloseWithReason(code int, reason string) error {
+	// WriteControl can be called concurrently with the message writer.
+	err := c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(closeWriteTimeout))
 	if err != nil {
 		log.Println("failed to close connection:", err)
 	}
@@ -89,3 +440,18 @@ func (c *connection) Close() error {
 func (c *connection) Wait() <-chan struct{} {
 	return c.closedChan
 }
+
+func (c *connection) Compression() CompressionState {
+	return c.compression
+}
+
+func (c *connection) SendWithAck(ctx context.Context, msg Message) error {
+	if c.acks == nil {
+		return ErrAckDeliveryDisabled
+	}
+	return c.acks.Send(ctx, c, msg)
+}
+
+func (c *connection) ackDelivery() *AckDelivery {
+	return c.acks
+}
@@ -47,7 +47,7 @@ func TestConnection_Write(t *testing.T) {
 	assert.NoError(t, err)
 	defer conn.Close()
 
-	wsConn := newConnection(DefaultLogger(), conn, 10)
+	wsConn := newConnection(DefaultLogger(), conn, connectionConfig{writeChanSize: 10})
 
 	err = wsConn.WriteMessage(message)
 	assert.NoError(t, err)
@@ -86,7 +86,7 @@ func TestConnection_Close(t *testing.T) {
 	assert.NoError(t, err)
 	defer conn.Close()
 
-	wsConn := newConnection(DefaultLogger(), conn, 10)
+	wsConn := newConnection(DefaultLogger(), conn, connectionConfig{writeChanSize: 10})
 
 	err = wsConn.Close()
 	assert.NoError(t, err)
@@ -97,3 +97,123 @@ func TestConnection_Close(t *testing.T) {
 	assert.True(t, serverClosed)
 	serverClosedMutex.Unlock()
 }
+
+func TestConnection_NextWriter(t *testing.T) {
+	received := make(chan []string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := websocket.Upgrader{}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		assert.NoError(t, err)
+		defer conn.Close()
+
+		messages := make([]string, 0, 2)
+		for i := 0; i < 2; i++ {
+			_, msg, err := conn.ReadMessage()
+			assert.NoError(t, err)
+			messages = append(messages, string(msg))
+		}
+		received <- messages
+	}))
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+server.URL[4:], nil)
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	wsConn := newConnection(NoLogger(), conn, connectionConfig{writeChanSize: 10})
+
+	_, err = wsConn.NextWriter(websocket.PingMessage)
+	assert.Error(t, err)
+
+	w, err := wsConn.NextWriter(websocket.TextMessage)
+	assert.NoError(t, err)
+
+	// The message is queued until the writer is closed.
+	assert.NoError(t, wsConn.WriteMessage(NewTextMessage([]byte("second"))))
+
+	_, err = w.Write([]byte("first, "))
+	assert.NoError(t, err)
+	_, err = w.Write([]byte("streamed"))
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+
+	select {
+	case messages := <-received:
+		assert.Equal(t, []string{"first, streamed", "second"}, messages)
+	case <-time.After(time.Second):
+		t.Fatal("Expected the server to receive the messages")
+	}
+}
+
+func TestConnection_NextWriter_IdleTimeout(t *testing.T) {
+	received := make(chan []string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := websocket.Upgrader{}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		assert.NoError(t, err)
+		defer conn.Close()
+
+		messages := make([]string, 0, 2)
+		for i := 0; i < 2; i++ {
+			_, msg, err := conn.ReadMessage()
+			assert.NoError(t, err)
+			messages = append(messages, string(msg))
+		}
+		received <- messages
+	}))
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+server.URL[4:], nil)
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	wsConn := newConnection(NoLogger(), conn, connectionConfig{writeChanSize: 10, writerIdleTimeout: 50 * time.Millisecond})
+
+	w, err := NextWriter(wsConn, websocket.TextMessage)
+	assert.NoError(t, err)
+	_, err = w.Write([]byte("forgotten"))
+	assert.NoError(t, err)
+	assert.NoError(t, wsConn.WriteMessage(NewTextMessage([]byte("next"))))
+
+	select {
+	case messages := <-received:
+		assert.Equal(t, []string{"forgotten", "next"}, messages, "Expected the idle writer to be closed")
+	case <-time.After(time.Second):
+		t.Fatal("Expected the server to receive the messages")
+	}
+
+	_, err = w.Write([]byte("late"))
+	assert.ErrorIs(t, err, errWriterIdle)
+}
+
+func TestNextWriter_Buffered(t *testing.T) {
+	rw := &testResponseWriter{}
+
+	_, err := NextWriter(rw, websocket.PingMessage)
+	assert.Error(t, err)
+
+	w, err := NextWriter(rw, websocket.BinaryMessage)
+	assert.NoError(t, err)
+	_, err = w.Write([]byte("buffered"))
+	assert.NoError(t, err)
+	assert.Nil(t, rw.GetWrittenMessage(), "Expected the message to be buffered until the writer is closed")
+
+	assert.NoError(t, w.Close())
+	assert.Equal(t, Message{msgType: websocket.BinaryMessage, Message: []byte("buffered")}, *rw.GetWrittenMessage())
+}
+
+func TestCloseWithReason(t *testing.T) {
+	conn := newTestConnection()
+	assert.NoError(t, CloseWithReason(conn, websocket.ClosePolicyViolation, "policy"))
+	assert.Equal(t, websocket.ClosePolicyViolation, conn.CloseCode())
+
+	// The embedded interface hides CloseWithReason of the test connection.
+	plain := newTestConnection()
+	assert.NoError(t, CloseWithReason(struct{ Connection }{plain}, websocket.ClosePolicyViolation, "policy"))
+	assert.Zero(t, plain.CloseCode())
+	select {
+	case <-plain.Wait():
+	default:
+		t.Fatal("Expected a connection without CloseWithReason to be closed")
+	}
+}
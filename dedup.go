@@ -0,0 +1,326 @@
+package wsocket
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// DedupAction is the action taken when a duplicate message is received.
+type DedupAction int
+
+const (
+	// DedupDrop drops duplicates with ErrDuplicateMessage.
+	DedupDrop DedupAction = iota
+	// DedupReplay drops duplicates and writes the response of the first message again.
+	// A duplicate received while the first message is still handled is dropped with ErrDuplicateMessage.
+	DedupReplay
+)
+
+// DedupScope returns the key within which message IDs must be unique, and false if the message is not deduplicated.
+type DedupScope func(ctx context.Context) (string, bool)
+
+// DedupPerConnection remembers message IDs per connection. With a SessionManager, IDs are remembered for the whole session.
+// Every connection gets a random ID, so the scopes of the connections of different nodes sharing a store don't collide.
+func DedupPerConnection() DedupScope {
+	var mu sync.Mutex
+	ids := make(map[Connection]string)
+
+	return func(ctx context.Context) (string, bool) {
+		if session, ok := SessionFromContext(ctx); ok {
+			return session.ID(), true
+		}
+		conn, ok := ConnectionFromContext(ctx)
+		if !ok {
+			return "", false
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if id, ok := ids[conn]; ok {
+			return id, true
+		}
+		id, err := newRandomID()
+		if err != nil {
+			return "", false
+		}
+		ids[conn] = id
+		go func() {
+			<-conn.Wait()
+			mu.Lock()
+			delete(ids, conn)
+			mu.Unlock()
+		}()
+		return id, true
+	}
+}
+
+// DedupPerUser remembers message IDs per user. Messages without a user are not deduplicated.
+func DedupPerUser(userID func(ctx context.Context) string) DedupScope {
+	return func(ctx context.Context) (string, bool) {
+		id := userID(ctx)
+		return id, id != ""
+	}
+}
+
+// StoredMessage is a response message kept by a DedupStore.
+type StoredMessage struct {
+	Type int
+	Data []byte
+	// Priority is the priority the message was written with, it is replayed with the same priority.
+	Priority Priority
+}
+
+// DedupClaim is the result of DedupStore.Claim.
+type DedupClaim struct {
+	// Claimed reports whether the key is recorded by the claim, i.e. the message is received for the first time.
+	Claimed bool
+	// Completed reports whether the first message is handled and its response is saved by Complete.
+	Completed bool
+	// Response is the response of the first message saved by Complete.
+	Response []StoredMessage
+}
+
+// DedupStore remembers the message IDs seen recently and their responses.
+// Implement it to deduplicate messages across nodes, e.g. in Redis.
+type DedupStore interface {
+	// Claim records the key for ttl if it is not recorded yet.
+	// If the key is already recorded, the claim holds the response saved by Complete, if any.
+	Claim(ctx context.Context, key string, ttl time.Duration) (DedupClaim, error)
+	// Complete saves the response of the message with the key.
+	Complete(ctx context.Context, key string, response []StoredMessage, ttl time.Duration) error
+	// Forget removes the key, so the message can be handled again, e.g. after its handler failed.
+	Forget(ctx context.Context, key string) error
+}
+
+// MemoryDedupStore is a DedupStore keeping up to a maximum number of keys in memory, the oldest keys are evicted first.
+type MemoryDedupStore struct {
+	mu sync.Mutex
+
+	maxEntries int
+	entries    map[string]*list.Element
+	order      *list.List
+}
+
+type dedupEntry struct {
+	key       string
+	completed bool
+	response  []StoredMessage
+	expiresAt time.Time
+}
+
+// NewMemoryDedupStore creates a new MemoryDedupStore instance.
+// If maxEntries is less than 1, NewMemoryDedupStore panics.
+func NewMemoryDedupStore(maxEntries int) *MemoryDedupStore {
+	if maxEntries < 1 {
+		panic(fmt.Sprintf("wsocket: invalid dedup store size: %d", maxEntries))
+	}
+
+	return &MemoryDedupStore{
+		maxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+	}
+}
+
+func (s *MemoryDedupStore) Claim(_ context.Context, key string, ttl time.Duration) (DedupClaim, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if element, ok := s.entries[key]; ok {
+		entry := element.Value.(*dedupEntry)
+		if now.Before(entry.expiresAt) {
+			return DedupClaim{Completed: entry.completed, Response: entry.response}, nil
+		}
+		s.removeLocked(element)
+	}
+
+	s.entries[key] = s.order.PushBack(&dedupEntry{key: key, expiresAt: now.Add(ttl)})
+	for s.order.Len() > s.maxEntries {
+		s.removeLocked(s.order.Front())
+	}
+	return DedupClaim{Claimed: true}, nil
+}
+
+func (s *MemoryDedupStore) Complete(_ context.Context, key string, response []StoredMessage, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if element, ok := s.entries[key]; ok {
+		entry := element.Value.(*dedupEntry)
+		entry.completed = true
+		entry.response = response
+		entry.expiresAt = time.Now().Add(ttl)
+	}
+	return nil
+}
+
+func (s *MemoryDedupStore) Forget(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if element, ok := s.entries[key]; ok {
+		s.removeLocked(element)
+	}
+	return nil
+}
+
+// Len returns the number of remembered keys, including the expired ones not evicted yet.
+func (s *MemoryDedupStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.order.Len()
+}
+
+func (s *MemoryDedupStore) removeLocked(element *list.Element) {
+	delete(s.entries, element.Value.(*dedupEntry).key)
+	s.order.Remove(element)
+}
+
+// Deduplicator handles a message with the same ID only once, e.g. when clients retry sends after a reconnect.
+// Use Wrap to deduplicate all messages of a resolver, or WithDeduplication to deduplicate the messages of a JSONResolver route.
+type Deduplicator struct {
+	idPath []string
+	scope  DedupScope
+	action DedupAction
+	store  DedupStore
+	ttl    time.Duration
+}
+
+// NewDeduplicator creates a new Deduplicator instance.
+// idField is the JSON field holding the message ID, nested fields are separated by '.', e.g. "meta.id". Messages without an ID are not deduplicated.
+// ttl is how long IDs and responses are remembered.
+// scope groups the IDs, if nil, DedupPerConnection is used.
+// store remembers the IDs, if nil, a MemoryDedupStore of 10000 entries is used.
+func NewDeduplicator(idField string, scope DedupScope, action DedupAction, store DedupStore, ttl time.Duration) *Deduplicator {
+	if scope == nil {
+		scope = DedupPerConnection()
+	}
+	if store == nil {
+		store = NewMemoryDedupStore(10000)
+	}
+
+	return &Deduplicator{
+		idPath: strings.Split(idField, "."),
+		scope:  scope,
+		action: action,
+		store:  store,
+		ttl:    ttl,
+	}
+}
+
+// Wrap returns a resolver deduplicating the messages before they are passed to next.
+func (d *Deduplicator) Wrap(next Resolver) Resolver {
+	return dedupResolver{dedup: d, next: next}
+}
+
+type dedupResolver struct {
+	dedup *Deduplicator
+	next  Resolver
+}
+
+func (r dedupResolver) Handle(ctx context.Context, msg []byte, rw ResponseWriter) error {
+	return r.dedup.handle(ctx, msg, rw, r.next.Handle)
+}
+
+// WithDeduplication deduplicates the messages of a JSONResolver route.
+func WithDeduplication(d *Deduplicator) RouteOption {
+	return func(c *routeConfig) {
+		c.middlewares = append(c.middlewares, func(_ string, next Handler) Handler {
+			return func(ctx context.Context, msg []byte, rw ResponseWriter) error {
+				return d.handle(ctx, msg, rw, next)
+			}
+		})
+	}
+}
+
+func (d *Deduplicator) handle(ctx context.Context, msg []byte, rw ResponseWriter, next Handler) error {
+	key, ok := d.key(ctx, msg)
+	if !ok {
+		return next(ctx, msg, rw)
+	}
+
+	claim, err := d.store.Claim(ctx, key, d.ttl)
+	if err != nil {
+		return err
+	}
+	if !claim.Claimed {
+		if d.action == DedupReplay && claim.Completed {
+			for _, stored := range claim.Response {
+				if err := rw.WriteMessage(Message{msgType: stored.Type, Message: stored.Data, priority: stored.Priority}); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		return ErrDuplicateMessage
+	}
+
+	recorder := &recordingResponseWriter{rw: rw}
+	if err := next(ctx, msg, recorder); err != nil {
+		if forgetErr := d.store.Forget(ctx, key); forgetErr != nil {
+			return fmt.Errorf("%w (failed to forget message: %v)", err, forgetErr)
+		}
+		return err
+	}
+
+	if d.action != DedupReplay {
+		return nil
+	}
+	return d.store.Complete(ctx, key, recorder.messages(), d.ttl)
+}
+
+// key returns the store key of the message, made of the scope and the message ID.
+func (d *Deduplicator) key(ctx context.Context, msg []byte) (string, bool) {
+	scope, ok := d.scope(ctx)
+	if !ok {
+		return "", false
+	}
+
+	jsonMsg, err := ParseJSONMessage(ctx, msg)
+	if err != nil {
+		return "", false
+	}
+	id, ok := jsonValueString(jsonMsg.Get(d.idPath...))
+	if !ok || id == "" {
+		return "", false
+	}
+
+	return CompositeKey(scope, id), true
+}
+
+// recordingResponseWriter records the messages written by a handler.
+type recordingResponseWriter struct {
+	mu       sync.Mutex
+	rw       ResponseWriter
+	recorded []StoredMessage
+}
+
+func (w *recordingResponseWriter) WriteMessage(msg Message) error {
+	if err := w.rw.WriteMessage(msg); err != nil {
+		return err
+	}
+
+	msgType := msg.msgType
+	if msgType == 0 {
+		msgType = websocket.TextMessage
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	// The handler may reuse the buffer of the message after it is written.
+	w.recorded = append(w.recorded, StoredMessage{Type: msgType, Data: append([]byte(nil), msg.Message...), Priority: msg.priority})
+	return nil
+}
+
+func (w *recordingResponseWriter) messages() []StoredMessage {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.recorded
+}
@@ -0,0 +1,192 @@
+package wsocket
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryDedupStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryDedupStore(2)
+
+	claim, err := store.Claim(ctx, "a", time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, DedupClaim{Claimed: true}, claim)
+
+	claim, err = store.Claim(ctx, "a", time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, DedupClaim{}, claim, "Expected an in-flight key not to be completed")
+
+	response := []StoredMessage{{Type: 1, Data: []byte("reply")}}
+	assert.NoError(t, store.Complete(ctx, "a", response, time.Minute))
+
+	claim, err = store.Claim(ctx, "a", time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, DedupClaim{Completed: true, Response: response}, claim)
+
+	assert.NoError(t, store.Forget(ctx, "a"))
+	claim, _ = store.Claim(ctx, "a", time.Minute)
+	assert.True(t, claim.Claimed, "Expected a forgotten key to be claimed again")
+
+	claim, _ = store.Claim(ctx, "expired", -time.Second)
+	assert.True(t, claim.Claimed)
+	claim, _ = store.Claim(ctx, "expired", time.Minute)
+	assert.True(t, claim.Claimed, "Expected an expired key to be claimed again")
+
+	_, _ = store.Claim(ctx, "b", time.Minute)
+	assert.Equal(t, 2, store.Len())
+	claim, _ = store.Claim(ctx, "a", time.Minute)
+	assert.True(t, claim.Claimed, "Expected the oldest key to be evicted")
+
+	assert.Panics(t, func() { NewMemoryDedupStore(0) })
+}
+
+func TestDeduplicator(t *testing.T) {
+	tests := []struct {
+		name             string
+		action           DedupAction
+		msg              string
+		expectedErr      error
+		expectedHandled  int
+		expectedMessages []string
+	}{
+		{
+			name:             "drop",
+			action:           DedupDrop,
+			msg:              `{"type":"order","meta":{"id":"42"}}`,
+			expectedErr:      ErrDuplicateMessage,
+			expectedHandled:  1,
+			expectedMessages: []string{"ok"},
+		},
+		{
+			name:             "replay",
+			action:           DedupReplay,
+			msg:              `{"type":"order","meta":{"id":42}}`,
+			expectedHandled:  1,
+			expectedMessages: []string{"ok", "ok"},
+		},
+		{
+			name:             "no id",
+			action:           DedupDrop,
+			msg:              `{"type":"order"}`,
+			expectedHandled:  2,
+			expectedMessages: []string{"ok", "ok"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handled := 0
+			resolver := NewJSONResolver("type").AddHandler("order", func(ctx context.Context, msg []byte, rw ResponseWriter) error {
+				handled++
+				return rw.WriteMessage(NewTextMessage([]byte("ok")))
+			})
+			dedup := NewDeduplicator("meta.id", DedupPerConnection(), tt.action, nil, time.Minute)
+			wrapped := dedup.Wrap(resolver)
+
+			conn := newTestConnection()
+			ctx := withConnection(context.Background(), conn)
+
+			assert.NoError(t, wrapped.Handle(ctx, []byte(tt.msg), conn))
+			err := wrapped.Handle(ctx, []byte(tt.msg), conn)
+			assert.True(t, errors.Is(err, tt.expectedErr), "Unexpected error: %v", err)
+			assert.Equal(t, tt.expectedHandled, handled)
+			assert.Equal(t, tt.expectedMessages, conn.Messages())
+
+			other := newTestConnection()
+			assert.NoError(t, wrapped.Handle(withConnection(context.Background(), other), []byte(tt.msg), other))
+			assert.Equal(t, []string{"ok"}, other.Messages(), "Expected IDs to be remembered per connection")
+		})
+	}
+}
+
+func TestDeduplicator_ReplayInFlight(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	resolver := NewJSONResolver("type").AddHandler("order", func(ctx context.Context, msg []byte, rw ResponseWriter) error {
+		close(started)
+		<-release
+		buf := []byte("ok")
+		err := rw.WriteMessage(NewTextMessage(buf).WithPriority(PriorityHigh))
+		copy(buf, "xx")
+		return err
+	})
+	dedup := NewDeduplicator("id", nil, DedupReplay, nil, time.Minute)
+	wrapped := dedup.Wrap(resolver)
+
+	conn := newTestConnection()
+	ctx := withConnection(context.Background(), conn)
+	msg := []byte(`{"type":"order","id":"1"}`)
+
+	done := make(chan error, 1)
+	go func() { done <- wrapped.Handle(ctx, msg, conn) }()
+	<-started
+	assert.ErrorIs(t, wrapped.Handle(ctx, msg, conn), ErrDuplicateMessage, "Expected an in-flight duplicate to be dropped")
+
+	close(release)
+	assert.NoError(t, <-done)
+	assert.NoError(t, wrapped.Handle(ctx, msg, conn))
+	messages := conn.Messages()
+	assert.Len(t, messages, 2)
+	assert.Equal(t, "ok", messages[1], "Expected the recorded response to be copied")
+	conn.mu.Lock()
+	assert.Equal(t, PriorityHigh, conn.messages[1].priority, "Expected the response to be replayed with its priority")
+	conn.mu.Unlock()
+}
+
+func TestDedupPerConnection(t *testing.T) {
+	scope := DedupPerConnection()
+	conn, other := newTestConnection(), newTestConnection()
+
+	id, ok := scope(withConnection(context.Background(), conn))
+	assert.True(t, ok)
+	again, _ := scope(withConnection(context.Background(), conn))
+	assert.Equal(t, id, again, "Expected the ID of a connection to be stable")
+	otherID, _ := scope(withConnection(context.Background(), other))
+	assert.NotEqual(t, id, otherID)
+
+	_, ok = scope(context.Background())
+	assert.False(t, ok)
+
+	assert.NoError(t, conn.Close())
+	assert.Eventually(t, func() bool {
+		closed, _ := scope(withConnection(context.Background(), conn))
+		return closed != id
+	}, time.Second, 10*time.Millisecond, "Expected the ID of a closed connection to be forgotten")
+}
+
+func TestDeduplicator_HandlerError(t *testing.T) {
+	fail := true
+	resolver := NewJSONResolver("type").AddHandler("order", func(ctx context.Context, msg []byte, rw ResponseWriter) error {
+		if fail {
+			return errors.New("failed")
+		}
+		return nil
+	})
+	dedup := NewDeduplicator("id", DedupPerUser(func(ctx context.Context) string { return "alice" }), DedupDrop, nil, time.Minute)
+	wrapped := dedup.Wrap(resolver)
+
+	msg := []byte(`{"type":"order","id":"1"}`)
+	assert.Error(t, wrapped.Handle(context.Background(), msg, &testResponseWriter{}))
+
+	fail = false
+	assert.NoError(t, wrapped.Handle(context.Background(), msg, &testResponseWriter{}), "Expected a failed message to be handled again")
+	assert.ErrorIs(t, wrapped.Handle(context.Background(), msg, &testResponseWriter{}), ErrDuplicateMessage)
+}
+
+func TestWithDeduplication(t *testing.T) {
+	handled := 0
+	dedup := NewDeduplicator("id", DedupPerUser(func(ctx context.Context) string { return "alice" }), DedupDrop, nil, time.Minute)
+	resolver := NewJSONResolver("type").AddHandler("order", func(ctx context.Context, msg []byte, rw ResponseWriter) error {
+		handled++
+		return nil
+	}, WithDeduplication(dedup))
+
+	msg := []byte(`{"type":"order","id":"1"}`)
+	assert.NoError(t, resolver.Handle(context.Background(), msg, &testResponseWriter{}))
+	assert.ErrorIs(t, resolver.Handle(context.Background(), msg, &testResponseWriter{}), ErrDuplicateMessage)
+	assert.Equal(t, 1, handled)
+}
@@ -0,0 +1,428 @@
+package wsocket
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/valyala/fastjson"
+)
+
+// Acknowledged delivery message types.
+const (
+	// DeliveryMessageType is the type of the envelope carrying a message that must be acknowledged,
+	// e.g. {"type": "delivery", "id": "4f2a...", "data": {"type": "payment", "status": "paid"}}.
+	DeliveryMessageType = "delivery"
+	// DeliveredMessageType is the type of the message acknowledging a delivery, e.g. {"type": "delivered", "id": "4f2a..."}.
+	DeliveredMessageType = "delivered"
+)
+
+// ErrDeliveryFailed is returned by SendWithAck when the message is not acknowledged after all attempts.
+var ErrDeliveryFailed = errors.New("delivery not acknowledged")
+
+// ErrAckDeliveryDisabled is returned by SendWithAck when the client has no AckDelivery.
+var ErrAckDeliveryDisabled = errors.New("acknowledged delivery is not enabled")
+
+// RetryPolicy configures the attempts of an acknowledged delivery.
+type RetryPolicy struct {
+	// MaxAttempts is the number of times the message is sent.
+	MaxAttempts int
+	// InitialBackoff is how long the first attempt waits for the acknowledgement.
+	InitialBackoff time.Duration
+	// MaxBackoff limits the wait of the later attempts.
+	MaxBackoff time.Duration
+	// Multiplier increases the wait after every attempt.
+	Multiplier float64
+}
+
+// DefaultRetryPolicy makes 5 attempts waiting 1s, 2s, 4s, 8s and 16s for the acknowledgement.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    5,
+	InitialBackoff: time.Second,
+	MaxBackoff:     30 * time.Second,
+	Multiplier:     2,
+}
+
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	backoff := float64(p.InitialBackoff)
+	for i := 1; i < attempt; i++ {
+		backoff *= p.Multiplier
+	}
+	if p.MaxBackoff > 0 && backoff > float64(p.MaxBackoff) {
+		return p.MaxBackoff
+	}
+	return time.Duration(backoff)
+}
+
+// PendingDelivery is a message waiting for an acknowledgement, kept by a DeliveryStore.
+type PendingDelivery struct {
+	ID string
+	// Recipient identifies the receiver across reconnects, e.g. the session or user ID. It is empty if the receiver is unknown.
+	Recipient string
+	// Message is the JSON message being delivered, without the envelope.
+	Message   []byte
+	CreatedAt time.Time
+}
+
+// DeliveryStore persists pending deliveries, so they can be redelivered after a restart.
+type DeliveryStore interface {
+	// Save saves the pending delivery.
+	Save(ctx context.Context, delivery PendingDelivery) error
+	// Delete deletes the pending delivery with the ID.
+	Delete(ctx context.Context, id string) error
+	// List returns the pending deliveries of the recipient in the order they were created.
+	List(ctx context.Context, recipient string) ([]PendingDelivery, error)
+}
+
+// MemoryDeliveryStore is a DeliveryStore keeping pending deliveries in memory.
+type MemoryDeliveryStore struct {
+	mu         sync.Mutex
+	deliveries map[string]PendingDelivery
+}
+
+// NewMemoryDeliveryStore creates a new MemoryDeliveryStore instance.
+func NewMemoryDeliveryStore() *MemoryDeliveryStore {
+	return &MemoryDeliveryStore{deliveries: make(map[string]PendingDelivery)}
+}
+
+func (s *MemoryDeliveryStore) Save(_ context.Context, delivery PendingDelivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deliveries[delivery.ID] = delivery
+	return nil
+}
+
+func (s *MemoryDeliveryStore) Delete(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.deliveries, id)
+	return nil
+}
+
+func (s *MemoryDeliveryStore) List(_ context.Context, recipient string) ([]PendingDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := make([]PendingDelivery, 0)
+	for _, delivery := range s.deliveries {
+		if delivery.Recipient == recipient {
+			result = append(result, delivery)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt.Before(result[j].CreatedAt)
+	})
+	return result, nil
+}
+
+// AckDelivery sends messages that must be acknowledged by the receiver (at-least-once delivery).
+//
+// A message is wrapped in a "delivery" envelope with a unique ID and resent with backoff until the receiver
+// replies with a "delivered" message carrying the same ID. Pending deliveries are saved to the store,
+// use Redeliver to send them again when the recipient reconnects, e.g. after a restart.
+// Use AcknowledgeDeliveries on the receiving side.
+type AckDelivery struct {
+	mu sync.Mutex
+
+	store     DeliveryStore
+	policy    RetryPolicy
+	recipient func(conn Connection) string
+	logger    Logger
+
+	pending map[string]pendingAck
+}
+
+// pendingAck is a delivery being sent, acked is closed when it is acknowledged.
+type pendingAck struct {
+	conn      Connection
+	recipient string
+	acked     chan struct{}
+}
+
+// NewAckDelivery creates a new AckDelivery instance.
+// store persists pending deliveries, if nil, a MemoryDeliveryStore is used.
+// recipient returns the recipient ID of a connection saved with pending deliveries, if nil, the recipient is unknown.
+// logger is used to log store errors. If nil, a default logger is used.
+// If policy.MaxAttempts is less than 1, NewAckDelivery panics.
+func NewAckDelivery(store DeliveryStore, policy RetryPolicy, recipient func(conn Connection) string, logger Logger) *AckDelivery {
+	if policy.MaxAttempts < 1 {
+		panic(fmt.Sprintf("wsocket: invalid delivery attempts: %d", policy.MaxAttempts))
+	}
+	if store == nil {
+		store = NewMemoryDeliveryStore()
+	}
+	if recipient == nil {
+		recipient = func(Connection) string { return "" }
+	}
+	if logger == nil {
+		logger = DefaultLogger()
+	}
+
+	return &AckDelivery{
+		store:     store,
+		policy:    policy,
+		recipient: recipient,
+		logger:    logger,
+		pending:   make(map[string]pendingAck),
+	}
+}
+
+// AckSender is implemented by the connections that send messages with acknowledged delivery,
+// e.g. the connections of a Client created with WithAckDelivery and their sessions.
+type AckSender interface {
+	// SendWithAck sends the JSON message and waits until the peer acknowledges it, retrying with backoff.
+	// It returns ErrAckDeliveryDisabled if the client is not created with WithAckDelivery.
+	SendWithAck(ctx context.Context, msg Message) error
+}
+
+// SendWithAck sends the JSON message to conn and waits until the peer acknowledges it.
+// It returns ErrAckDeliveryDisabled if conn is not an AckSender.
+func SendWithAck(ctx context.Context, conn Connection, msg Message) error {
+	if sender, ok := conn.(AckSender); ok {
+		return sender.SendWithAck(ctx, msg)
+	}
+	return ErrAckDeliveryDisabled
+}
+
+// ackDeliveryProvider is implemented by the connections created with WithAckDelivery, so the sessions can send through their delivery.
+type ackDeliveryProvider interface {
+	ackDelivery() *AckDelivery
+}
+
+// WithAckDelivery enables SendWithAck on the connections using the delivery.
+func WithAckDelivery(delivery *AckDelivery) ClientOption {
+	return func(c *client) {
+		c.connConfig.acks = delivery
+	}
+}
+
+// AddHandlers adds the built-in "delivered" handler to r.
+func (d *AckDelivery) AddHandlers(r *JSONResolver) *JSONResolver {
+	return r.AddHandler(DeliveredMessageType, func(ctx context.Context, msg []byte, _ ResponseWriter) error {
+		conn, ok := ConnectionFromContext(ctx)
+		if !ok {
+			return fmt.Errorf("no connection in context")
+		}
+
+		jsonMsg, err := ParseJSONMessage(ctx, msg)
+		if err != nil {
+			return err
+		}
+
+		d.Ack(ctx, conn, jsonMsg.GetString("id"))
+		return nil
+	})
+}
+
+// Ack acknowledges the delivery with the ID received from conn and deletes it from the store.
+// Only the deliveries sent to conn or to its recipient are acknowledged, the IDs of other recipients are ignored.
+// A delivery that is not being sent, e.g. it was acknowledged after the last attempt or is sent by another node,
+// is looked up in the store by the recipient of conn, so it is only deleted if the recipient is known.
+func (d *AckDelivery) Ack(ctx context.Context, conn Connection, id string) {
+	recipient := d.recipient(conn)
+
+	d.mu.Lock()
+	pending, ok := d.pending[id]
+	if ok && pending.conn != conn && (pending.recipient == "" || pending.recipient != recipient) {
+		d.mu.Unlock()
+		return
+	}
+	delete(d.pending, id)
+	d.mu.Unlock()
+
+	if ok {
+		close(pending.acked)
+	} else if !d.isPendingFor(ctx, recipient, id) {
+		return
+	}
+
+	if err := d.store.Delete(ctx, id); err != nil {
+		d.logger.Printf("failed to delete delivery %s: %v", id, err)
+	}
+}
+
+// isPendingFor reports whether the store has the delivery with the ID for the recipient.
+func (d *AckDelivery) isPendingFor(ctx context.Context, recipient, id string) bool {
+	if recipient == "" {
+		return false
+	}
+
+	deliveries, err := d.store.List(ctx, recipient)
+	if err != nil {
+		d.logger.Printf("failed to list deliveries of %s: %v", recipient, err)
+		return false
+	}
+	for _, delivery := range deliveries {
+		if delivery.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Send sends the JSON message to conn and waits until it is acknowledged.
+// It returns ErrDeliveryFailed if the message is not acknowledged after all attempts, or ErrConnectionClosed if conn is closed before.
+// The delivery is kept in the store in these cases.
+func (d *AckDelivery) Send(ctx context.Context, conn Connection, msg Message) error {
+	if msg.msgType != 0 && msg.msgType != websocket.TextMessage {
+		return fmt.Errorf("acknowledged messages must be text messages")
+	}
+	if err := fastjson.ValidateBytes(msg.Message); err != nil {
+		return fmt.Errorf("acknowledged messages must be JSON: %w", err)
+	}
+
+	id, err := newRandomID()
+	if err != nil {
+		return err
+	}
+
+	delivery := PendingDelivery{
+		ID:        id,
+		Recipient: d.recipient(conn),
+		Message:   msg.Message,
+		CreatedAt: time.Now(),
+	}
+	if err := d.store.Save(ctx, delivery); err != nil {
+		return err
+	}
+
+	return d.deliver(ctx, conn, delivery, msg)
+}
+
+// Redeliver sends the pending deliveries of the recipient to conn in the background.
+// It is usually called when the recipient connects.
+func (d *AckDelivery) Redeliver(ctx context.Context, conn Connection, recipient string) error {
+	deliveries, err := d.store.List(ctx, recipient)
+	if err != nil {
+		return err
+	}
+
+	for _, delivery := range deliveries {
+		go func(delivery PendingDelivery) {
+			err := d.deliver(ctx, conn, delivery, NewTextMessage(delivery.Message))
+			if err != nil && !errors.Is(err, ErrDeliveryFailed) && !errors.Is(err, ErrConnectionClosed) && !errors.Is(err, context.Canceled) {
+				d.logger.Printf("failed to redeliver %s: %v", delivery.ID, err)
+			}
+		}(delivery)
+	}
+	return nil
+}
+
+// Pending returns the number of deliveries waiting for an acknowledgement.
+func (d *AckDelivery) Pending() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.pending)
+}
+
+func (d *AckDelivery) deliver(ctx context.Context, conn Connection, delivery PendingDelivery, msg Message) error {
+	acked := make(chan struct{})
+	d.mu.Lock()
+	if _, ok := d.pending[delivery.ID]; ok {
+		// The delivery is already being sent, e.g. redelivered twice.
+		d.mu.Unlock()
+		return nil
+	}
+	d.pending[delivery.ID] = pendingAck{conn: conn, recipient: delivery.Recipient, acked: acked}
+	d.mu.Unlock()
+
+	defer func() {
+		d.mu.Lock()
+		if d.pending[delivery.ID].acked == acked {
+			delete(d.pending, delivery.ID)
+		}
+		d.mu.Unlock()
+	}()
+
+	envelope := deliveryEnvelope(delivery.ID, msg)
+	for attempt := 1; attempt <= d.policy.MaxAttempts; attempt++ {
+		if err := conn.WriteMessage(envelope); err != nil && !errors.Is(err, ErrConnectionClosed) {
+			return err
+		}
+
+		timer := time.NewTimer(d.policy.backoff(attempt))
+		select {
+		case <-acked:
+			timer.Stop()
+			return nil
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-conn.Wait():
+			timer.Stop()
+			return ErrConnectionClosed
+		case <-timer.C:
+		}
+	}
+
+	return ErrDeliveryFailed
+}
+
+// deliveryEnvelope wraps the JSON message into a "delivery" envelope with the ID.
+func deliveryEnvelope(id string, msg Message) Message {
+	var buf bytes.Buffer
+	buf.Grow(len(msg.Message) + len(id) + 40)
+	buf.WriteString(`{"type":"`)
+	buf.WriteString(DeliveryMessageType)
+	buf.WriteString(`","id":"`)
+	buf.WriteString(id)
+	buf.WriteString(`","data":`)
+	buf.Write(msg.Message)
+	buf.WriteByte('}')
+
+	msg.msgType = websocket.TextMessage
+	msg.Message = buf.Bytes()
+	msg.conflationKey = ""
+	return msg
+}
+
+// AcknowledgeDeliveries returns a client middleware acknowledging the messages sent by AckDelivery.
+// It unwraps the "delivery" envelope and replies with a "delivered" message before the message is handled.
+// A delivery can be received more than once, use a deduplication middleware after it to handle it once.
+func AcknowledgeDeliveries() Middleware {
+	return func(ctx context.Context, msg []byte) (context.Context, []byte, error) {
+		jsonMsg, err := ParseJSONMessage(ctx, msg)
+		if err != nil || jsonMsg.GetString("type") != DeliveryMessageType {
+			return ctx, msg, nil
+		}
+
+		id := jsonMsg.GetString("id")
+		data := jsonMsg.Get("data")
+		if id == "" || data == nil {
+			return ctx, nil, fmt.Errorf("invalid delivery message")
+		}
+
+		if conn, ok := ConnectionFromContext(ctx); ok {
+			ack, err := json.Marshal(struct {
+				Type string `json:"type"`
+				ID   string `json:"id"`
+			}{Type: DeliveredMessageType, ID: id})
+			if err != nil {
+				return ctx, nil, err
+			}
+			if err := conn.WriteMessage(NewTextMessage(ack).WithPriority(PriorityHigh)); err != nil {
+				return ctx, nil, err
+			}
+		}
+
+		return withDeliveryID(ctx, id), data.MarshalTo(nil), nil
+	}
+}
+
+type deliveryIDContextKey struct{}
+
+// DeliveryIDFromContext returns the ID of the delivery being handled, unwrapped by AcknowledgeDeliveries.
+func DeliveryIDFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(deliveryIDContextKey{}).(string)
+	return id, ok
+}
+
+func withDeliveryID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, deliveryIDContextKey{}, id)
+}
@@ -0,0 +1,206 @@
+package wsocket
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testRetryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: 20 * time.Millisecond,
+	MaxBackoff:     40 * time.Millisecond,
+	Multiplier:     2,
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	assert.Equal(t, time.Second, DefaultRetryPolicy.backoff(1))
+	assert.Equal(t, 16*time.Second, DefaultRetryPolicy.backoff(5))
+	assert.Equal(t, 30*time.Second, DefaultRetryPolicy.backoff(6))
+	assert.Equal(t, 40*time.Millisecond, testRetryPolicy.backoff(3))
+}
+
+func TestMemoryDeliveryStore_List(t *testing.T) {
+	store := NewMemoryDeliveryStore()
+	ctx := context.Background()
+	now := time.Now()
+
+	assert.NoError(t, store.Save(ctx, PendingDelivery{ID: "2", Recipient: "alice", CreatedAt: now.Add(time.Second)}))
+	assert.NoError(t, store.Save(ctx, PendingDelivery{ID: "1", Recipient: "alice", CreatedAt: now}))
+	assert.NoError(t, store.Save(ctx, PendingDelivery{ID: "3", Recipient: "bob", CreatedAt: now}))
+
+	deliveries, err := store.List(ctx, "alice")
+	assert.NoError(t, err)
+	assert.Len(t, deliveries, 2)
+	assert.Equal(t, "1", deliveries[0].ID)
+	assert.Equal(t, "2", deliveries[1].ID)
+}
+
+// deliveryID returns the ID of the delivery envelope.
+func deliveryID(t *testing.T, msg string) string {
+	var envelope struct {
+		Type string `json:"type"`
+		ID   string `json:"id"`
+	}
+	assert.NoError(t, json.Unmarshal([]byte(msg), &envelope))
+	assert.Equal(t, DeliveryMessageType, envelope.Type)
+	return envelope.ID
+}
+
+func TestAckDelivery_Send(t *testing.T) {
+	store := NewMemoryDeliveryStore()
+	policy := RetryPolicy{MaxAttempts: 3, InitialBackoff: 20 * time.Millisecond, Multiplier: 50}
+	delivery := NewAckDelivery(store, policy, func(Connection) string { return "alice" }, NoLogger())
+	jr := delivery.AddHandlers(NewJSONResolver("type"))
+
+	conn := newTestConnection()
+	go func() {
+		// Acknowledge the second attempt twice.
+		for len(conn.Messages()) < 2 {
+			time.Sleep(5 * time.Millisecond)
+		}
+		ack := []byte(`{"type":"delivered","id":"` + deliveryID(t, conn.Messages()[0]) + `"}`)
+		ctx := withConnection(context.Background(), conn)
+		assert.NoError(t, jr.Handle(ctx, ack, &testResponseWriter{}))
+		assert.NoError(t, jr.Handle(ctx, ack, &testResponseWriter{}))
+	}()
+
+	err := delivery.Send(context.Background(), conn, NewTextMessage([]byte(`{"type":"payment","status":"paid"}`)))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, delivery.Pending())
+
+	messages := conn.Messages()
+	assert.Len(t, messages, 2)
+	assert.Equal(t, messages[0], messages[1], "Expected the retry to carry the same ID")
+	assert.Contains(t, messages[0], `"data":{"type":"payment","status":"paid"}`)
+
+	pending, err := store.List(context.Background(), "alice")
+	assert.NoError(t, err)
+	assert.Empty(t, pending)
+
+	assert.Error(t, delivery.Send(context.Background(), conn, NewTextMessage([]byte(`not json`))))
+}
+
+func TestAckDelivery_Redeliver(t *testing.T) {
+	store := NewMemoryDeliveryStore()
+	delivery := NewAckDelivery(store, testRetryPolicy, func(Connection) string { return "alice" }, NoLogger())
+
+	conn := newTestConnection()
+	err := delivery.Send(context.Background(), conn, NewTextMessage([]byte(`{"type":"payment"}`)))
+	assert.ErrorIs(t, err, ErrDeliveryFailed)
+	assert.Len(t, conn.Messages(), testRetryPolicy.MaxAttempts)
+
+	pending, err := store.List(context.Background(), "alice")
+	assert.NoError(t, err)
+	assert.Len(t, pending, 1, "Expected a failed delivery to be kept")
+
+	// A restarted node redelivers the pending deliveries when the recipient reconnects.
+	restarted := NewAckDelivery(store, testRetryPolicy, nil, NoLogger())
+	conn2 := newTestConnection()
+	assert.NoError(t, restarted.Redeliver(context.Background(), conn2, "alice"))
+	assert.Eventually(t, func() bool { return len(conn2.Messages()) > 0 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, pending[0].ID, deliveryID(t, conn2.Messages()[0]))
+
+	restarted.Ack(context.Background(), conn2, pending[0].ID)
+	pending, err = store.List(context.Background(), "alice")
+	assert.NoError(t, err)
+	assert.Empty(t, pending)
+}
+
+func TestAckDelivery_AckAfterLastAttempt(t *testing.T) {
+	store := NewMemoryDeliveryStore()
+	delivery := NewAckDelivery(store, testRetryPolicy, func(Connection) string { return "alice" }, NoLogger())
+
+	conn := newTestConnection()
+	err := delivery.Send(context.Background(), conn, NewTextMessage([]byte(`{"type":"payment"}`)))
+	assert.ErrorIs(t, err, ErrDeliveryFailed)
+
+	delivery.Ack(context.Background(), newTestConnection(), deliveryID(t, conn.Messages()[0]))
+	pending, err := store.List(context.Background(), "alice")
+	assert.NoError(t, err)
+	assert.Empty(t, pending, "Expected a late acknowledgement to delete the delivery")
+}
+
+func TestAckDelivery_AckOfOtherRecipient(t *testing.T) {
+	store := NewMemoryDeliveryStore()
+	policy := RetryPolicy{MaxAttempts: 1, InitialBackoff: time.Minute}
+	alice, bob := newTestConnection(), newTestConnection()
+	recipients := map[Connection]string{alice: "alice", bob: "bob"}
+	delivery := NewAckDelivery(store, policy, func(conn Connection) string { return recipients[conn] }, NoLogger())
+
+	sent := make(chan error, 1)
+	go func() {
+		sent <- delivery.Send(context.Background(), alice, NewTextMessage([]byte(`{"type":"payment"}`)))
+	}()
+	assert.Eventually(t, func() bool { return len(alice.Messages()) == 1 }, time.Second, 5*time.Millisecond)
+	id := deliveryID(t, alice.Messages()[0])
+
+	// Neither a pending nor a stored delivery is acknowledged by another recipient.
+	delivery.Ack(context.Background(), bob, id)
+	assert.Equal(t, 1, delivery.Pending())
+	delivery.Ack(context.Background(), newTestConnection(), id)
+	assert.Equal(t, 1, delivery.Pending(), "Expected an unknown recipient not to acknowledge the delivery")
+
+	delivery.Ack(context.Background(), alice, id)
+	assert.NoError(t, <-sent)
+
+	assert.NoError(t, store.Save(context.Background(), PendingDelivery{ID: "stored", Recipient: "alice"}))
+	delivery.Ack(context.Background(), bob, "stored")
+	pending, err := store.List(context.Background(), "alice")
+	assert.NoError(t, err)
+	assert.Len(t, pending, 1, "Expected the stored delivery of another recipient to be kept")
+}
+
+func TestAckDelivery_ConnectionClosed(t *testing.T) {
+	store := NewMemoryDeliveryStore()
+	policy := RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Minute}
+	delivery := NewAckDelivery(store, policy, func(Connection) string { return "alice" }, NoLogger())
+
+	conn := newTestConnection()
+	go func() {
+		for len(conn.Messages()) == 0 {
+			time.Sleep(5 * time.Millisecond)
+		}
+		_ = conn.Close()
+	}()
+
+	err := delivery.Send(context.Background(), conn, NewTextMessage([]byte(`{"type":"payment"}`)))
+	assert.ErrorIs(t, err, ErrConnectionClosed)
+	assert.Equal(t, 0, delivery.Pending())
+
+	pending, err := store.List(context.Background(), "alice")
+	assert.NoError(t, err)
+	assert.Len(t, pending, 1, "Expected the delivery to be kept for a redelivery")
+}
+
+func TestAcknowledgeDeliveries(t *testing.T) {
+	middleware := AcknowledgeDeliveries()
+	conn := newTestConnection()
+	ctx := withConnection(context.Background(), conn)
+
+	ctx, msg, err := middleware(ctx, []byte(`{"type":"delivery","id":"42","data":{"type":"payment"}}`))
+	assert.NoError(t, err)
+	assert.Equal(t, `{"type":"payment"}`, string(msg))
+	id, ok := DeliveryIDFromContext(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, "42", id)
+	assert.Equal(t, []string{`{"type":"delivered","id":"42"}`}, conn.Messages())
+
+	_, msg, err = middleware(ctx, []byte(`{"type":"other"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, `{"type":"other"}`, string(msg))
+
+	_, _, err = middleware(ctx, []byte(`{"type":"delivery","id":"42"}`))
+	assert.Error(t, err)
+}
+
+func TestSendWithAck_Disabled(t *testing.T) {
+	c := newTestQueue(DefaultStarvationLimit, 10)
+	assert.ErrorIs(t, SendWithAck(context.Background(), c, NewTextMessage([]byte(`{}`))), ErrAckDeliveryDisabled)
+
+	conn := newTestConnection()
+	assert.ErrorIs(t, SendWithAck(context.Background(), conn, NewTextMessage([]byte(`{}`))), ErrAckDeliveryDisabled)
+}
@@ -0,0 +1,56 @@
+package wsocket
+
+import "encoding/json"
+
+// ErrorReply is the standard reply sent when a message is rejected before reaching its handler.
+type ErrorReply struct {
+	// Type is always "error".
+	Type string `json:"type"`
+	// Code identifies the reason of the rejection, e.g. "validation_failed".
+	Code string `json:"code"`
+	// Message is a human readable description of the error.
+	Message string `json:"message"`
+	// Route is the message type the rejected message was resolved to.
+	Route string `json:"route,omitempty"`
+	// Details holds additional information depending on Code.
+	Details interface{} `json:"details,omitempty"`
+}
+
+// Error reply codes.
+const (
+	ErrorCodeValidationFailed = "validation_failed"
+)
+
+// errorReplyType is the type of every ErrorReply.
+const errorReplyType = "error"
+
+// NewErrorReply creates an ErrorReply.
+func NewErrorReply(code, message, route string, details interface{}) ErrorReply {
+	return ErrorReply{
+		Type:    errorReplyType,
+		Code:    code,
+		Message: message,
+		Route:   route,
+		Details: details,
+	}
+}
+
+// WriteErrorReply writes reply to rw as a JSON text message with PriorityHigh.
+func WriteErrorReply(rw ResponseWriter, reply ErrorReply) error {
+	msg, err := json.Marshal(reply)
+	if err != nil {
+		return err
+	}
+
+	return rw.WriteMessage(NewTextMessage(msg).WithPriority(PriorityHigh))
+}
+
+// tryWriteErrorReply writes reply like WriteErrorReply, but returns errWriteQueueFull instead of waiting for the write queue.
+func tryWriteErrorReply(rw ResponseWriter, reply ErrorReply) error {
+	msg, err := json.Marshal(reply)
+	if err != nil {
+		return err
+	}
+
+	return writeNonBlocking(rw, NewTextMessage(msg).WithPriority(PriorityHigh))
+}
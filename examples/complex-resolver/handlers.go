@@ -15,6 +15,11 @@ type sumRequest struct {
 	B    int    `json:"b"`
 }
 
+type sumResponse struct {
+	Type   string `json:"type"`
+	Result int    `json:"result"`
+}
+
 func handleSum(_ context.Context, msg []byte, rw wsocket.ResponseWriter) error {
 	jsonMsg := &sumRequest{}
 	if err := json.Unmarshal(msg, jsonMsg); err != nil {
@@ -30,32 +35,25 @@ func handleSum(_ context.Context, msg []byte, rw wsocket.ResponseWriter) error {
 	return nil
 }
 
-type event struct {
-	Type string `json:"type"`
-	Data struct {
-		Type    string `json:"type"`
-		Message string `json:"message"`
-	} `json:"data"`
-}
-
-func handleInfoEvent(_ context.Context, msg []byte, rw wsocket.ResponseWriter) error {
-	jsonMsg := &event{}
-	if err := json.Unmarshal(msg, jsonMsg); err != nil {
+func handleInfoEvent(ctx context.Context, msg []byte, rw wsocket.ResponseWriter) error {
+	// The message is already parsed by the resolvers, so the parse result is reused instead of unmarshalling it again.
+	jsonMsg, err := wsocket.ParseJSONMessage(ctx, msg)
+	if err != nil {
 		return err
 	}
 
-	log.Printf("[INFO] %s\n", jsonMsg.Data.Message)
+	log.Printf("[INFO] %s\n", jsonMsg.GetString("data", "message"))
 
 	return nil
 }
 
-func handleErrorEvent(_ context.Context, msg []byte, rw wsocket.ResponseWriter) error {
-	jsonMsg := &event{}
-	if err := json.Unmarshal(msg, jsonMsg); err != nil {
+func handleErrorEvent(ctx context.Context, msg []byte, rw wsocket.ResponseWriter) error {
+	jsonMsg, err := wsocket.ParseJSONMessage(ctx, msg)
+	if err != nil {
 		return err
 	}
 
-	log.Printf("[ERROR] %s\n", jsonMsg.Data.Message)
+	log.Printf("[ERROR] %s\n", jsonMsg.GetString("data", "message"))
 
 	return nil
 }
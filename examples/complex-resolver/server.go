@@ -16,9 +16,18 @@ import (
 // Event resolver will handle messages with the data.type "info" and "error".
 
 func main() {
-	wsClient := wsocket.NewClient(context.Background(), getResolver(), nil, 10)
+	resolver := getResolver()
+	wsClient := wsocket.NewClient(context.Background(), resolver, nil, 10)
 	wsClient.AddMiddleware(messageLogger)
 
+	// The AsyncAPI document describing the routes is served next to the socket.
+	http.Handle("/asyncapi.json", wsocket.AsyncAPIHandler(resolver, wsocket.AsyncAPIInfo{
+		Title:   "Complex resolver example",
+		Version: "1.0.0",
+		Server:  "localhost:8080",
+		Channel: "/ws",
+	}))
+
 	upgrader := &websocket.Upgrader{}
 	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
@@ -41,13 +50,12 @@ func main() {
 
 func getResolver() wsocket.Resolver {
 	r := wsocket.NewJSONResolver("type")
-	r.AddHandler("sum-request", handleSum)
+	r.AddHandler("sum-request", handleSum, wsocket.WithPayload(sumRequest{}, sumResponse{}))
 
-	r.AddHandler("event",
+	r.AddResolver("event",
 		wsocket.NewJSONResolver("data.type").
 			AddHandler("info", handleInfoEvent).
-			AddHandler("error", handleErrorEvent).
-			Handle,
+			AddHandler("error", handleErrorEvent),
 	)
 
 	return r
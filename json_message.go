@@ -0,0 +1,230 @@
+package wsocket
+
+import (
+	"context"
+	"hash/maphash"
+	"strconv"
+	"sync"
+
+	"github.com/valyala/fastjson"
+)
+
+// JSONMessage is a JSON message parsed once per frame.
+// The client caches it in the message context, so middlewares, nested JSONResolvers and handlers
+// share a single parse result instead of parsing the same frame again.
+//
+// The parsed values are backed by a pooled parser and are only valid until the handler returns.
+// Copy the values you need before passing them to other goroutines.
+type JSONMessage struct {
+	raw   []byte
+	value *fastjson.Value
+
+	// mu guards the lookups. fastjson unescapes the keys of an object on its first lookup, which is a write,
+	// so the objects are unescaped under mu before their values are shared.
+	mu sync.Mutex
+}
+
+// Get returns the value at the given path, e.g. Get("data", "type").
+// Array elements are addressed by their index, e.g. Get("items", "0").
+// nil is returned if the value doesn't exist.
+func (m *JSONMessage) Get(path ...string) *fastjson.Value {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	v := m.lookup(path)
+	if v != nil {
+		unescapeKeys(v)
+	}
+	return v
+}
+
+// GetString returns the string value at the given path.
+// An empty string is returned if the value doesn't exist or isn't a string.
+func (m *JSONMessage) GetString(path ...string) string {
+	return string(m.find(path).GetStringBytes())
+}
+
+// GetInt returns the integer value at the given path.
+// 0 is returned if the value doesn't exist or isn't a number.
+func (m *JSONMessage) GetInt(path ...string) int {
+	return m.find(path).GetInt()
+}
+
+// GetUint64 returns the unsigned integer value at the given path.
+// 0 is returned if the value doesn't exist or isn't an unsigned integer.
+func (m *JSONMessage) GetUint64(path ...string) uint64 {
+	return m.find(path).GetUint64()
+}
+
+// GetFloat64 returns the number value at the given path.
+// 0 is returned if the value doesn't exist or isn't a number.
+func (m *JSONMessage) GetFloat64(path ...string) float64 {
+	return m.find(path).GetFloat64()
+}
+
+// GetBool returns the boolean value at the given path.
+// false is returned if the value doesn't exist or isn't a boolean.
+func (m *JSONMessage) GetBool(path ...string) bool {
+	return m.find(path).GetBool()
+}
+
+// Exists returns true if a value exists at the given path.
+func (m *JSONMessage) Exists(path ...string) bool {
+	return m.find(path) != nil
+}
+
+// Value returns the root value of the message.
+func (m *JSONMessage) Value() *fastjson.Value {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	unescapeKeys(m.value)
+	return m.value
+}
+
+// Raw returns the raw message.
+func (m *JSONMessage) Raw() []byte {
+	return m.raw
+}
+
+// find returns the value at path to read a scalar from it. Only the objects on the path are unescaped.
+func (m *JSONMessage) find(path []string) *fastjson.Value {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.lookup(path)
+}
+
+// lookup returns the value at path like fastjson.Value.Get. m.mu must be held.
+func (m *JSONMessage) lookup(path []string) *fastjson.Value {
+	v := m.value
+	for _, key := range path {
+		switch v.Type() {
+		case fastjson.TypeObject:
+			obj, _ := v.Object()
+			v = obj.Get(key)
+		case fastjson.TypeArray:
+			arr, _ := v.Array()
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(arr) {
+				return nil
+			}
+			v = arr[i]
+		default:
+			return nil
+		}
+		if v == nil {
+			return nil
+		}
+	}
+	return v
+}
+
+// ParseJSONMessage returns msg parsed as JSON.
+// If the message context already holds a parse result for msg, it is reused.
+// Otherwise msg is parsed and, if ctx comes from the client, cached for the rest of the message handling.
+// The results are cached by the slice, so a message passed on by the middlewares and resolvers is parsed once,
+// and a message modified in place is parsed again.
+// Every distinct message has its own parse result, so parsing another message doesn't invalidate the earlier results.
+// The returned values are safe for concurrent reads.
+func ParseJSONMessage(ctx context.Context, msg []byte) (*JSONMessage, error) {
+	cache, ok := ctx.Value(jsonCacheContextKey{}).(*jsonCache)
+	if !ok || len(msg) == 0 {
+		value, err := fastjson.ParseBytes(msg)
+		if err != nil {
+			return nil, err
+		}
+		return &JSONMessage{raw: msg, value: value}, nil
+	}
+
+	return cache.parse(msg)
+}
+
+var jsonParserPool fastjson.ParserPool
+
+type jsonCacheContextKey struct{}
+
+// jsonCache holds the parse results of the messages being handled, by their slices.
+type jsonCache struct {
+	mu      sync.Mutex
+	seed    maphash.Seed
+	parsers []*fastjson.Parser
+	entries map[jsonCacheKey]jsonCacheEntry
+}
+
+// jsonCacheKey identifies a message slice by its first byte and its length.
+type jsonCacheKey struct {
+	data *byte
+	size int
+}
+
+type jsonCacheEntry struct {
+	// hash is the hash of the content, a message modified in place doesn't match it.
+	hash uint64
+	msg  *JSONMessage
+	err  error
+}
+
+func withJSONCache(ctx context.Context) (context.Context, *jsonCache) {
+	cache := &jsonCache{
+		seed:    maphash.MakeSeed(),
+		entries: make(map[jsonCacheKey]jsonCacheEntry),
+	}
+	return context.WithValue(ctx, jsonCacheContextKey{}, cache), cache
+}
+
+// parse returns the cached parse result of msg or parses it. msg must not be empty.
+func (c *jsonCache) parse(msg []byte) (*JSONMessage, error) {
+	key := jsonCacheKey{data: &msg[0], size: len(msg)}
+	hash := maphash.Bytes(c.seed, msg)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if entry, ok := c.entries[key]; ok && entry.hash == hash {
+		return entry.msg, entry.err
+	}
+
+	// The parser copies msg, so the parsed values are not affected if msg is modified later.
+	parser := jsonParserPool.Get()
+	value, err := parser.ParseBytes(msg)
+	if err != nil {
+		jsonParserPool.Put(parser)
+		c.entries[key] = jsonCacheEntry{hash: hash, err: err}
+		return nil, err
+	}
+	c.parsers = append(c.parsers, parser)
+
+	entry := jsonCacheEntry{hash: hash, msg: &JSONMessage{raw: msg, value: value}}
+	c.entries[key] = entry
+	return entry.msg, nil
+}
+
+// release returns the parsers to the pool. The cached values must not be used afterwards.
+func (c *jsonCache) release() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, parser := range c.parsers {
+		jsonParserPool.Put(parser)
+	}
+	c.parsers = nil
+	c.entries = make(map[jsonCacheKey]jsonCacheEntry)
+}
+
+// unescapeKeys visits all objects of v. fastjson unescapes object keys lazily on the first access,
+// which is a write, so the keys are unescaped before v is handed out.
+func unescapeKeys(v *fastjson.Value) {
+	switch v.Type() {
+	case fastjson.TypeObject:
+		obj, _ := v.Object()
+		obj.Visit(func(_ []byte, v *fastjson.Value) {
+			unescapeKeys(v)
+		})
+	case fastjson.TypeArray:
+		arr, _ := v.Array()
+		for _, item := range arr {
+			unescapeKeys(item)
+		}
+	}
+}
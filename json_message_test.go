@@ -0,0 +1,115 @@
+package wsocket
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseJSONMessage(t *testing.T) {
+	msg := []byte(`{"type": "event", "data": {"type": "info", "count": 2, "ok": true, "ratio": 0.5}}`)
+
+	jsonMsg, err := ParseJSONMessage(context.Background(), msg)
+	assert.NoError(t, err)
+	assert.Equal(t, "event", jsonMsg.GetString("type"))
+	assert.Equal(t, "info", jsonMsg.GetString("data", "type"))
+	assert.Equal(t, 2, jsonMsg.GetInt("data", "count"))
+	assert.Equal(t, 0.5, jsonMsg.GetFloat64("data", "ratio"))
+	assert.True(t, jsonMsg.GetBool("data", "ok"))
+	assert.True(t, jsonMsg.Exists("data"))
+	assert.False(t, jsonMsg.Exists("missing"))
+	assert.Nil(t, jsonMsg.Get("missing"))
+	assert.Nil(t, jsonMsg.Get("type", "missing"))
+	assert.False(t, jsonMsg.Exists("data", "count", "missing"))
+	assert.Equal(t, msg, jsonMsg.Raw())
+
+	_, err = ParseJSONMessage(context.Background(), []byte(`invalid_json`))
+	assert.Error(t, err)
+}
+
+func TestParseJSONMessage_Cache(t *testing.T) {
+	ctx, cache := withJSONCache(context.Background())
+	defer cache.release()
+
+	msg := []byte(`{"type": "event"}`)
+
+	first, err := ParseJSONMessage(ctx, msg)
+	assert.NoError(t, err)
+	second, err := ParseJSONMessage(ctx, msg)
+	assert.NoError(t, err)
+	assert.Same(t, first, second, "Expected the cached message to be reused")
+
+	replaced := []byte(`{"type": "replaced"}`)
+	third, err := ParseJSONMessage(ctx, replaced)
+	assert.NoError(t, err)
+	assert.NotSame(t, first, third, "Expected a replaced message to be parsed again")
+	assert.Equal(t, "replaced", third.GetString("type"))
+	assert.Equal(t, "event", first.GetString("type"), "Expected the earlier result to stay valid")
+
+	copied, err := ParseJSONMessage(ctx, append([]byte(nil), msg...))
+	assert.NoError(t, err)
+	assert.NotSame(t, first, copied, "Expected a copy of the message to be parsed again")
+	assert.Equal(t, "event", copied.GetString("type"))
+
+	copy(msg, `{"type": "other"}`)
+	mutated, err := ParseJSONMessage(ctx, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, "other", mutated.GetString("type"), "Expected a message modified in place to be parsed again")
+	assert.Equal(t, "event", first.GetString("type"))
+
+	invalid := []byte(`invalid_json`)
+	_, err = ParseJSONMessage(ctx, invalid)
+	assert.Error(t, err)
+	_, err = ParseJSONMessage(ctx, invalid)
+	assert.Error(t, err)
+}
+
+func TestParseJSONMessage_ConcurrentGet(t *testing.T) {
+	ctx, cache := withJSONCache(context.Background())
+	defer cache.release()
+
+	jsonMsg, err := ParseJSONMessage(ctx, []byte(`{"ty\u0070e": "event", "d\u0061ta": {"k\u0065y": "value", "it\u0065ms": [{"n\u0061me": "a"}]}}`))
+	assert.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Equal(t, "event", jsonMsg.GetString("type"))
+			assert.Equal(t, "value", jsonMsg.GetString("data", "key"))
+			assert.Equal(t, "a", jsonMsg.GetString("data", "items", "0", "name"))
+			assert.Equal(t, "a", string(jsonMsg.Get("data").GetStringBytes("items", "0", "name")))
+			assert.Equal(t, "value", string(jsonMsg.Value().GetStringBytes("data", "key")))
+		}()
+	}
+	wg.Wait()
+}
+
+func TestJSONResolver_Handle_SharesParsedMessage(t *testing.T) {
+	ctx, cache := withJSONCache(context.Background())
+	defer cache.release()
+
+	msg := []byte(`{"type": "event", "data": {"type": "info", "message": "hello"}}`)
+
+	outer, err := ParseJSONMessage(ctx, msg)
+	assert.NoError(t, err)
+
+	resolver := NewJSONResolver("type").
+		AddHandler("event", NewJSONResolver("data.type").
+			AddHandler("info", func(ctx context.Context, msg []byte, rw ResponseWriter) error {
+				jsonMsg, err := ParseJSONMessage(ctx, msg)
+				assert.NoError(t, err)
+				assert.Same(t, outer, jsonMsg)
+				return rw.WriteMessage(NewTextMessage([]byte(jsonMsg.GetString("data", "message"))))
+			}).
+			Handle,
+		)
+
+	rw := &testResponseWriter{}
+	err = resolver.Handle(ctx, msg, rw)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(rw.GetWrittenMessage().Message))
+}
@@ -7,6 +7,13 @@ import "github.com/gorilla/websocket"
 type Message struct {
 	msgType int
 	Message []byte
+
+	compression   compressionOverride
+	priority      Priority
+	conflationKey string
+
+	// writer is set for the requests of NextWriter passed through the write queue.
+	writer *writerRequest
 }
 
 func NewTextMessage(msg []byte) Message {
@@ -25,6 +32,18 @@ func NewBinaryMessage(msg []byte) Message {
 
 func NewCloseMessage() Message {
 	return Message{
-		msgType: websocket.CloseMessage,
+		msgType:  websocket.CloseMessage,
+		priority: PriorityHigh,
+	}
+}
+
+// WithCompression returns a copy of the message that is compressed or sent uncompressed regardless of the minimum size set by WithCompressionLevel.
+// It has no effect if compression is not enabled for the connection.
+func (m Message) WithCompression(enabled bool) Message {
+	if enabled {
+		m.compression = compressionOn
+	} else {
+		m.compression = compressionOff
 	}
+	return m
 }
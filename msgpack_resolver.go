@@ -0,0 +1,63 @@
+package wsocket
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// MsgPackResolver resolves MessagePack encoded messages by the value of a map field.
+// It mirrors JSONResolver, but reads binary MessagePack frames directly.
+type MsgPackResolver struct {
+	mu sync.RWMutex
+
+	field    string
+	handlers map[string]Handler
+}
+
+// NewMsgPackResolver creates a new MsgPackResolver instance.
+// field is the name of the map key in the MessagePack message that is used to resolve the handler.
+// For example, if field is "t", the message {"t": "temp", "v": 21.5} is resolved to the handler registered for "temp".
+// If the field is nested, use dot notation, e.g. "meta.t".
+// Integer field values are matched by their decimal representation, e.g. {"t": 7} is resolved to the handler registered for "7".
+func NewMsgPackResolver(field string) *MsgPackResolver {
+	return &MsgPackResolver{
+		field:    field,
+		handlers: make(map[string]Handler),
+	}
+}
+
+// AddHandler adds a handler for a message type.
+// name is the value of the field that is used to resolve the handler.
+func (r *MsgPackResolver) AddHandler(name string, handler Handler) *MsgPackResolver {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.handlers[name] = handler
+
+	return r
+}
+
+func (r *MsgPackResolver) Handle(ctx context.Context, msg []byte, rw ResponseWriter) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	values, err := msgpack.NewDecoder(bytes.NewReader(msg)).Query(r.field)
+	if err != nil || len(values) == 0 {
+		return fmt.Errorf("failed to get field %q from message", r.field)
+	}
+
+	fieldValue, ok := discriminatorString(values[0])
+	if !ok || fieldValue == "" {
+		return fmt.Errorf("failed to get field %q from message", r.field)
+	}
+
+	handler, ok := r.handlers[fieldValue]
+	if !ok {
+		return fmt.Errorf("unknown message type '%q'", fieldValue)
+	}
+
+	return handler(ctx, msg, rw)
+}
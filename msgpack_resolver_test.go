@@ -0,0 +1,96 @@
+package wsocket
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func TestMsgPackResolver_Handle(t *testing.T) {
+	resolver := NewMsgPackResolver("t")
+
+	resolver.AddHandler("temp", func(ctx context.Context, msg []byte, rw ResponseWriter) error {
+		err := rw.WriteMessage(NewBinaryMessage([]byte("Handler for temp")))
+		assert.NoError(t, err)
+		return nil
+	})
+	resolver.AddHandler("7", func(ctx context.Context, msg []byte, rw ResponseWriter) error {
+		err := rw.WriteMessage(NewBinaryMessage([]byte("Handler for 7")))
+		assert.NoError(t, err)
+		return nil
+	})
+	resolver.AddHandler("event", NewMsgPackResolver("data.t").
+		AddHandler("info", func(ctx context.Context, msg []byte, rw ResponseWriter) error {
+			err := rw.WriteMessage(NewBinaryMessage([]byte("Handler for event info")))
+			assert.NoError(t, err)
+			return nil
+		}).
+		Handle,
+	)
+
+	tests := []struct {
+		name           string
+		inputMessage   interface{}
+		expectedResult string
+		expectedError  bool
+	}{
+		{
+			name:           "String Message Type",
+			inputMessage:   map[string]interface{}{"t": "temp", "v": 21.5},
+			expectedResult: "Handler for temp",
+		},
+		{
+			name:           "Integer Message Type",
+			inputMessage:   map[string]interface{}{"t": 7, "v": 1},
+			expectedResult: "Handler for 7",
+		},
+		{
+			name:           "Nested Resolver",
+			inputMessage:   map[string]interface{}{"t": "event", "data": map[string]interface{}{"t": "info"}},
+			expectedResult: "Handler for event info",
+		},
+		{
+			name:          "Unknown Message Type",
+			inputMessage:  map[string]interface{}{"t": "unknown"},
+			expectedError: true,
+		},
+		{
+			name:          "Missing Field",
+			inputMessage:  map[string]interface{}{"v": 1},
+			expectedError: true,
+		},
+		{
+			name:          "Not A Map",
+			inputMessage:  []int{1, 2, 3},
+			expectedError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			msg, err := msgpack.Marshal(test.inputMessage)
+			assert.NoError(t, err)
+
+			rw := &testResponseWriter{}
+
+			err = resolver.Handle(context.Background(), msg, rw)
+			if test.expectedError {
+				assert.Error(t, err, "Expected an error")
+			} else {
+				assert.NoError(t, err, "Expected no error")
+				message := rw.GetWrittenMessage()
+				assert.NotNilf(t, message, "Expected a message to be written")
+				assert.Equal(t, test.expectedResult, string(message.Message))
+				assert.Equal(t, websocket.BinaryMessage, message.msgType)
+			}
+		})
+	}
+
+	t.Run("Invalid MessagePack", func(t *testing.T) {
+		err := resolver.Handle(context.Background(), []byte{0xc1}, &testResponseWriter{})
+		assert.Error(t, err)
+	})
+}
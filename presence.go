@@ -0,0 +1,514 @@
+package wsocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// PresenceEntry describes a user present under a key, e.g. a user viewing a document.
+type PresenceEntry struct {
+	UserID string `json:"user_id"`
+	// Meta is the metadata of the most recently tracked connection of the user, e.g. the cursor color.
+	Meta map[string]interface{} `json:"meta,omitempty"`
+	// Connections is the number of connections of the user under the key, on all nodes.
+	Connections int `json:"connections"`
+}
+
+// PresenceDiff describes the users that joined or left a key.
+// A user joins with their first connection and leaves with their last one.
+type PresenceDiff struct {
+	Key    string          `json:"key"`
+	Joins  []PresenceEntry `json:"joins,omitempty"`
+	Leaves []PresenceEntry `json:"leaves,omitempty"`
+}
+
+// DefaultPresenceHeartbeatInterval is the default interval of the heartbeats announcing a node to the other nodes.
+const DefaultPresenceHeartbeatInterval = 10 * time.Second
+
+// PresenceOption configures a Presence.
+type PresenceOption func(*Presence)
+
+// WithHeartbeatInterval sets how often a node announces itself to the other nodes when a broker is used.
+// The entries of a node that misses three heartbeats are removed. If interval is not positive, NewPresence panics.
+func WithHeartbeatInterval(interval time.Duration) PresenceOption {
+	return func(p *Presence) {
+		if interval <= 0 {
+			panic(fmt.Sprintf("wsocket: invalid presence heartbeat interval: %s", interval))
+		}
+		p.heartbeatInterval = interval
+	}
+}
+
+// Presence tracks which users are present under a key, e.g. who is online in a document.
+// Entries are added for connections and removed automatically when the connection is closed.
+// Several connections of the same user are merged into a single entry.
+//
+// By default, only the connections of the current process are tracked.
+// Use UseBroker to replicate the entries across nodes.
+type Presence struct {
+	mu sync.Mutex
+
+	logger Logger
+
+	// brokerQueue holds the replication events to publish through the broker in order, nil if no broker is used.
+	brokerQueue       chan BrokerMessage
+	nodeID            string
+	heartbeatInterval time.Duration
+	lastSeen          map[string]time.Time
+
+	nextRef     int
+	nextSeq     uint64
+	connRefs    map[Connection]map[string]string
+	keys        map[string]map[string]*presenceUser
+	subscribers map[string]map[int]func(diff PresenceDiff)
+	nextSubID   int
+
+	// pending holds the diffs not passed to the subscribers yet, in the order of the changes.
+	// They are passed by a single goroutine, dispatching is true while it runs.
+	pending     []PresenceDiff
+	dispatching bool
+}
+
+// presenceUser holds the tracked connections of a user under a key by reference.
+type presenceUser struct {
+	refs map[string]presenceRef
+}
+
+type presenceRef struct {
+	nodeID string
+	meta   map[string]interface{}
+	// seq orders the references by the time they were added.
+	seq uint64
+}
+
+// NewPresence creates a new Presence instance.
+// logger is used to log replication errors. If nil, a default logger is used.
+func NewPresence(logger Logger, opts ...PresenceOption) *Presence {
+	if logger == nil {
+		logger = DefaultLogger()
+	}
+
+	p := &Presence{
+		logger:            logger,
+		heartbeatInterval: DefaultPresenceHeartbeatInterval,
+		lastSeen:          make(map[string]time.Time),
+		connRefs:          make(map[Connection]map[string]string),
+		keys:              make(map[string]map[string]*presenceUser),
+		subscribers:       make(map[string]map[int]func(diff PresenceDiff)),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p
+}
+
+// Track adds conn of userID under key with meta.
+// If conn is already tracked under key, its entry is replaced.
+// The entry is removed when the connection is closed.
+func (p *Presence) Track(conn Connection, key, userID string, meta map[string]interface{}) {
+	p.mu.Lock()
+
+	refs, ok := p.connRefs[conn]
+	if !ok {
+		refs = make(map[string]string)
+		p.connRefs[conn] = refs
+		go p.untrackOnClose(conn)
+	}
+
+	if ref, ok := refs[key]; ok {
+		if diff, ok := p.remove(key, ref); ok {
+			p.queueDiff(diff)
+		}
+		p.replicate(presenceEvent{Op: presenceOpUntrack, Key: key, Ref: ref})
+	}
+
+	ref := p.newRef()
+	refs[key] = ref
+	if diff, ok := p.add(key, userID, ref, p.nodeID, meta); ok {
+		p.queueDiff(diff)
+	}
+	p.replicate(presenceEvent{Op: presenceOpTrack, Key: key, UserID: userID, Ref: ref, Meta: meta})
+	p.mu.Unlock()
+}
+
+// Untrack removes conn from key.
+func (p *Presence) Untrack(conn Connection, key string) {
+	p.mu.Lock()
+	ref, ok := p.connRefs[conn][key]
+	if !ok {
+		p.mu.Unlock()
+		return
+	}
+	delete(p.connRefs[conn], key)
+
+	if diff, ok := p.remove(key, ref); ok {
+		p.queueDiff(diff)
+	}
+	p.replicate(presenceEvent{Op: presenceOpUntrack, Key: key, Ref: ref})
+	p.mu.Unlock()
+}
+
+func (p *Presence) untrackOnClose(conn Connection) {
+	<-conn.Wait()
+
+	p.mu.Lock()
+	keys := make([]string, 0, len(p.connRefs[conn]))
+	for key := range p.connRefs[conn] {
+		keys = append(keys, key)
+	}
+	p.mu.Unlock()
+
+	for _, key := range keys {
+		p.Untrack(conn, key)
+	}
+
+	p.mu.Lock()
+	delete(p.connRefs, conn)
+	p.mu.Unlock()
+}
+
+// List returns the users present under key sorted by user ID.
+func (p *Presence) List(key string) []PresenceEntry {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	entries := make([]PresenceEntry, 0, len(p.keys[key]))
+	for userID, user := range p.keys[key] {
+		entries = append(entries, user.entry(userID))
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].UserID < entries[j].UserID
+	})
+
+	return entries
+}
+
+// Subscribe calls handler for every change of the users present under key until the returned function is called.
+// The handlers are called asynchronously in the order of the changes, one diff at a time, so they must not block.
+func (p *Presence) Subscribe(key string, handler func(diff PresenceDiff)) (unsubscribe func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	id := p.nextSubID
+	p.nextSubID++
+	if _, ok := p.subscribers[key]; !ok {
+		p.subscribers[key] = make(map[int]func(diff PresenceDiff))
+	}
+	p.subscribers[key][id] = handler
+
+	return func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+
+		delete(p.subscribers[key], id)
+		if len(p.subscribers[key]) == 0 {
+			delete(p.subscribers, key)
+		}
+	}
+}
+
+// PublishDiffs writes every change under key to conn as a JSON text message until conn is closed,
+// e.g. {"type": "presence_diff", "key": "doc-1", "joins": [{"user_id": "alice", "connections": 1}]}.
+// The diffs are queued without waiting. A connection whose write queue is full is too slow to keep up and is closed.
+func (p *Presence) PublishDiffs(conn Connection, key string) {
+	unsubscribe := p.Subscribe(key, func(diff PresenceDiff) {
+		msg, err := json.Marshal(struct {
+			Type string `json:"type"`
+			PresenceDiff
+		}{Type: "presence_diff", PresenceDiff: diff})
+		if err != nil {
+			p.logger.Printf("failed to encode presence diff: %v", err)
+			return
+		}
+		err = writeNonBlocking(conn, NewTextMessage(msg))
+		switch {
+		case err == nil:
+		case errors.Is(err, errWriteQueueFull):
+			p.logger.Printf("closing slow subscriber of presence key %q: %v", key, err)
+			// Closing writes the close message, it must not hold up the other subscribers.
+			go conn.Close()
+		case !errors.Is(err, ErrConnectionClosed):
+			p.logger.Printf("failed to write presence diff: %v", err)
+		}
+	})
+
+	go func() {
+		<-conn.Wait()
+		unsubscribe()
+	}()
+}
+
+func (p *Presence) newRef() string {
+	p.nextRef++
+	return p.nodeID + ":" + strconv.Itoa(p.nextRef)
+}
+
+// add adds a reference and returns the resulting diff if the user joined.
+func (p *Presence) add(key, userID, ref, nodeID string, meta map[string]interface{}) (PresenceDiff, bool) {
+	users, ok := p.keys[key]
+	if !ok {
+		users = make(map[string]*presenceUser)
+		p.keys[key] = users
+	}
+	user, ok := users[userID]
+	if !ok {
+		user = &presenceUser{refs: make(map[string]presenceRef)}
+		users[userID] = user
+	}
+
+	joined := len(user.refs) == 0
+	p.nextSeq++
+	user.refs[ref] = presenceRef{nodeID: nodeID, meta: meta, seq: p.nextSeq}
+
+	if !joined {
+		return PresenceDiff{}, false
+	}
+	return PresenceDiff{Key: key, Joins: []PresenceEntry{user.entry(userID)}}, true
+}
+
+// remove removes a reference and returns the resulting diff if the user left.
+func (p *Presence) remove(key, ref string) (PresenceDiff, bool) {
+	for userID, user := range p.keys[key] {
+		if _, ok := user.refs[ref]; !ok {
+			continue
+		}
+		if len(user.refs) > 1 {
+			delete(user.refs, ref)
+			return PresenceDiff{}, false
+		}
+
+		left := user.entry(userID)
+		left.Connections = 0
+		delete(p.keys[key], userID)
+		if len(p.keys[key]) == 0 {
+			delete(p.keys, key)
+		}
+		return PresenceDiff{Key: key, Leaves: []PresenceEntry{left}}, true
+	}
+
+	return PresenceDiff{}, false
+}
+
+// entry returns the entry of the user with the meta of the most recently tracked remaining connection.
+// The entry of a user that left has the meta of the last connection.
+func (u *presenceUser) entry(userID string) PresenceEntry {
+	var latest presenceRef
+	for _, ref := range u.refs {
+		if ref.seq > latest.seq {
+			latest = ref
+		}
+	}
+	return PresenceEntry{UserID: userID, Meta: latest.meta, Connections: len(u.refs)}
+}
+
+// queueDiff queues diff to be passed to the subscribers and starts dispatching if it is not running.
+// p.mu must be held, so the diffs are queued in the order of the changes.
+func (p *Presence) queueDiff(diff PresenceDiff) {
+	p.pending = append(p.pending, diff)
+	if !p.dispatching {
+		p.dispatching = true
+		go p.dispatch()
+	}
+}
+
+// dispatch passes the pending diffs to the subscribers until none are left.
+// Only one dispatch runs at a time, so the subscribers receive the diffs in order.
+func (p *Presence) dispatch() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for len(p.pending) > 0 {
+		diff := p.pending[0]
+		p.pending = p.pending[1:]
+
+		handlers := make([]func(diff PresenceDiff), 0, len(p.subscribers[diff.Key]))
+		for _, handler := range p.subscribers[diff.Key] {
+			handlers = append(handlers, handler)
+		}
+
+		p.mu.Unlock()
+		for _, handler := range handlers {
+			handler(diff)
+		}
+		p.mu.Lock()
+	}
+
+	p.pending = nil
+	p.dispatching = false
+}
+
+const (
+	presenceOpTrack     = "track"
+	presenceOpUntrack   = "untrack"
+	presenceOpSync      = "sync"
+	presenceOpHeartbeat = "heartbeat"
+
+	presenceTopic = "wsocket.presence"
+)
+
+// presenceEvent is replicated between nodes through the broker.
+type presenceEvent struct {
+	Op     string                 `json:"op"`
+	Key    string                 `json:"key,omitempty"`
+	UserID string                 `json:"user_id,omitempty"`
+	Ref    string                 `json:"ref,omitempty"`
+	Meta   map[string]interface{} `json:"meta,omitempty"`
+}
+
+// UseBroker replicates the tracked entries across the nodes subscribed to broker.
+// The replication events are published in order by a single goroutine without waiting for the broker.
+// If the broker can't keep up and the queue is full, the events are dropped.
+// Use a broker dedicated to presence, e.g. a separate Redis channel, so the replication messages don't reach PubSub topics.
+// nodeID must be unique per node, if empty a random one is generated.
+// The entries of the other nodes are requested on start, and the entries of a node that stops sending heartbeats are removed.
+// Call UseBroker before tracking connections. The replication lasts until ctx is canceled.
+func (p *Presence) UseBroker(ctx context.Context, broker Broker, nodeID string) error {
+	if nodeID == "" {
+		nodeID = NewNodeID()
+	}
+
+	p.mu.Lock()
+	p.nodeID = nodeID
+	p.mu.Unlock()
+
+	err := broker.Subscribe(ctx, func(msg BrokerMessage) {
+		if msg.NodeID == nodeID || msg.Topic != presenceTopic {
+			return
+		}
+		event := presenceEvent{}
+		if err := json.Unmarshal(msg.Message.Message, &event); err != nil {
+			p.logger.Printf("failed to decode presence event: %v", err)
+			return
+		}
+		p.handleRemote(msg.NodeID, event)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to broker: %w", err)
+	}
+
+	queue := make(chan BrokerMessage, brokerQueueSize)
+
+	p.mu.Lock()
+	p.brokerQueue = queue
+	p.replicate(presenceEvent{Op: presenceOpSync})
+	p.mu.Unlock()
+
+	go func() {
+		forwardToBroker(ctx, broker, queue, p.logger)
+
+		p.mu.Lock()
+		if p.brokerQueue == queue {
+			p.brokerQueue = nil
+		}
+		p.mu.Unlock()
+	}()
+	go p.heartbeat(ctx, p.heartbeatInterval)
+
+	return nil
+}
+
+func (p *Presence) heartbeat(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.mu.Lock()
+			p.replicate(presenceEvent{Op: presenceOpHeartbeat})
+			p.mu.Unlock()
+			p.expireNodes()
+		}
+	}
+}
+
+func (p *Presence) handleRemote(nodeID string, event presenceEvent) {
+	p.mu.Lock()
+	p.lastSeen[nodeID] = time.Now()
+
+	var diff PresenceDiff
+	changed := false
+	switch event.Op {
+	case presenceOpTrack:
+		diff, changed = p.add(event.Key, event.UserID, event.Ref, nodeID, event.Meta)
+	case presenceOpUntrack:
+		diff, changed = p.remove(event.Key, event.Ref)
+	case presenceOpSync:
+		for _, e := range p.localEvents() {
+			p.replicate(e)
+		}
+	}
+	if changed {
+		p.queueDiff(diff)
+	}
+	p.mu.Unlock()
+}
+
+// localEvents returns the track events of the entries of this node.
+func (p *Presence) localEvents() []presenceEvent {
+	events := make([]presenceEvent, 0)
+	for key, users := range p.keys {
+		for userID, user := range users {
+			for ref, r := range user.refs {
+				if r.nodeID == p.nodeID {
+					events = append(events, presenceEvent{Op: presenceOpTrack, Key: key, UserID: userID, Ref: ref, Meta: r.meta})
+				}
+			}
+		}
+	}
+	return events
+}
+
+// expireNodes removes the entries of the nodes that missed three heartbeats.
+func (p *Presence) expireNodes() {
+	p.mu.Lock()
+	for nodeID, lastSeen := range p.lastSeen {
+		if time.Since(lastSeen) < 3*p.heartbeatInterval {
+			continue
+		}
+		delete(p.lastSeen, nodeID)
+
+		for key, users := range p.keys {
+			for _, user := range users {
+				for ref, r := range user.refs {
+					if r.nodeID != nodeID {
+						continue
+					}
+					if diff, ok := p.remove(key, ref); ok {
+						p.queueDiff(diff)
+					}
+				}
+			}
+		}
+	}
+	p.mu.Unlock()
+}
+
+// replicate queues event to be published to the other nodes. p.mu must be held,
+// so the events are published in the order of the changes.
+func (p *Presence) replicate(event presenceEvent) {
+	if p.brokerQueue == nil {
+		return
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		p.logger.Printf("failed to encode presence event: %v", err)
+		return
+	}
+
+	select {
+	case p.brokerQueue <- BrokerMessage{NodeID: p.nodeID, Topic: presenceTopic, Message: NewTextMessage(data)}:
+	default:
+		p.logger.Printf("failed to replicate presence event: queue is full")
+	}
+}
@@ -0,0 +1,258 @@
+package wsocket
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPresence_Track(t *testing.T) {
+	presence := NewPresence(NoLogger())
+
+	var mu sync.Mutex
+	diffs := make([]PresenceDiff, 0)
+	unsubscribe := presence.Subscribe("doc-1", func(diff PresenceDiff) {
+		mu.Lock()
+		defer mu.Unlock()
+		diffs = append(diffs, diff)
+	})
+	defer unsubscribe()
+
+	aliceTab1 := newTestConnection()
+	aliceTab2 := newTestConnection()
+	bob := newTestConnection()
+
+	presence.Track(aliceTab1, "doc-1", "alice", map[string]interface{}{"color": "red"})
+	presence.Track(aliceTab2, "doc-1", "alice", map[string]interface{}{"color": "blue"})
+	presence.Track(bob, "doc-1", "bob", nil)
+	presence.Track(bob, "doc-2", "bob", nil)
+
+	assert.Equal(t, []PresenceEntry{
+		{UserID: "alice", Meta: map[string]interface{}{"color": "blue"}, Connections: 2},
+		{UserID: "bob", Connections: 1},
+	}, presence.List("doc-1"))
+	assert.Len(t, presence.List("doc-2"), 1)
+
+	presence.Untrack(aliceTab1, "doc-1")
+	assert.Equal(t, 1, presence.List("doc-1")[0].Connections)
+
+	assert.NoError(t, aliceTab2.Close())
+	assert.NoError(t, bob.Close())
+	assert.Eventually(t, func() bool {
+		return len(presence.List("doc-1")) == 0 && len(presence.List("doc-2")) == 0
+	}, time.Second, 10*time.Millisecond)
+
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(diffs) == 4
+	}, time.Second, 10*time.Millisecond, "Expected a join and a leave for each user")
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, "alice", diffs[0].Joins[0].UserID)
+	assert.Equal(t, "bob", diffs[1].Joins[0].UserID)
+	for _, diff := range diffs[2:] {
+		assert.Len(t, diff.Leaves, 1)
+		assert.Empty(t, diff.Joins)
+	}
+}
+
+func TestPresence_PublishDiffs(t *testing.T) {
+	presence := NewPresence(NoLogger())
+
+	watcher := newTestConnection()
+	presence.PublishDiffs(watcher, "doc-1")
+
+	presence.Track(newTestConnection(), "doc-1", "alice", map[string]interface{}{"color": "red"})
+
+	assert.Eventually(t, func() bool {
+		return len(watcher.Messages()) == 1
+	}, time.Second, 10*time.Millisecond)
+	messages := watcher.Messages()
+	diff := map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal([]byte(messages[0]), &diff))
+	assert.Equal(t, "presence_diff", diff["type"])
+	assert.Equal(t, "doc-1", diff["key"])
+	assert.Equal(t, "alice", diff["joins"].([]interface{})[0].(map[string]interface{})["user_id"])
+}
+
+func TestPresence_UseBroker(t *testing.T) {
+	broker := NewInProcessBroker()
+
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	defer cancel1()
+	node1 := NewPresence(NoLogger(), WithHeartbeatInterval(20*time.Millisecond))
+	assert.NoError(t, node1.UseBroker(ctx1, broker, "node-1"))
+	node1.Track(newTestConnection(), "doc-1", "alice", map[string]interface{}{"color": "red"})
+
+	// node2 starts later and receives the existing entries of node1.
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+	node2 := NewPresence(NoLogger(), WithHeartbeatInterval(20*time.Millisecond))
+	assert.NoError(t, node2.UseBroker(ctx2, broker, "node-2"))
+	assert.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual([]PresenceEntry{{UserID: "alice", Meta: map[string]interface{}{"color": "red"}, Connections: 1}}, node2.List("doc-1"))
+	}, time.Second, 10*time.Millisecond)
+
+	// Connections of the same user on different nodes are merged.
+	bobConn := newTestConnection()
+	node2.Track(bobConn, "doc-1", "bob", nil)
+	node2.Track(newTestConnection(), "doc-1", "alice", map[string]interface{}{"color": "green"})
+	assert.Eventually(t, func() bool {
+		entries := node1.List("doc-1")
+		return len(entries) == 2 && entries[0].Connections == 2
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, node1.List("doc-1"), node2.List("doc-1"))
+
+	assert.NoError(t, bobConn.Close())
+	assert.Eventually(t, func() bool {
+		return len(node1.List("doc-1")) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// The entries of a node that stops sending heartbeats expire.
+	cancel2()
+	assert.Eventually(t, func() bool {
+		entries := node1.List("doc-1")
+		return len(entries) == 1 && entries[0].Connections == 1
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, map[string]interface{}{"color": "red"}, node1.List("doc-1")[0].Meta,
+		"Expected the meta of the remaining connection")
+}
+
+func TestPresence_MetaOfRemainingConnections(t *testing.T) {
+	presence := NewPresence(NoLogger())
+
+	tab1 := newTestConnection()
+	tab2 := newTestConnection()
+	presence.Track(tab1, "doc-1", "alice", map[string]interface{}{"color": "red"})
+	presence.Track(tab2, "doc-1", "alice", map[string]interface{}{"color": "blue"})
+	assert.Equal(t, map[string]interface{}{"color": "blue"}, presence.List("doc-1")[0].Meta)
+
+	presence.Untrack(tab2, "doc-1")
+	assert.Equal(t, map[string]interface{}{"color": "red"}, presence.List("doc-1")[0].Meta)
+
+	presence.Track(tab2, "doc-1", "alice", map[string]interface{}{"color": "green"})
+	presence.Track(tab1, "doc-1", "alice", map[string]interface{}{"color": "yellow"})
+	presence.Untrack(tab1, "doc-1")
+	assert.Equal(t, map[string]interface{}{"color": "green"}, presence.List("doc-1")[0].Meta)
+}
+
+func TestPresence_DiffOrder(t *testing.T) {
+	presence := NewPresence(NoLogger())
+
+	var mu sync.Mutex
+	present := false
+	outOfOrder := 0
+	presence.Subscribe("doc-1", func(diff PresenceDiff) {
+		mu.Lock()
+		defer mu.Unlock()
+		if (len(diff.Joins) > 0) == present {
+			outOfOrder++
+		}
+		present = len(diff.Joins) > 0
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn := newTestConnection()
+			for j := 0; j < 100; j++ {
+				presence.Track(conn, "doc-1", "alice", nil)
+				presence.Untrack(conn, "doc-1")
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Eventually(t, func() bool {
+		presence.mu.Lock()
+		defer presence.mu.Unlock()
+		return !presence.dispatching
+	}, time.Second, 10*time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Zero(t, outOfOrder, "Expected joins and leaves to alternate")
+	assert.False(t, present)
+	assert.Empty(t, presence.List("doc-1"))
+}
+
+func TestPresence_SubscribersDontBlockTracking(t *testing.T) {
+	presence := NewPresence(NoLogger())
+
+	release := make(chan struct{})
+	received := make(chan PresenceDiff, 2)
+	presence.Subscribe("doc-1", func(diff PresenceDiff) {
+		<-release
+		received <- diff
+	})
+
+	conn := newTestConnection()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		presence.Track(conn, "doc-1", "alice", nil)
+		presence.Untrack(conn, "doc-1")
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected Track and Untrack not to wait for the subscribers")
+	}
+
+	close(release)
+	assert.Len(t, (<-received).Joins, 1)
+	assert.Len(t, (<-received).Leaves, 1)
+}
+
+func TestPresence_PublishDiffsClosesSlowConnection(t *testing.T) {
+	presence := NewPresence(NoLogger())
+
+	slow := slowTestConnection{newTestConnection()}
+	presence.PublishDiffs(slow, "doc-1")
+	presence.Track(newTestConnection(), "doc-1", "alice", nil)
+
+	select {
+	case <-slow.Wait():
+	case <-time.After(time.Second):
+		t.Fatal("Expected the slow connection to be closed")
+	}
+}
+
+func TestPresence_ReplicationOrder(t *testing.T) {
+	broker := NewInProcessBroker()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	node1 := NewPresence(NoLogger())
+	assert.NoError(t, node1.UseBroker(ctx, broker, "node-1"))
+	node2 := NewPresence(NoLogger())
+	assert.NoError(t, node2.UseBroker(ctx, broker, "node-2"))
+
+	conn := newTestConnection()
+	for i := 0; i < 100; i++ {
+		node1.Track(conn, "doc-1", "alice", nil)
+		node1.Untrack(conn, "doc-1")
+	}
+	node1.Track(conn, "doc-1", "bob", nil)
+
+	assert.Eventually(t, func() bool {
+		entries := node2.List("doc-1")
+		return len(entries) == 1 && entries[0].UserID == "bob"
+	}, time.Second, 10*time.Millisecond)
+	assert.Never(t, func() bool {
+		return len(node2.List("doc-1")) != 1
+	}, 50*time.Millisecond, 10*time.Millisecond, "Expected no late events of alice")
+}
+
+func TestNewPresence_InvalidHeartbeatInterval(t *testing.T) {
+	assert.Panics(t, func() { NewPresence(NoLogger(), WithHeartbeatInterval(0)) })
+}
@@ -0,0 +1,129 @@
+package wsocket
+
+import (
+	"fmt"
+	"time"
+)
+
+// Priority is the priority of an outgoing message.
+// Messages of a higher priority are written before the queued messages of a lower priority.
+type Priority int8
+
+// Message priorities. Messages have PriorityNormal by default.
+const (
+	PriorityLow    Priority = -1
+	PriorityNormal Priority = 0
+	PriorityHigh   Priority = 1
+)
+
+// DefaultStarvationLimit is the default number of times a queued message can be passed over by messages of a higher priority.
+const DefaultStarvationLimit = 8
+
+// lane indexes, from the highest priority to the lowest.
+const (
+	laneHigh = iota
+	laneNormal
+	laneLow
+	laneCount
+)
+
+// WithPriority returns a copy of the message with the priority.
+// Close messages and error replies have PriorityHigh by default.
+func (m Message) WithPriority(priority Priority) Message {
+	m.priority = priority
+	return m
+}
+
+// WithStarvationLimit sets how many times a queued message can be passed over by messages of a higher priority.
+// Once the limit is reached, the lower priority message is written next. The default is DefaultStarvationLimit.
+// If limit is less than 1, WithStarvationLimit panics.
+func WithStarvationLimit(limit int) ClientOption {
+	if limit < 1 {
+		panic(fmt.Sprintf("wsocket: invalid starvation limit: %d", limit))
+	}
+
+	return func(c *client) {
+		c.connConfig.starvationLimit = limit
+	}
+}
+
+func (p Priority) lane() int {
+	switch {
+	case p > PriorityNormal:
+		return laneHigh
+	case p < PriorityNormal:
+		return laneLow
+	default:
+		return laneNormal
+	}
+}
+
+// nextMessage returns the next message to write, the queued message of the highest priority.
+// If wait is false, it returns only a message that is already queued, otherwise it waits until timeout fires or forever if timeout is nil.
+// It returns false if there is no message or the connection is closed.
+func (c *connection) nextMessage(wait bool, timeout <-chan time.Time) (Message, bool) {
+	select {
+	case <-c.closedChan:
+		return Message{}, false
+	default:
+	}
+
+	// Starvation protection: a lane passed over too many times is served first, starting from the lowest priority.
+	for lane := laneCount - 1; lane > laneHigh; lane-- {
+		if c.skipped[lane] < c.starvationLimit {
+			continue
+		}
+		if msg, ok := c.tryLane(lane); ok {
+			return msg, true
+		}
+		c.skipped[lane] = 0
+	}
+
+	for lane := 0; lane < laneCount; lane++ {
+		if msg, ok := c.tryLane(lane); ok {
+			return msg, true
+		}
+	}
+
+	if !wait {
+		return Message{}, false
+	}
+
+	select {
+	case msg := <-c.lanes[laneHigh]:
+		return c.dequeued(laneHigh, msg), true
+	case msg := <-c.lanes[laneNormal]:
+		return c.dequeued(laneNormal, msg), true
+	case msg := <-c.lanes[laneLow]:
+		return c.dequeued(laneLow, msg), true
+	case <-timeout:
+		return Message{}, false
+	case <-c.closedChan:
+		return Message{}, false
+	}
+}
+
+func (c *connection) tryLane(lane int) (Message, bool) {
+	select {
+	case msg := <-c.lanes[lane]:
+		return c.dequeued(lane, msg), true
+	default:
+		return Message{}, false
+	}
+}
+
+// dequeued is called for every message taken from the lane.
+// It updates the starvation counters and resolves conflation placeholders.
+func (c *connection) dequeued(lane int, msg Message) Message {
+	c.skipped[lane] = 0
+	for lower := lane + 1; lower < laneCount; lower++ {
+		if len(c.lanes[lower]) > 0 {
+			c.skipped[lower]++
+		}
+	}
+
+	if msg.conflationKey != "" {
+		return c.resolveConflated(msg)
+	}
+	return msg
+}
@@ -0,0 +1,110 @@
+package wsocket
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestQueue creates a connection without a message writer to test the write queue.
+func newTestQueue(starvationLimit, size int) *connection {
+	c := &connection{
+		closedChan:      make(chan struct{}),
+		starvationLimit: starvationLimit,
+		conflated:       make(map[string]Message),
+	}
+	for lane := range c.lanes {
+		c.lanes[lane] = make(chan Message, size)
+	}
+	return c
+}
+
+func TestConnection_NextMessage(t *testing.T) {
+	tests := []struct {
+		name            string
+		starvationLimit int
+		messages        []Message
+		expected        []string
+	}{
+		{
+			name:            "priority order",
+			starvationLimit: 10,
+			messages: []Message{
+				NewTextMessage([]byte("low")).WithPriority(PriorityLow),
+				NewTextMessage([]byte("normal 1")),
+				NewTextMessage([]byte("high")).WithPriority(PriorityHigh),
+				NewTextMessage([]byte("normal 2")),
+				NewCloseMessage(),
+			},
+			expected: []string{"high", "", "normal 1", "normal 2", "low"},
+		},
+		{
+			name:            "starvation protection",
+			starvationLimit: 2,
+			messages: []Message{
+				NewTextMessage([]byte("low")).WithPriority(PriorityLow),
+				NewTextMessage([]byte("normal 1")),
+				NewTextMessage([]byte("normal 2")),
+				NewTextMessage([]byte("high 1")).WithPriority(PriorityHigh),
+				NewTextMessage([]byte("high 2")).WithPriority(PriorityHigh),
+				NewTextMessage([]byte("high 3")).WithPriority(PriorityHigh),
+				NewTextMessage([]byte("high 4")).WithPriority(PriorityHigh),
+			},
+			expected: []string{"high 1", "high 2", "low", "normal 1", "high 3", "high 4", "normal 2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestQueue(tt.starvationLimit, 10)
+			for _, msg := range tt.messages {
+				c.lanes[msg.priority.lane()] <- msg
+			}
+
+			actual := make([]string, 0, len(tt.expected))
+			for {
+				msg, ok := c.nextMessage(false, nil)
+				if !ok {
+					break
+				}
+				actual = append(actual, string(msg.Message))
+			}
+			assert.Equal(t, tt.expected, actual)
+		})
+	}
+}
+
+func TestConnection_NextMessage_Wait(t *testing.T) {
+	c := newTestQueue(DefaultStarvationLimit, 10)
+
+	_, ok := c.nextMessage(true, time.After(10*time.Millisecond))
+	assert.False(t, ok, "Expected no message before the timeout")
+
+	go func() {
+		c.lanes[laneLow] <- NewTextMessage([]byte("low"))
+	}()
+	msg, ok := c.nextMessage(true, nil)
+	assert.True(t, ok)
+	assert.Equal(t, "low", string(msg.Message))
+
+	close(c.closedChan)
+	c.lanes[laneHigh] <- NewTextMessage([]byte("high"))
+	_, ok = c.nextMessage(true, nil)
+	assert.False(t, ok, "Expected no message after the connection is closed")
+}
+
+func TestConnection_TryWriteMessage(t *testing.T) {
+	c := newTestQueue(DefaultStarvationLimit, 1)
+
+	assert.NoError(t, c.tryWriteMessage(NewTextMessage([]byte("1"))))
+	assert.ErrorIs(t, c.tryWriteMessage(NewTextMessage([]byte("2"))), errWriteQueueFull)
+	assert.NoError(t, c.tryWriteMessage(NewTextMessage([]byte("high")).WithPriority(PriorityHigh)), "Expected the lanes to be queued separately")
+
+	assert.NoError(t, c.tryWriteMessage(NewTextMessage([]byte("EURUSD 1.1")).WithConflationKey("EURUSD").WithPriority(PriorityLow)))
+	assert.ErrorIs(t, c.tryWriteMessage(NewTextMessage([]byte("GBPUSD 1.3")).WithConflationKey("GBPUSD").WithPriority(PriorityLow)), errWriteQueueFull)
+	assert.NotContains(t, c.conflated, "GBPUSD", "Expected a conflated message not queued to be discarded")
+
+	close(c.closedChan)
+	assert.ErrorIs(t, c.tryWriteMessage(NewTextMessage([]byte("3")).WithPriority(PriorityLow)), ErrConnectionClosed)
+}
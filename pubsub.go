@@ -0,0 +1,369 @@
+package wsocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PubSub delivers published messages to the connections subscribed to a topic.
+//
+// Topics consist of segments separated by '.' or '/'. A subscription can use wildcards:
+// "*" matches any single segment and a trailing "**" matches one or more remaining segments.
+// For example, a connection subscribed to "prices.*" receives messages published to "prices.EURUSD".
+// Subscriptions are removed automatically when the connection is closed.
+//
+// Messages are queued to the subscribers without waiting. A subscriber whose write queue is full is too slow to keep up:
+// the message is dropped for it, and it is unsubscribed and closed, so the client can reconnect and resubscribe.
+//
+// By default, messages are only delivered to the connections of the current process.
+// Use UseBroker to deliver them to the connections of all nodes.
+type PubSub struct {
+	mu sync.RWMutex
+
+	logger Logger
+
+	// brokerQueue holds the messages to publish through the broker, nil if no broker is used.
+	brokerQueue chan BrokerMessage
+	nodeID      string
+
+	// topics holds the subscribers of every topic or pattern.
+	topics map[string]map[Connection]struct{}
+	// patterns matches published topics against the subscribed topics and patterns.
+	patterns *routeTrie
+	// connections holds the subscriptions of every connection.
+	connections map[Connection]*subscriber
+}
+
+// subscriber holds the topics a connection is subscribed to.
+type subscriber struct {
+	topics map[string]struct{}
+	// removed is closed when the connection is forgotten, so removeOnClose stops waiting for it to close.
+	removed chan struct{}
+}
+
+// ErrorCodeInvalidTopic is the code of the ErrorReply sent when a "subscribe" message has an invalid topic.
+const ErrorCodeInvalidTopic = "invalid_topic"
+
+// NewPubSub creates a new PubSub instance.
+// logger is used to log delivery errors. If nil, a default logger is used.
+func NewPubSub(logger Logger) *PubSub {
+	if logger == nil {
+		logger = DefaultLogger()
+	}
+
+	return &PubSub{
+		logger:      logger,
+		topics:      make(map[string]map[Connection]struct{}),
+		patterns:    newRouteTrie(),
+		connections: make(map[Connection]*subscriber),
+	}
+}
+
+// Subscribe subscribes conn to topic. topic can contain wildcards.
+// Subscribing to the same topic twice has no effect.
+func (p *PubSub) Subscribe(conn Connection, topic string) error {
+	if err := validateTopic(topic); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	subscribers, ok := p.topics[topic]
+	if !ok {
+		if err := p.patterns.add(topic, nil); err != nil {
+			return fmt.Errorf("invalid topic: %w", err)
+		}
+		subscribers = make(map[Connection]struct{})
+		p.topics[topic] = subscribers
+	}
+	subscribers[conn] = struct{}{}
+
+	sub, ok := p.connections[conn]
+	if !ok {
+		sub = &subscriber{topics: make(map[string]struct{}), removed: make(chan struct{})}
+		p.connections[conn] = sub
+		go p.removeOnClose(conn, sub)
+	}
+	sub.topics[topic] = struct{}{}
+
+	return nil
+}
+
+// Unsubscribe unsubscribes conn from topic. topic must be the same as passed to Subscribe.
+func (p *PubSub) Unsubscribe(conn Connection, topic string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.unsubscribe(conn, topic)
+}
+
+// UnsubscribeAll unsubscribes conn from all topics.
+func (p *PubSub) UnsubscribeAll(conn Connection) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.removeConnection(conn)
+}
+
+func (p *PubSub) unsubscribe(conn Connection, topic string) {
+	if subscribers, ok := p.topics[topic]; ok {
+		delete(subscribers, conn)
+		if len(subscribers) == 0 {
+			delete(p.topics, topic)
+			p.patterns.remove(topic)
+		}
+	}
+	if sub, ok := p.connections[conn]; ok {
+		delete(sub.topics, topic)
+	}
+}
+
+// removeOnClose forgets conn when it is closed, unless sub is removed before.
+func (p *PubSub) removeOnClose(conn Connection, sub *subscriber) {
+	select {
+	case <-conn.Wait():
+	case <-sub.removed:
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.connections[conn] == sub {
+		p.removeConnection(conn)
+	}
+}
+
+// removeConnection unsubscribes conn from all topics and forgets it.
+func (p *PubSub) removeConnection(conn Connection) {
+	sub, ok := p.connections[conn]
+	if !ok {
+		return
+	}
+	for topic := range sub.topics {
+		p.unsubscribe(conn, topic)
+	}
+	delete(p.connections, conn)
+	close(sub.removed)
+}
+
+// UseBroker distributes the messages published on this node to the other nodes through broker
+// and delivers the messages published on the other nodes to the local subscribers.
+// nodeID must be unique per node, if empty a random one is generated. The messages published by this node
+// are delivered locally by Publish and ignored when the broker echoes them back.
+// The broker subscription and the publishing through the broker last until ctx is canceled.
+func (p *PubSub) UseBroker(ctx context.Context, broker Broker, nodeID string) error {
+	if nodeID == "" {
+		nodeID = NewNodeID()
+	}
+
+	err := broker.Subscribe(ctx, func(msg BrokerMessage) {
+		if msg.NodeID == nodeID {
+			return
+		}
+		p.publishLocal(msg.Topic, msg.Message)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to broker: %w", err)
+	}
+
+	queue := make(chan BrokerMessage, brokerQueueSize)
+
+	p.mu.Lock()
+	p.brokerQueue = queue
+	p.nodeID = nodeID
+	p.mu.Unlock()
+
+	go func() {
+		forwardToBroker(ctx, broker, queue, p.logger)
+
+		p.mu.Lock()
+		if p.brokerQueue == queue {
+			p.brokerQueue = nil
+		}
+		p.mu.Unlock()
+	}()
+
+	return nil
+}
+
+const (
+	// brokerQueueSize is the number of published messages waiting to be sent to the broker.
+	brokerQueueSize = 1024
+	// brokerPublishTimeout is the time allowed to publish a message to the broker.
+	brokerPublishTimeout = 5 * time.Second
+)
+
+// forwardToBroker publishes the queued messages to the broker in order until ctx is canceled.
+func forwardToBroker(ctx context.Context, broker Broker, queue <-chan BrokerMessage, logger Logger) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg := <-queue:
+			publishCtx, cancel := context.WithTimeout(ctx, brokerPublishTimeout)
+			err := broker.Publish(publishCtx, msg)
+			cancel()
+			if err != nil && ctx.Err() == nil {
+				logger.Printf("failed to publish message to topic %q through broker: %v", msg.Topic, err)
+			}
+		}
+	}
+}
+
+// Publish writes msg to every connection subscribed to topic or to a matching wildcard topic.
+// Every connection receives the message once, even if several of its subscriptions match.
+// topic must not contain wildcards. The number of local connections the message was written to is returned.
+// If a broker is used, the message is also queued to be published to the other nodes without waiting for the broker.
+// If the broker can't keep up and the queue is full, the message is not published to the other nodes.
+func (p *PubSub) Publish(topic string, msg Message) int {
+	delivered := p.publishLocal(topic, msg)
+
+	p.mu.RLock()
+	queue, nodeID := p.brokerQueue, p.nodeID
+	p.mu.RUnlock()
+
+	if queue != nil {
+		select {
+		case queue <- BrokerMessage{NodeID: nodeID, Topic: topic, Message: msg}:
+		default:
+			p.logger.Printf("failed to publish message to topic %q through broker: queue is full", topic)
+		}
+	}
+
+	return delivered
+}
+
+func (p *PubSub) publishLocal(topic string, msg Message) int {
+	delivered := 0
+	for _, conn := range p.subscribers(topic) {
+		err := writeNonBlocking(conn, msg)
+		switch {
+		case err == nil:
+			delivered++
+		case errors.Is(err, errWriteQueueFull):
+			p.logger.Printf("closing slow subscriber of topic %q: %v", topic, err)
+			p.UnsubscribeAll(conn)
+			// Closing writes the close message, it must not hold up the other subscribers.
+			go conn.Close()
+		case !errors.Is(err, ErrConnectionClosed):
+			p.logger.Printf("failed to publish message to topic %q: %v", topic, err)
+		}
+	}
+
+	return delivered
+}
+
+func (p *PubSub) subscribers(topic string) []Connection {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	seen := make(map[Connection]struct{})
+	result := make([]Connection, 0)
+	p.patterns.matchAll(topic, func(subscription string) {
+		for conn := range p.topics[subscription] {
+			if _, ok := seen[conn]; !ok {
+				seen[conn] = struct{}{}
+				result = append(result, conn)
+			}
+		}
+	})
+
+	return result
+}
+
+// SubscriberCount returns the number of connections subscribed to topic.
+// Wildcard subscriptions are counted by the exact pattern they were made with.
+func (p *PubSub) SubscriberCount(topic string) int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return len(p.topics[topic])
+}
+
+// Topics returns the number of subscribers of every topic with at least one subscriber.
+func (p *PubSub) Topics() map[string]int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	counts := make(map[string]int, len(p.topics))
+	for topic, subscribers := range p.topics {
+		counts[topic] = len(subscribers)
+	}
+
+	return counts
+}
+
+// AddHandlers adds the built-in "subscribe" and "unsubscribe" handlers to r.
+// The messages carry the topic in the "topic" field, e.g. {"type": "subscribe", "topic": "prices.*"},
+// and are confirmed with {"type": "subscribed", "topic": "prices.*"} and {"type": "unsubscribed", "topic": "prices.*"}.
+// A "subscribe" message with an invalid topic is rejected with an ErrorReply with code ErrorCodeInvalidTopic.
+func (p *PubSub) AddHandlers(r *JSONResolver) *JSONResolver {
+	return r.
+		AddHandler("subscribe", p.handleSubscription(true)).
+		AddHandler("unsubscribe", p.handleSubscription(false))
+}
+
+func (p *PubSub) handleSubscription(subscribe bool) Handler {
+	return func(ctx context.Context, msg []byte, rw ResponseWriter) error {
+		conn, ok := ConnectionFromContext(ctx)
+		if !ok {
+			return fmt.Errorf("no connection in context")
+		}
+
+		jsonMsg, err := ParseJSONMessage(ctx, msg)
+		if err != nil {
+			return err
+		}
+		topic := jsonMsg.GetString("topic")
+
+		replyType := "unsubscribed"
+		if subscribe {
+			replyType = "subscribed"
+			if err := p.Subscribe(conn, topic); err != nil {
+				if replyErr := WriteErrorReply(rw, NewErrorReply(ErrorCodeInvalidTopic, err.Error(), "subscribe", nil)); replyErr != nil {
+					return fmt.Errorf("failed to write error reply: %w", replyErr)
+				}
+				return err
+			}
+		} else {
+			p.Unsubscribe(conn, topic)
+		}
+
+		reply, err := json.Marshal(struct {
+			Type  string `json:"type"`
+			Topic string `json:"topic"`
+		}{Type: replyType, Topic: topic})
+		if err != nil {
+			return err
+		}
+
+		return rw.WriteMessage(NewTextMessage(reply))
+	}
+}
+
+// validateTopic checks that topic consists of literal segments and the "*" and "**" wildcards.
+func validateTopic(topic string) error {
+	segments, err := splitRoute(topic)
+	if err != nil {
+		return fmt.Errorf("invalid topic: %w", err)
+	}
+	for i, segment := range segments {
+		switch {
+		case segment.value == catchAllParam:
+			if i != len(segments)-1 {
+				return fmt.Errorf("%q must be the last segment of topic %q", catchAllParam, topic)
+			}
+		case segment.value == "*":
+		case strings.ContainsAny(segment.value, "*{}"):
+			return fmt.Errorf("invalid segment %q in topic %q", segment.value, topic)
+		}
+	}
+	return nil
+}
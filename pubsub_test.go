@@ -0,0 +1,236 @@
+package wsocket
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPubSub_Publish(t *testing.T) {
+	ps := NewPubSub(NoLogger())
+
+	exact := newTestConnection()
+	wildcard := newTestConnection()
+	catchAll := newTestConnection()
+	both := newTestConnection()
+
+	assert.NoError(t, ps.Subscribe(exact, "prices.EURUSD"))
+	assert.NoError(t, ps.Subscribe(wildcard, "prices.*"))
+	assert.NoError(t, ps.Subscribe(catchAll, "prices.**"))
+	assert.NoError(t, ps.Subscribe(both, "prices.EURUSD"))
+	assert.NoError(t, ps.Subscribe(both, "prices.*"))
+
+	assert.Equal(t, 4, ps.Publish("prices.EURUSD", NewTextMessage([]byte("1.1"))))
+	assert.Equal(t, 3, ps.Publish("prices.GBPUSD", NewTextMessage([]byte("1.3"))))
+	assert.Equal(t, 1, ps.Publish("prices.GBPUSD.bid", NewTextMessage([]byte("1.2"))))
+	assert.Equal(t, 0, ps.Publish("trades.EURUSD", NewTextMessage([]byte("1"))))
+	assert.Equal(t, 0, ps.Publish("prices/EURUSD", NewTextMessage([]byte("1"))), "Expected separators to be matched literally")
+
+	assert.Equal(t, []string{"1.1"}, exact.Messages())
+	assert.Equal(t, []string{"1.1", "1.3"}, wildcard.Messages())
+	assert.Equal(t, []string{"1.1", "1.3", "1.2"}, catchAll.Messages())
+	assert.Equal(t, []string{"1.1", "1.3"}, both.Messages())
+
+	assert.Equal(t, 2, ps.SubscriberCount("prices.EURUSD"))
+	assert.Equal(t, map[string]int{"prices.EURUSD": 2, "prices.*": 2, "prices.**": 1}, ps.Topics())
+
+	ps.Unsubscribe(both, "prices.*")
+	assert.Equal(t, 1, ps.SubscriberCount("prices.*"))
+	ps.UnsubscribeAll(both)
+	assert.Equal(t, 1, ps.SubscriberCount("prices.EURUSD"))
+
+	ps.UnsubscribeAll(exact)
+	ps.mu.RLock()
+	assert.NotContains(t, ps.connections, Connection(exact), "Expected UnsubscribeAll to forget the connection")
+	ps.mu.RUnlock()
+
+	assert.Error(t, ps.Subscribe(exact, ""))
+	assert.Error(t, ps.Subscribe(exact, "prices.**.bid"))
+	assert.Error(t, ps.Subscribe(exact, "prices.{pair}"))
+	assert.Error(t, ps.Subscribe(exact, "prices.EUR*"))
+}
+
+func TestPubSub_UnsubscribeRemovesPatterns(t *testing.T) {
+	ps := NewPubSub(NoLogger())
+
+	conn := newTestConnection()
+	assert.NoError(t, ps.Subscribe(conn, "prices.*.bid"))
+	assert.NoError(t, ps.Subscribe(conn, "prices.**"))
+	ps.UnsubscribeAll(conn)
+
+	assert.True(t, ps.patterns.root.empty(), "Expected the unsubscribed patterns to be removed")
+	assert.Equal(t, 0, ps.Publish("prices.EURUSD.bid", NewTextMessage([]byte("1.1"))))
+}
+
+// slowTestConnection is a testConnection whose write queue is always full.
+type slowTestConnection struct {
+	*testConnection
+}
+
+func (c slowTestConnection) tryWriteMessage(Message) error {
+	return errWriteQueueFull
+}
+
+func TestPubSub_SlowSubscriber(t *testing.T) {
+	ps := NewPubSub(NoLogger())
+
+	fast := newTestConnection()
+	slow := slowTestConnection{newTestConnection()}
+	assert.NoError(t, ps.Subscribe(fast, "prices.*"))
+	assert.NoError(t, ps.Subscribe(slow, "prices.*"))
+
+	assert.Equal(t, 1, ps.Publish("prices.EURUSD", NewTextMessage([]byte("1.1"))))
+	assert.Equal(t, []string{"1.1"}, fast.Messages())
+	assert.Equal(t, 1, ps.SubscriberCount("prices.*"), "Expected the slow subscriber to be unsubscribed")
+
+	select {
+	case <-slow.Wait():
+	case <-time.After(time.Second):
+		t.Fatal("Expected the slow subscriber to be closed")
+	}
+}
+
+func TestPubSub_RemovesClosedConnections(t *testing.T) {
+	ps := NewPubSub(NoLogger())
+
+	conn := newTestConnection()
+	assert.NoError(t, ps.Subscribe(conn, "prices.*"))
+	assert.Equal(t, 1, ps.SubscriberCount("prices.*"))
+
+	assert.NoError(t, conn.Close())
+	assert.Eventually(t, func() bool {
+		return ps.SubscriberCount("prices.*") == 0
+	}, time.Second, 10*time.Millisecond)
+	assert.Empty(t, ps.Topics())
+}
+
+func TestPubSub_UnsubscribeAllStopsWatcher(t *testing.T) {
+	ps := NewPubSub(NoLogger())
+	conn := newTestConnection()
+
+	assert.NoError(t, ps.Subscribe(conn, "prices.*"))
+	ps.mu.RLock()
+	first := ps.connections[conn]
+	ps.mu.RUnlock()
+
+	ps.UnsubscribeAll(conn)
+	select {
+	case <-first.removed:
+	default:
+		t.Fatal("Expected UnsubscribeAll to stop waiting for the connection to close")
+	}
+
+	assert.NoError(t, ps.Subscribe(conn, "prices.*"))
+	assert.NoError(t, conn.Close())
+	assert.Eventually(t, func() bool {
+		return ps.SubscriberCount("prices.*") == 0
+	}, time.Second, 10*time.Millisecond, "Expected the new subscription to be removed on close")
+}
+
+func TestPubSub_AddHandlers(t *testing.T) {
+	ps := NewPubSub(NoLogger())
+	resolver := ps.AddHandlers(NewJSONResolver("type"))
+	wsClient := NewClient(context.Background(), resolver, NoLogger(), 10)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := websocket.Upgrader{}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		assert.NoError(t, err)
+
+		<-wsClient.NewConnection(conn).Wait()
+	}))
+	defer server.Close()
+
+	wsURL := strings.Replace(server.URL, "http://", "ws://", 1)
+	clientConn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	assert.NoError(t, err)
+	defer clientConn.Close()
+
+	assert.NoError(t, clientConn.WriteMessage(websocket.TextMessage, []byte(`{"type": "subscribe", "topic": "prices.*"}`)))
+	_, msg, err := clientConn.ReadMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, `{"type":"subscribed","topic":"prices.*"}`, string(msg))
+
+	assert.Equal(t, 1, ps.Publish("prices.EURUSD", NewTextMessage([]byte(`{"price": 1.1}`))))
+	_, msg, err = clientConn.ReadMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, `{"price": 1.1}`, string(msg))
+
+	assert.NoError(t, clientConn.WriteMessage(websocket.TextMessage, []byte(`{"type": "unsubscribe", "topic": "prices.*"}`)))
+	_, msg, err = clientConn.ReadMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, `{"type":"unsubscribed","topic":"prices.*"}`, string(msg))
+	assert.Equal(t, 0, ps.Publish("prices.EURUSD", NewTextMessage([]byte(`{"price": 1.2}`))))
+
+	assert.NoError(t, clientConn.WriteMessage(websocket.TextMessage, []byte(`{"type": "subscribe", "topic": ""}`)))
+	_, msg, err = clientConn.ReadMessage()
+	assert.NoError(t, err)
+	assert.Contains(t, string(msg), `"code":"invalid_topic"`)
+	assert.Contains(t, string(msg), `"route":"subscribe"`)
+}
+
+// testConnection is an in-memory Connection recording the written messages.
+type testConnection struct {
+	mu         sync.Mutex
+	messages   []Message
+	closedChan chan struct{}
+	closeOnce  sync.Once
+	closeCode  int
+}
+
+func newTestConnection() *testConnection {
+	return &testConnection{closedChan: make(chan struct{})}
+}
+
+func (c *testConnection) WriteMessage(msg Message) error {
+	select {
+	case <-c.closedChan:
+		return ErrConnectionClosed
+	default:
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.messages = append(c.messages, msg)
+	return nil
+}
+
+func (c *testConnection) Close() error {
+	c.closeOnce.Do(func() { close(c.closedChan) })
+	return nil
+}
+
+func (c *testConnection) CloseWithReason(code int, _ string) error {
+	c.mu.Lock()
+	c.closeCode = code
+	c.mu.Unlock()
+	return c.Close()
+}
+
+func (c *testConnection) CloseCode() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closeCode
+}
+
+func (c *testConnection) Wait() <-chan struct{} {
+	return c.closedChan
+}
+
+func (c *testConnection) Messages() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	result := make([]string, 0, len(c.messages))
+	for _, msg := range c.messages {
+		result = append(result, string(msg.Message))
+	}
+	return result
+}
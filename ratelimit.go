@@ -0,0 +1,215 @@
+package wsocket
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ErrRateLimited is returned when a message exceeds the rate limit.
+// The client doesn't log it, see RateLimiter.Stats for the number of throttled messages.
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+// ErrorCodeRateLimited is the code of the ErrorReply sent by RateLimitReply.
+const ErrorCodeRateLimited = "rate_limited"
+
+// RateLimitAction is the action taken when a message exceeds the rate limit.
+type RateLimitAction int
+
+const (
+	// RateLimitDrop drops the message silently.
+	RateLimitDrop RateLimitAction = iota
+	// RateLimitReply drops the message and replies with an ErrorReply with code ErrorCodeRateLimited.
+	RateLimitReply
+	// RateLimitClose drops the message and closes the connection with the policy violation close code (1008).
+	RateLimitClose
+)
+
+// RateLimitScope selects the bucket a message is counted against.
+// It returns the bucket key and false if the message is not limited.
+type RateLimitScope func(ctx context.Context) (interface{}, bool)
+
+type globalRateLimitKey struct{}
+
+// RateLimitGlobal counts all messages against a single bucket.
+func RateLimitGlobal() RateLimitScope {
+	return func(ctx context.Context) (interface{}, bool) {
+		return globalRateLimitKey{}, true
+	}
+}
+
+// RateLimitPerConnection counts the messages of every connection against a separate bucket.
+func RateLimitPerConnection() RateLimitScope {
+	return func(ctx context.Context) (interface{}, bool) {
+		conn, ok := ConnectionFromContext(ctx)
+		return conn, ok
+	}
+}
+
+// RateLimitPerUser counts the messages of every user against a separate bucket.
+// userID returns the authenticated user of the message, messages without a user are not limited.
+func RateLimitPerUser(userID func(ctx context.Context) string) RateLimitScope {
+	return func(ctx context.Context) (interface{}, bool) {
+		id := userID(ctx)
+		return id, id != ""
+	}
+}
+
+// RateLimiterStats holds the number of messages checked by a RateLimiter.
+type RateLimiterStats struct {
+	Allowed   uint64
+	Throttled uint64
+}
+
+// RateLimiter limits the rate of messages with token buckets.
+// Every bucket holds up to burst tokens and is refilled with rate tokens per second, every message takes a token.
+// Use Middleware to limit all messages of a client, or WithRateLimit to limit the messages of a JSONResolver route.
+type RateLimiter struct {
+	mu sync.Mutex
+
+	rate   float64
+	burst  float64
+	scope  RateLimitScope
+	action RateLimitAction
+
+	buckets   map[interface{}]*tokenBucket
+	lastSweep time.Time
+
+	allowed   uint64
+	throttled uint64
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimitSweepInterval is how often idle buckets are removed.
+const rateLimitSweepInterval = time.Minute
+
+// NewRateLimiter creates a new RateLimiter instance.
+// rate is the number of messages per second, burst is the number of messages allowed at once.
+func NewRateLimiter(rate float64, burst int, scope RateLimitScope, action RateLimitAction) *RateLimiter {
+	return &RateLimiter{
+		rate:      rate,
+		burst:     float64(burst),
+		scope:     scope,
+		action:    action,
+		buckets:   make(map[interface{}]*tokenBucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// Middleware returns a client middleware applying the rate limit to every message.
+func (l *RateLimiter) Middleware() Middleware {
+	return func(ctx context.Context, msg []byte) (context.Context, []byte, error) {
+		conn, _ := ConnectionFromContext(ctx)
+		if err := l.check(ctx, conn, ""); err != nil {
+			return ctx, nil, err
+		}
+		return ctx, msg, nil
+	}
+}
+
+// WithRateLimit applies the rate limit to the messages of a JSONResolver route.
+// A limiter can be shared by several routes to limit them together.
+func WithRateLimit(l *RateLimiter) RouteOption {
+	return func(c *routeConfig) {
+		c.middlewares = append(c.middlewares, func(name string, next Handler) Handler {
+			return func(ctx context.Context, msg []byte, rw ResponseWriter) error {
+				if err := l.check(ctx, rw, name); err != nil {
+					return err
+				}
+				return next(ctx, msg, rw)
+			}
+		})
+	}
+}
+
+// Stats returns the number of allowed and throttled messages.
+func (l *RateLimiter) Stats() RateLimiterStats {
+	return RateLimiterStats{
+		Allowed:   atomic.LoadUint64(&l.allowed),
+		Throttled: atomic.LoadUint64(&l.throttled),
+	}
+}
+
+// check takes a token for the message and applies the action if there is none.
+func (l *RateLimiter) check(ctx context.Context, rw ResponseWriter, route string) error {
+	key, ok := l.scope(ctx)
+	if !ok {
+		return nil
+	}
+
+	retryAfter, allowed := l.take(key, time.Now())
+	if allowed {
+		atomic.AddUint64(&l.allowed, 1)
+		return nil
+	}
+	atomic.AddUint64(&l.throttled, 1)
+
+	switch l.action {
+	case RateLimitReply:
+		if rw != nil {
+			details := map[string]int64{"retry_after_ms": retryAfter.Milliseconds()}
+			if err := WriteErrorReply(rw, NewErrorReply(ErrorCodeRateLimited, "rate limit exceeded", route, details)); err != nil {
+				return err
+			}
+		}
+	case RateLimitClose:
+		if conn, ok := ConnectionFromContext(ctx); ok {
+			if err := CloseWithReason(conn, websocket.ClosePolicyViolation, "rate limit exceeded"); err != nil {
+				return err
+			}
+		}
+	}
+
+	return ErrRateLimited
+}
+
+// take takes a token from the bucket of key.
+// If there is no token, it returns the time until the next one is available.
+func (l *RateLimiter) take(key interface{}, now time.Time) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = bucket
+	}
+
+	bucket.tokens = math.Min(l.burst, bucket.tokens+now.Sub(bucket.last).Seconds()*l.rate)
+	bucket.last = now
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return 0, true
+	}
+
+	if l.rate <= 0 {
+		return 0, false
+	}
+	return time.Duration((1 - bucket.tokens) / l.rate * float64(time.Second)), false
+}
+
+// sweep removes the buckets that are full again, they are recreated on the next message.
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < rateLimitSweepInterval {
+		return
+	}
+	l.lastSweep = now
+
+	for key, bucket := range l.buckets {
+		if bucket.tokens+now.Sub(bucket.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package wsocket
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter_Take(t *testing.T) {
+	limiter := NewRateLimiter(2, 2, RateLimitGlobal(), RateLimitDrop)
+	now := time.Now()
+
+	_, ok := limiter.take("key", now)
+	assert.True(t, ok)
+	_, ok = limiter.take("key", now)
+	assert.True(t, ok)
+
+	retryAfter, ok := limiter.take("key", now)
+	assert.False(t, ok)
+	assert.Equal(t, 500*time.Millisecond, retryAfter)
+
+	_, ok = limiter.take("other", now)
+	assert.True(t, ok, "Expected a separate bucket for another key")
+
+	_, ok = limiter.take("key", now.Add(500*time.Millisecond))
+	assert.True(t, ok, "Expected the bucket to be refilled")
+
+	limiter.take("idle", now)
+	limiter.take("key", now.Add(rateLimitSweepInterval))
+	assert.Len(t, limiter.buckets, 1, "Expected idle buckets to be swept")
+}
+
+func TestRateLimiter_Middleware(t *testing.T) {
+	tests := []struct {
+		name          string
+		scope         RateLimitScope
+		action        RateLimitAction
+		expectedReply bool
+		expectedClose int
+	}{
+		{
+			name:   "drop",
+			scope:  RateLimitPerConnection(),
+			action: RateLimitDrop,
+		},
+		{
+			name:          "reply",
+			scope:         RateLimitPerConnection(),
+			action:        RateLimitReply,
+			expectedReply: true,
+		},
+		{
+			name:          "close",
+			scope:         RateLimitPerConnection(),
+			action:        RateLimitClose,
+			expectedClose: websocket.ClosePolicyViolation,
+		},
+		{
+			name: "per user",
+			scope: RateLimitPerUser(func(ctx context.Context) string {
+				return "alice"
+			}),
+			action: RateLimitDrop,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := NewRateLimiter(0, 1, tt.scope, tt.action)
+			middleware := limiter.Middleware()
+
+			conn := newTestConnection()
+			ctx := withConnection(context.Background(), conn)
+
+			_, msg, err := middleware(ctx, []byte("first"))
+			assert.NoError(t, err)
+			assert.Equal(t, []byte("first"), msg)
+
+			_, msg, err = middleware(ctx, []byte("second"))
+			assert.ErrorIs(t, err, ErrRateLimited)
+			assert.Nil(t, msg)
+
+			if tt.expectedReply {
+				messages := conn.Messages()
+				assert.Len(t, messages, 1)
+				var reply ErrorReply
+				assert.NoError(t, json.Unmarshal([]byte(messages[0]), &reply))
+				assert.Equal(t, ErrorCodeRateLimited, reply.Code)
+			} else {
+				assert.Empty(t, conn.Messages())
+			}
+			assert.Equal(t, tt.expectedClose, conn.CloseCode())
+
+			assert.Equal(t, RateLimiterStats{Allowed: 1, Throttled: 1}, limiter.Stats())
+		})
+	}
+}
+
+func TestWithRateLimit(t *testing.T) {
+	limiter := NewRateLimiter(0, 1, RateLimitGlobal(), RateLimitReply)
+
+	handled := 0
+	resolver := NewJSONResolver("type").
+		AddHandler("limited", func(ctx context.Context, msg []byte, rw ResponseWriter) error {
+			handled++
+			return nil
+		}, WithRateLimit(limiter)).
+		AddHandler("free", func(ctx context.Context, msg []byte, rw ResponseWriter) error {
+			handled++
+			return nil
+		})
+
+	msg := []byte(`{"type":"limited"}`)
+	assert.NoError(t, resolver.Handle(context.Background(), msg, &testResponseWriter{}))
+
+	rw := &testResponseWriter{}
+	assert.ErrorIs(t, resolver.Handle(context.Background(), msg, rw), ErrRateLimited)
+	var reply ErrorReply
+	assert.NoError(t, json.Unmarshal(rw.msg.Message, &reply))
+	assert.Equal(t, ErrorCodeRateLimited, reply.Code)
+	assert.Equal(t, "limited", reply.Route)
+
+	assert.NoError(t, resolver.Handle(context.Background(), []byte(`{"type":"free"}`), &testResponseWriter{}))
+	assert.Equal(t, 2, handled)
+}
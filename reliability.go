@@ -0,0 +1,360 @@
+package wsocket
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/valyala/fastjson"
+)
+
+// Reliable delivery message types.
+const (
+	// ReliableMessageType is the type of the envelope carrying a message with its sequence number,
+	// e.g. {"type": "reliable", "seq": 12, "data": {"type": "price", "price": 1.2}}.
+	ReliableMessageType = "reliable"
+	// AckMessageType is the type of the message acknowledging all messages up to a sequence number, e.g. {"type": "ack", "seq": 12}.
+	AckMessageType = "ack"
+	// ResumeMessageType is the type of the message asking to replay the messages after a sequence number, e.g. {"type": "resume", "seq": 12}.
+	// A receiver that hasn't received any message sends 0.
+	ResumeMessageType = "resume"
+)
+
+// ErrorCodeReplayIncomplete is the code of the ErrorReply sent on resume when some of the missing messages are no longer buffered.
+// Details hold the first and the last sequence number that can't be replayed as "from" and "to".
+const ErrorCodeReplayIncomplete = "replay_incomplete"
+
+// ErrNoReliableStream is returned when a message can't be associated with a reliable stream.
+var ErrNoReliableStream = errors.New("no reliable stream")
+
+// Reliability keeps the reliable streams of the receivers.
+//
+// A ReliableStream stamps outgoing messages with sequence numbers and keeps them in a bounded replay buffer until they are acknowledged.
+// When a receiver reconnects, it sends a "resume" message with the last sequence number it has seen and the missing messages are replayed.
+// Streams are identified by the application, e.g. by the Session ID or by the authenticated user and device, so they survive reconnects.
+// Reliable messages must be JSON text messages. Use ReliableReceiver on the receiving side.
+type Reliability struct {
+	mu sync.Mutex
+
+	bufferSize int
+	ttl        time.Duration
+	streamID   func(ctx context.Context) (string, bool)
+
+	streams     map[string]*ReliableStream
+	connections map[Connection]*ReliableStream
+}
+
+// NewReliability creates a new Reliability instance.
+// bufferSize is the maximum number of unacknowledged messages kept by a stream, the oldest ones are dropped when it is exceeded.
+// ttl is how long a stream without a connection is kept, 0 keeps it until Remove is called.
+// streamID returns the ID of the stream of the connection sending a "resume" message.
+// If bufferSize is less than 1, NewReliability panics.
+func NewReliability(bufferSize int, ttl time.Duration, streamID func(ctx context.Context) (string, bool)) *Reliability {
+	if bufferSize < 1 {
+		panic(fmt.Sprintf("wsocket: invalid replay buffer size: %d", bufferSize))
+	}
+
+	return &Reliability{
+		bufferSize:  bufferSize,
+		ttl:         ttl,
+		streamID:    streamID,
+		streams:     make(map[string]*ReliableStream),
+		connections: make(map[Connection]*ReliableStream),
+	}
+}
+
+// Stream returns the stream with the ID, creating it if it doesn't exist.
+// Messages written to a stream without a connection are buffered until a connection resumes it.
+func (r *Reliability) Stream(id string) *ReliableStream {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stream, ok := r.streams[id]
+	if !ok {
+		stream = &ReliableStream{
+			id:          id,
+			reliability: r,
+			nextSeq:     1,
+			buffer:      make([]reliableEntry, 0, r.bufferSize),
+		}
+		r.streams[id] = stream
+		stream.expireLocked()
+	}
+	return stream
+}
+
+// StreamOf returns the stream the connection is attached to.
+func (r *Reliability) StreamOf(conn Connection) (*ReliableStream, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stream, ok := r.connections[conn]
+	return stream, ok
+}
+
+// Remove removes the stream with the ID and drops its buffered messages.
+func (r *Reliability) Remove(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if stream, ok := r.streams[id]; ok {
+		r.removeLocked(stream)
+	}
+}
+
+func (r *Reliability) removeLocked(stream *ReliableStream) {
+	delete(r.streams, stream.id)
+	stream.mu.Lock()
+	if stream.conn != nil {
+		delete(r.connections, stream.conn)
+	}
+	if stream.expiry != nil {
+		stream.expiry.Stop()
+	}
+	stream.mu.Unlock()
+}
+
+// AddHandlers adds the built-in "resume" and "ack" handlers to jr.
+func (r *Reliability) AddHandlers(jr *JSONResolver) *JSONResolver {
+	return jr.
+		AddHandler(ResumeMessageType, r.handleResume).
+		AddHandler(AckMessageType, r.handleAck)
+}
+
+func (r *Reliability) handleResume(ctx context.Context, msg []byte, _ ResponseWriter) error {
+	conn, ok := ConnectionFromContext(ctx)
+	if !ok {
+		return fmt.Errorf("no connection in context")
+	}
+	id, ok := r.streamID(ctx)
+	if !ok {
+		return ErrNoReliableStream
+	}
+
+	jsonMsg, err := ParseJSONMessage(ctx, msg)
+	if err != nil {
+		return err
+	}
+
+	return r.Stream(id).Attach(conn, jsonMsg.GetUint64("seq"))
+}
+
+func (r *Reliability) handleAck(ctx context.Context, msg []byte, _ ResponseWriter) error {
+	conn, ok := ConnectionFromContext(ctx)
+	if !ok {
+		return fmt.Errorf("no connection in context")
+	}
+	stream, ok := r.StreamOf(conn)
+	if !ok {
+		return ErrNoReliableStream
+	}
+
+	jsonMsg, err := ParseJSONMessage(ctx, msg)
+	if err != nil {
+		return err
+	}
+
+	stream.Ack(jsonMsg.GetUint64("seq"))
+	return nil
+}
+
+// ReliableStream is a sequence of messages delivered to a receiver across reconnects.
+// It implements ResponseWriter, messages written to it are delivered to the connection currently attached.
+type ReliableStream struct {
+	mu sync.Mutex
+	// writeMu serializes the writes to the connection, so the messages are written in the order of their sequence numbers
+	// without holding mu while waiting for the write queue.
+	writeMu sync.Mutex
+
+	id          string
+	reliability *Reliability
+
+	conn    Connection
+	expiry  *time.Timer
+	nextSeq uint64
+	// buffer holds the unacknowledged messages in the order of their sequence numbers.
+	buffer []reliableEntry
+	// dropped is the highest sequence number dropped from the buffer without an acknowledgement.
+	dropped uint64
+}
+
+type reliableEntry struct {
+	seq uint64
+	msg Message
+}
+
+// ID returns the ID of the stream.
+func (s *ReliableStream) ID() string {
+	return s.id
+}
+
+// WriteMessage stamps the message with the next sequence number, buffers it and writes it to the attached connection.
+// msg must be a JSON text message.
+func (s *ReliableStream) WriteMessage(msg Message) error {
+	if msg.msgType != 0 && msg.msgType != websocket.TextMessage {
+		return fmt.Errorf("reliable messages must be text messages")
+	}
+	if err := fastjson.ValidateBytes(msg.Message); err != nil {
+		return fmt.Errorf("reliable messages must be JSON: %w", err)
+	}
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	s.mu.Lock()
+	entry := reliableEntry{seq: s.nextSeq, msg: reliableEnvelope(s.nextSeq, msg)}
+	s.nextSeq++
+
+	if len(s.buffer) == s.reliability.bufferSize {
+		s.dropped = s.buffer[0].seq
+		s.buffer = append(s.buffer[:0], s.buffer[1:]...)
+	}
+	s.buffer = append(s.buffer, entry)
+	conn := s.conn
+	s.mu.Unlock()
+
+	if conn == nil {
+		return nil
+	}
+	if err := conn.WriteMessage(entry.msg); err != nil && !errors.Is(err, ErrConnectionClosed) {
+		return err
+	}
+	// A message written to a closed connection stays buffered until the stream is resumed.
+	return nil
+}
+
+// Attach attaches conn to the stream and replays the buffered messages after lastSeq.
+// If some of them were dropped from the buffer, an ErrorReply with code ErrorCodeReplayIncomplete is written first.
+// The connection is detached when it is closed.
+func (s *ReliableStream) Attach(conn Connection, lastSeq uint64) error {
+	// The replay is written before the messages written concurrently, so they stay in order.
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	s.reliability.mu.Lock()
+	s.mu.Lock()
+	if s.conn != nil {
+		delete(s.reliability.connections, s.conn)
+	}
+	s.reliability.connections[conn] = s
+	s.conn = conn
+	if s.expiry != nil {
+		s.expiry.Stop()
+		s.expiry = nil
+	}
+	s.reliability.mu.Unlock()
+
+	s.ackLocked(lastSeq)
+	dropped := s.dropped
+	replay := append([]reliableEntry(nil), s.buffer...)
+	s.mu.Unlock()
+
+	go s.detachOnClose(conn)
+
+	if lastSeq < dropped {
+		details := map[string]uint64{"from": lastSeq + 1, "to": dropped}
+		if err := WriteErrorReply(conn, NewErrorReply(ErrorCodeReplayIncomplete, "some messages can't be replayed", "", details)); err != nil {
+			return err
+		}
+	}
+
+	for _, entry := range replay {
+		if err := conn.WriteMessage(entry.msg); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Ack drops the buffered messages up to seq.
+func (s *ReliableStream) Ack(seq uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ackLocked(seq)
+}
+
+func (s *ReliableStream) ackLocked(seq uint64) {
+	i := 0
+	for i < len(s.buffer) && s.buffer[i].seq <= seq {
+		i++
+	}
+	s.buffer = append(s.buffer[:0], s.buffer[i:]...)
+}
+
+// Pending returns the number of unacknowledged messages.
+func (s *ReliableStream) Pending() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.buffer)
+}
+
+func (s *ReliableStream) detachOnClose(conn Connection) {
+	<-conn.Wait()
+
+	s.reliability.mu.Lock()
+	defer s.reliability.mu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn != conn {
+		return
+	}
+	delete(s.reliability.connections, conn)
+	s.conn = nil
+	s.expireLocked()
+}
+
+// expireLocked removes the stream after the TTL unless a connection is attached in the meantime.
+// Both the reliability and the stream mutex must be held.
+func (s *ReliableStream) expireLocked() {
+	if s.reliability.ttl <= 0 {
+		return
+	}
+
+	s.expiry = time.AfterFunc(s.reliability.ttl, func() {
+		s.reliability.mu.Lock()
+		defer s.reliability.mu.Unlock()
+
+		s.mu.Lock()
+		expired := s.conn == nil
+		s.mu.Unlock()
+		if expired && s.reliability.streams[s.id] == s {
+			s.reliability.removeLocked(s)
+		}
+	})
+}
+
+// reliableEnvelope wraps the JSON message into a "reliable" envelope with the sequence number.
+func reliableEnvelope(seq uint64, msg Message) Message {
+	var buf bytes.Buffer
+	buf.Grow(len(msg.Message) + 48)
+	buf.WriteString(`{"type":"`)
+	buf.WriteString(ReliableMessageType)
+	buf.WriteString(`","seq":`)
+	buf.WriteString(strconv.FormatUint(seq, 10))
+	buf.WriteString(`,"data":`)
+	buf.Write(msg.Message)
+	buf.WriteByte('}')
+
+	msg.msgType = websocket.TextMessage
+	msg.Message = buf.Bytes()
+	// Every sequence number must be delivered, so reliable messages are never conflated.
+	msg.conflationKey = ""
+	return msg
+}
+
+// reliableControl creates an "ack" or "resume" message.
+func reliableControl(msgType string, seq uint64) Message {
+	msg, _ := json.Marshal(struct {
+		Type string `json:"type"`
+		Seq  uint64 `json:"seq"`
+	}{Type: msgType, Seq: seq})
+	return NewTextMessage(msg)
+}
@@ -0,0 +1,125 @@
+package wsocket
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReliableStream(t *testing.T) {
+	reliability := NewReliability(3, 0, func(ctx context.Context) (string, bool) {
+		return "alice", true
+	})
+	jr := reliability.AddHandlers(NewJSONResolver("type"))
+
+	stream := reliability.Stream("alice")
+	assert.Same(t, stream, reliability.Stream("alice"))
+
+	resume := func(conn *testConnection, seq string) {
+		ctx := withConnection(context.Background(), conn)
+		assert.NoError(t, jr.Handle(ctx, []byte(`{"type":"resume","seq":`+seq+`}`), conn))
+	}
+
+	// Messages are buffered until a connection resumes the stream.
+	assert.NoError(t, stream.WriteMessage(NewTextMessage([]byte(`{"n":1}`))))
+	assert.Error(t, stream.WriteMessage(NewTextMessage([]byte(`not json`))))
+	assert.Error(t, stream.WriteMessage(NewBinaryMessage([]byte(`{}`))))
+
+	conn1 := newTestConnection()
+	resume(conn1, "0")
+	assert.NoError(t, stream.WriteMessage(NewTextMessage([]byte(`{"n":2}`))))
+	assert.Equal(t, []string{
+		`{"type":"reliable","seq":1,"data":{"n":1}}`,
+		`{"type":"reliable","seq":2,"data":{"n":2}}`,
+	}, conn1.Messages())
+
+	ctx := withConnection(context.Background(), conn1)
+	assert.NoError(t, jr.Handle(ctx, []byte(`{"type":"ack","seq":1}`), conn1))
+	assert.Equal(t, 1, stream.Pending())
+
+	// The connection drops, messages written in the meantime are replayed on resume.
+	assert.NoError(t, conn1.Close())
+	assert.Eventually(t, func() bool {
+		_, ok := reliability.StreamOf(conn1)
+		return !ok
+	}, time.Second, 10*time.Millisecond)
+	assert.NoError(t, stream.WriteMessage(NewTextMessage([]byte(`{"n":3}`))))
+
+	conn2 := newTestConnection()
+	resume(conn2, "2")
+	assert.Equal(t, []string{`{"type":"reliable","seq":3,"data":{"n":3}}`}, conn2.Messages())
+
+	// Messages dropped from the full buffer are reported on resume.
+	for i := 0; i < 4; i++ {
+		assert.NoError(t, stream.WriteMessage(NewTextMessage([]byte(`{}`))))
+	}
+	conn3 := newTestConnection()
+	resume(conn3, "3")
+	messages := conn3.Messages()
+	assert.Len(t, messages, 4)
+
+	var reply ErrorReply
+	assert.NoError(t, json.Unmarshal([]byte(messages[0]), &reply))
+	assert.Equal(t, ErrorCodeReplayIncomplete, reply.Code)
+	assert.Equal(t, map[string]interface{}{"from": float64(4), "to": float64(4)}, reply.Details)
+	assert.Equal(t, `{"type":"reliable","seq":5,"data":{}}`, messages[1])
+}
+
+func TestReliability_TTL(t *testing.T) {
+	reliability := NewReliability(10, 50*time.Millisecond, func(ctx context.Context) (string, bool) {
+		return "", false
+	})
+
+	stream := reliability.Stream("alice")
+	conn := newTestConnection()
+	assert.NoError(t, stream.Attach(conn, 0))
+
+	time.Sleep(100 * time.Millisecond)
+	assert.Same(t, stream, reliability.Stream("alice"), "Expected a stream with a connection to be kept")
+
+	assert.NoError(t, conn.Close())
+	assert.Eventually(t, func() bool {
+		return reliability.Stream("alice") != stream
+	}, time.Second, 10*time.Millisecond)
+
+	jr := reliability.AddHandlers(NewJSONResolver("type"))
+	ctx := withConnection(context.Background(), newTestConnection())
+	assert.ErrorIs(t, jr.Handle(ctx, []byte(`{"type":"resume","seq":0}`), &testResponseWriter{}), ErrNoReliableStream)
+}
+
+// blockingTestConnection is a testConnection whose writes wait until release is closed.
+type blockingTestConnection struct {
+	*testConnection
+	release chan struct{}
+}
+
+func (c blockingTestConnection) WriteMessage(msg Message) error {
+	<-c.release
+	return c.testConnection.WriteMessage(msg)
+}
+
+func TestReliableStream_WriteDoesNotBlockAck(t *testing.T) {
+	reliability := NewReliability(10, 0, nil)
+	stream := reliability.Stream("alice")
+
+	conn := blockingTestConnection{testConnection: newTestConnection(), release: make(chan struct{})}
+	assert.NoError(t, stream.Attach(conn, 0))
+	go func() {
+		assert.NoError(t, stream.WriteMessage(NewTextMessage([]byte(`{"n":1}`))))
+	}()
+
+	// Pending and Ack don't wait for the write blocked by the connection.
+	assert.Eventually(t, func() bool {
+		return stream.Pending() == 1
+	}, time.Second, 10*time.Millisecond)
+	stream.Ack(1)
+	assert.Equal(t, 0, stream.Pending())
+
+	close(conn.release)
+	assert.Eventually(t, func() bool {
+		return len(conn.Messages()) == 1
+	}, time.Second, 10*time.Millisecond)
+}
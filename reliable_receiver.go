@@ -0,0 +1,165 @@
+package wsocket
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ReliableReceiver is the receiving side of a ReliableStream, used with a Client dialing the server.
+//
+// Its middleware unwraps the "reliable" envelopes, drops the messages that were already handled and acknowledges the received ones.
+// When the server reports with ErrorCodeReplayIncomplete that some messages can't be replayed, the receiver skips them.
+// After every (re)connect, call Resume to attach the connection to the stream and receive the missing messages.
+type ReliableReceiver struct {
+	mu sync.Mutex
+
+	ackInterval time.Duration
+	ackTimer    *time.Timer
+	conn        Connection
+
+	// lastSeq is the sequence number up to which all messages are received.
+	lastSeq uint64
+	acked   uint64
+	// received holds the sequence numbers above lastSeq received out of order, messages are handled concurrently.
+	received map[uint64]struct{}
+}
+
+// NewReliableReceiver creates a new ReliableReceiver instance.
+// ackInterval is how long received messages wait to be acknowledged together, 0 acknowledges every message immediately.
+func NewReliableReceiver(ackInterval time.Duration) *ReliableReceiver {
+	return &ReliableReceiver{
+		ackInterval: ackInterval,
+		received:    make(map[uint64]struct{}),
+	}
+}
+
+// Resume asks the server to attach conn to the stream and replay the messages after the last one received.
+func (r *ReliableReceiver) Resume(conn Connection) error {
+	r.mu.Lock()
+	r.conn = conn
+	seq := r.lastSeq
+	r.mu.Unlock()
+
+	return conn.WriteMessage(reliableControl(ResumeMessageType, seq))
+}
+
+// LastSeq returns the sequence number up to which all messages are received.
+func (r *ReliableReceiver) LastSeq() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.lastSeq
+}
+
+// Middleware returns a client middleware unwrapping reliable messages.
+// Other messages are passed through, duplicates are dropped with ErrDuplicateMessage.
+// The ErrorReply with code ErrorCodeReplayIncomplete is also passed through after the lost messages are skipped.
+func (r *ReliableReceiver) Middleware() Middleware {
+	return func(ctx context.Context, msg []byte) (context.Context, []byte, error) {
+		jsonMsg, err := ParseJSONMessage(ctx, msg)
+		if err != nil {
+			return ctx, msg, nil
+		}
+		switch jsonMsg.GetString("type") {
+		case ReliableMessageType:
+		case errorReplyType:
+			if jsonMsg.GetString("code") == ErrorCodeReplayIncomplete {
+				conn, _ := ConnectionFromContext(ctx)
+				r.skip(conn, jsonMsg.GetUint64("details", "to"))
+			}
+			return ctx, msg, nil
+		default:
+			return ctx, msg, nil
+		}
+
+		seq := jsonMsg.GetUint64("seq")
+		conn, _ := ConnectionFromContext(ctx)
+		if !r.receive(conn, seq) {
+			return ctx, nil, ErrDuplicateMessage
+		}
+
+		data := jsonMsg.Get("data")
+		if data == nil {
+			return ctx, nil, fmt.Errorf("reliable message %d has no data", seq)
+		}
+		return ctx, data.MarshalTo(nil), nil
+	}
+}
+
+// receive records the sequence number and reports whether it is received for the first time.
+func (r *ReliableReceiver) receive(conn Connection, seq uint64) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if seq <= r.lastSeq {
+		return false
+	}
+	if _, ok := r.received[seq]; ok {
+		return false
+	}
+
+	r.received[seq] = struct{}{}
+	r.advanceLocked(conn)
+
+	return true
+}
+
+// skip treats the messages up to seq as received, they were dropped by the server and can't be replayed.
+func (r *ReliableReceiver) skip(conn Connection, seq uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if seq <= r.lastSeq {
+		return
+	}
+	for received := range r.received {
+		if received <= seq {
+			delete(r.received, received)
+		}
+	}
+	r.lastSeq = seq
+	r.advanceLocked(conn)
+}
+
+// advanceLocked moves lastSeq over the messages received in order and schedules their acknowledgement.
+func (r *ReliableReceiver) advanceLocked(conn Connection) {
+	for {
+		if _, ok := r.received[r.lastSeq+1]; !ok {
+			break
+		}
+		delete(r.received, r.lastSeq+1)
+		r.lastSeq++
+	}
+
+	if conn != nil {
+		r.conn = conn
+	}
+	r.scheduleAckLocked()
+}
+
+func (r *ReliableReceiver) scheduleAckLocked() {
+	if r.ackInterval <= 0 {
+		go r.ack()
+		return
+	}
+	if r.ackTimer == nil {
+		r.ackTimer = time.AfterFunc(r.ackInterval, r.ack)
+	}
+}
+
+// ack acknowledges the messages received since the last acknowledgement.
+func (r *ReliableReceiver) ack() {
+	r.mu.Lock()
+	r.ackTimer = nil
+	conn, seq := r.conn, r.lastSeq
+	if conn == nil || seq <= r.acked {
+		r.mu.Unlock()
+		return
+	}
+	r.acked = seq
+	r.mu.Unlock()
+
+	// If the connection is lost, the messages are acknowledged by the next resume.
+	_ = conn.WriteMessage(reliableControl(AckMessageType, seq))
+}
@@ -0,0 +1,111 @@
+package wsocket
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReliableReceiver_Middleware(t *testing.T) {
+	receiver := NewReliableReceiver(0)
+	middleware := receiver.Middleware()
+
+	conn := newTestConnection()
+	assert.NoError(t, receiver.Resume(conn))
+	assert.Equal(t, []string{`{"type":"resume","seq":0}`}, conn.Messages())
+
+	ctx := withConnection(context.Background(), conn)
+	receive := func(msg string) (string, error) {
+		_, out, err := middleware(ctx, []byte(msg))
+		return string(out), err
+	}
+
+	out, err := receive(`{"type":"other"}`)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"type":"other"}`, out, "Expected other messages to be passed through")
+
+	out, err = receive(`{"type":"reliable","seq":2,"data":{"n":2}}`)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"n":2}`, out)
+	assert.Equal(t, uint64(0), receiver.LastSeq(), "Expected the last sequence number to wait for the missing message")
+
+	out, err = receive(`{"type":"reliable","seq":1,"data":{"n":1}}`)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"n":1}`, out)
+	assert.Equal(t, uint64(2), receiver.LastSeq())
+
+	_, err = receive(`{"type":"reliable","seq":2,"data":{"n":2}}`)
+	assert.ErrorIs(t, err, ErrDuplicateMessage)
+
+	assert.Eventually(t, func() bool {
+		messages := conn.Messages()
+		return messages[len(messages)-1] == `{"type":"ack","seq":2}`
+	}, time.Second, 10*time.Millisecond)
+
+	conn2 := newTestConnection()
+	assert.NoError(t, receiver.Resume(conn2))
+	assert.Equal(t, []string{`{"type":"resume","seq":2}`}, conn2.Messages())
+}
+
+func TestReliableReceiver_AckInterval(t *testing.T) {
+	receiver := NewReliableReceiver(50 * time.Millisecond)
+	middleware := receiver.Middleware()
+
+	conn := newTestConnection()
+	ctx := withConnection(context.Background(), conn)
+	for _, msg := range []string{
+		`{"type":"reliable","seq":1,"data":{}}`,
+		`{"type":"reliable","seq":2,"data":{}}`,
+		`{"type":"reliable","seq":3,"data":{}}`,
+	} {
+		_, _, err := middleware(ctx, []byte(msg))
+		assert.NoError(t, err)
+	}
+
+	assert.Empty(t, conn.Messages())
+	assert.Eventually(t, func() bool {
+		messages := conn.Messages()
+		return len(messages) == 1 && messages[0] == `{"type":"ack","seq":3}`
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestReliableReceiver_ReplayIncomplete(t *testing.T) {
+	receiver := NewReliableReceiver(0)
+	middleware := receiver.Middleware()
+
+	conn := newTestConnection()
+	ctx := withConnection(context.Background(), conn)
+	receive := func(msg string) (string, error) {
+		_, out, err := middleware(ctx, []byte(msg))
+		return string(out), err
+	}
+
+	_, err := receive(`{"type":"reliable","seq":1,"data":{}}`)
+	assert.NoError(t, err)
+	_, err = receive(`{"type":"reliable","seq":4,"data":{}}`)
+	assert.NoError(t, err)
+	_, err = receive(`{"type":"reliable","seq":7,"data":{}}`)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), receiver.LastSeq())
+
+	// Messages 2-5 were dropped from the server buffer, 6 is replayed.
+	reply := `{"type":"error","code":"replay_incomplete","message":"some messages can't be replayed","details":{"from":2,"to":5}}`
+	out, err := receive(reply)
+	assert.NoError(t, err)
+	assert.Equal(t, reply, out, "Expected the error reply to be passed through")
+	assert.Equal(t, uint64(5), receiver.LastSeq(), "Expected the dropped messages to be skipped")
+
+	_, err = receive(`{"type":"reliable","seq":4,"data":{}}`)
+	assert.ErrorIs(t, err, ErrDuplicateMessage)
+	_, err = receive(`{"type":"reliable","seq":6,"data":{}}`)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(7), receiver.LastSeq())
+	assert.Empty(t, receiver.received)
+
+	assert.Eventually(t, func() bool {
+		messages := conn.Messages()
+		return len(messages) > 0 && messages[len(messages)-1] == `{"type":"ack","seq":7}`
+	}, time.Second, 10*time.Millisecond)
+}
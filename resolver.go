@@ -3,6 +3,7 @@ package wsocket
 import (
 	"context"
 	"fmt"
+	"strconv"
 	"strings"
 	"sync"
 
@@ -21,44 +22,286 @@ type Handler func(ctx context.Context, msg []byte, rw ResponseWriter) error
 type JSONResolver struct {
 	mu sync.RWMutex
 
-	field    string
+	fields   []jsonField
 	handlers map[string]Handler
+	patterns *routeTrie
+	routes   map[string]RouteInfo
+}
+
+type jsonField struct {
+	name     string
+	path     []string
+	presence bool
+}
+
+type routeParamsContextKey struct{}
+
+// messageTypeContextKey holds the message type resolved by JSONResolver, e.g. "order.created" for the route "order.{action}".
+type messageTypeContextKey struct{}
+
+func messageTypeFromContext(ctx context.Context) (string, bool) {
+	msgType, ok := ctx.Value(messageTypeContextKey{}).(string)
+	return msgType, ok
+}
+
+// CompositeKeySeparator separates field values in the message type of a JSONResolver with several fields.
+const CompositeKeySeparator = "/"
+
+var (
+	compositeKeyEscaper   = strings.NewReplacer("%", "%25", CompositeKeySeparator, "%2F", ".", "%2E")
+	compositeKeyUnescaper = strings.NewReplacer("%2F", CompositeKeySeparator, "%2E", ".", "%25", "%")
+)
+
+// CompositeKey joins values with CompositeKeySeparator, escaping '%' as "%25", the separator as "%2F" and '.' as "%2E" in every value,
+// so values containing the separator don't collide: "a/b" and "c" are joined to "a%2Fb/c", "a" and "b/c" to "a/b%2Fc".
+// '.' is escaped because route patterns split segments on it too, so "{channel}/update" matches "prices.EURUSD" and "update".
+// It builds the message type of a JSONResolver with several fields.
+func CompositeKey(values ...string) string {
+	escaped := make([]string, 0, len(values))
+	for _, value := range values {
+		escaped = append(escaped, compositeKeyEscaper.Replace(value))
+	}
+	return strings.Join(escaped, CompositeKeySeparator)
+}
+
+// splitCompositeKey splits a key built by CompositeKey into at most n unescaped values.
+func splitCompositeKey(key string, n int) []string {
+	values := strings.SplitN(key, CompositeKeySeparator, n)
+	for i, value := range values {
+		values[i] = compositeKeyUnescaper.Replace(value)
+	}
+	return values
 }
 
 // NewJSONResolver creates a new JSONResolver instance.
 // field is the name of the field in the JSON message that is used to resolve the handler.
 // For example, if field is "type", the message {"type": "sum-request", "a": 1, "b": 2} is resolved to the handler registered for "sum-request".
 // If the field is nested, use dot notation, e.g. "type.name".
-func NewJSONResolver(field string) *JSONResolver {
-	return &JSONResolver{
-		field:    field,
+// Strings, numbers and booleans are supported, e.g. {"version": 2} and {"ok": true} are resolved by "2" and "true".
+//
+// If more fields are given, the message type is the values of all fields joined by CompositeKey.
+// For example, with fields "channel" and "event", the message {"channel": "prices", "event": "update"} is resolved to "prices/update",
+// and {"channel": "a/b", "event": "c"} to "a%2Fb/c". Use CompositeKey to build the names of routes with such values,
+// e.g. CompositeKey("prices.EURUSD", "update") for "prices%2EEURUSD/update". Parameters captured by "*" and "{param}" are unescaped, the "**" remainder is not.
+// A field prefixed with "?" checks the presence of the field instead, its value is "present" or "absent".
+// For example, with fields "type" and "?id", the message {"type": "ping", "id": 1} is resolved to "ping/present".
+func NewJSONResolver(field string, fields ...string) *JSONResolver {
+	r := &JSONResolver{
 		handlers: make(map[string]Handler),
+		patterns: newRouteTrie(),
+		routes:   make(map[string]RouteInfo),
 	}
+	for _, f := range append([]string{field}, fields...) {
+		jf := jsonField{name: f}
+		if strings.HasPrefix(f, "?") {
+			jf.presence = true
+			jf.name = f[1:]
+		}
+		jf.path = strings.Split(jf.name, ".")
+		r.fields = append(r.fields, jf)
+	}
+
+	return r
 }
 
 // AddHandler adds a handler for a message type.
 // name is the value of the field that is used to resolve the handler.
-func (r *JSONResolver) AddHandler(name string, handler Handler) *JSONResolver {
+//
+// name can also be a pattern with non-empty segments separated by '.' or '/', separators are matched literally:
+// "*" matches any single segment, "{param}" matches any single segment and captures it,
+// and a trailing "**" matches one or more remaining segments.
+// For example, "order.{action}" matches "order.created" with the "action" parameter set to "created".
+// Captured parameters are available to the handler via RouteParamsFromContext, the "**" remainder is stored under the "**" key.
+// Exact names take precedence over patterns, literal segments over "*" and "{param}", and those over "**".
+// Adding a name or pattern again replaces its handler.
+// AddHandler panics if the pattern is invalid or conflicts with another pattern added before, e.g. "order.{id}" after "order.{action}".
+//
+// opts configure the route, e.g. WithSchema validates messages before they reach the handler.
+func (r *JSONResolver) AddHandler(name string, handler Handler, opts ...RouteOption) *JSONResolver {
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	r.handlers[name] = handler
+
+	config := newRouteConfig(opts)
+	handler = config.wrap(name, handler)
+
+	if isRoutePattern(name) {
+		if _, ok := r.routes[name]; ok {
+			r.patterns.remove(name)
+		}
+		if err := r.patterns.add(name, handler); err != nil {
+			panic(fmt.Sprintf("wsocket: %v", err))
+		}
+	} else {
+		r.handlers[name] = handler
+	}
+	r.routes[name] = RouteInfo{
+		Name:     name,
+		Schema:   config.schemaSource,
+		Request:  config.request,
+		Response: config.response,
+		Resolver: config.resolver,
+	}
 
 	return r
 }
 
+// AddResolver adds resolver as the handler of a message type, e.g. a JSONResolver resolving the messages by a nested field.
+// Unlike passing resolver.Handle to AddHandler, the routes of a nested JSONResolver are described by AsyncAPI.
+// name and opts are handled as by AddHandler.
+func (r *JSONResolver) AddResolver(name string, resolver Resolver, opts ...RouteOption) *JSONResolver {
+	withResolver := func(c *routeConfig) {
+		c.resolver = resolver
+	}
+	return r.AddHandler(name, resolver.Handle, append(opts[:len(opts):len(opts)], withResolver)...)
+}
+
+// Fields returns the fields used to resolve the handler as passed to NewJSONResolver.
+func (r *JSONResolver) Fields() []string {
+	fields := make([]string, 0, len(r.fields))
+	for _, f := range r.fields {
+		if f.presence {
+			fields = append(fields, "?"+f.name)
+		} else {
+			fields = append(fields, f.name)
+		}
+	}
+	return fields
+}
+
+// Routes returns the routes registered with AddHandler sorted by name.
+func (r *JSONResolver) Routes() []RouteInfo {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	routes := make([]RouteInfo, 0, len(r.routes))
+	for _, route := range r.routes {
+		routes = append(routes, route)
+	}
+	sortRoutes(routes)
+
+	return routes
+}
+
 func (r *JSONResolver) Handle(ctx context.Context, msg []byte, rw ResponseWriter) error {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
-	fieldValue := fastjson.GetString(msg, strings.Split(r.field, ".")...)
-	if fieldValue == "" {
-		return fmt.Errorf("failed to get field %q from message", r.field)
+	fieldValue, err := r.messageType(ctx, msg)
+	if err != nil {
+		return err
 	}
 
+	ctx = context.WithValue(ctx, messageTypeContextKey{}, fieldValue)
+
 	handler, ok := r.handlers[fieldValue]
-	if !ok {
+	if ok {
+		return handler(ctx, msg, rw)
+	}
+
+	handler, params := r.patterns.match(fieldValue)
+	if handler == nil {
 		return fmt.Errorf("unknown message type '%q'", fieldValue)
 	}
+	if len(r.fields) > 1 {
+		for name, value := range params {
+			if name != catchAllParam {
+				params[name] = compositeKeyUnescaper.Replace(value)
+			}
+		}
+	}
+
+	return handler(withRouteParams(ctx, params), msg, rw)
+}
+
+func (r *JSONResolver) messageType(ctx context.Context, msg []byte) (string, error) {
+	m, err := ParseJSONMessage(ctx, msg)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse message: %w", err)
+	}
+
+	values := make([]string, 0, len(r.fields))
+	for _, f := range r.fields {
+		fv := m.Get(f.path...)
+		if f.presence {
+			if fv != nil && fv.Type() != fastjson.TypeNull {
+				values = append(values, "present")
+			} else {
+				values = append(values, "absent")
+			}
+			continue
+		}
+
+		value, ok := jsonValueString(fv)
+		if !ok || value == "" {
+			return "", fmt.Errorf("failed to get field %q from message", f.name)
+		}
+		values = append(values, value)
+	}
+
+	if len(values) == 1 {
+		return values[0], nil
+	}
+	return CompositeKey(values...), nil
+}
+
+// jsonValueString converts a JSON discriminator value to the name used to look up a handler.
+func jsonValueString(v *fastjson.Value) (string, bool) {
+	if v == nil {
+		return "", false
+	}
+	switch v.Type() {
+	case fastjson.TypeString:
+		return string(v.GetStringBytes()), true
+	case fastjson.TypeNumber, fastjson.TypeTrue, fastjson.TypeFalse:
+		return v.String(), true
+	default:
+		return "", false
+	}
+}
 
-	return handler(ctx, msg, rw)
+// RouteParamsFromContext returns the parameters captured by a JSONResolver pattern route.
+// Parameters captured by nested resolvers are merged, inner values override outer ones.
+func RouteParamsFromContext(ctx context.Context) map[string]string {
+	params, _ := ctx.Value(routeParamsContextKey{}).(map[string]string)
+	return params
+}
+
+func withRouteParams(ctx context.Context, params map[string]string) context.Context {
+	if len(params) == 0 {
+		return ctx
+	}
+	for k, v := range RouteParamsFromContext(ctx) {
+		if _, ok := params[k]; !ok {
+			params[k] = v
+		}
+	}
+	return context.WithValue(ctx, routeParamsContextKey{}, params)
+}
+
+// discriminatorString converts a decoded discriminator value to the name used to look up a handler.
+func discriminatorString(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case []byte:
+		return string(v), true
+	case int8:
+		return strconv.FormatInt(int64(v), 10), true
+	case int16:
+		return strconv.FormatInt(int64(v), 10), true
+	case int32:
+		return strconv.FormatInt(int64(v), 10), true
+	case int64:
+		return strconv.FormatInt(v, 10), true
+	case uint8:
+		return strconv.FormatUint(uint64(v), 10), true
+	case uint16:
+		return strconv.FormatUint(uint64(v), 10), true
+	case uint32:
+		return strconv.FormatUint(uint64(v), 10), true
+	case uint64:
+		return strconv.FormatUint(v, 10), true
+	default:
+		return "", false
+	}
 }
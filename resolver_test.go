@@ -3,6 +3,7 @@ package wsocket
 import (
 	"context"
 	"fmt"
+	"sort"
 	"testing"
 
 	"github.com/gorilla/websocket"
@@ -111,3 +112,286 @@ func (rw *testResponseWriter) WriteMessage(msg Message) error {
 func (rw *testResponseWriter) GetWrittenMessage() *Message {
 	return rw.msg
 }
+
+func TestJSONResolver_Handle_Patterns(t *testing.T) {
+	writeRoute := func(name string) Handler {
+		return func(ctx context.Context, msg []byte, rw ResponseWriter) error {
+			params := RouteParamsFromContext(ctx)
+			keys := make([]string, 0, len(params))
+			for k := range params {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			result := name
+			for _, k := range keys {
+				result += fmt.Sprintf(" %s=%s", k, params[k])
+			}
+			return rw.WriteMessage(NewTextMessage([]byte(result)))
+		}
+	}
+
+	resolver := NewJSONResolver("type").
+		AddHandler("order.created", writeRoute("exact")).
+		AddHandler("order.{action}", writeRoute("action")).
+		AddHandler("order.*.item", writeRoute("item")).
+		AddHandler("order.**", writeRoute("order-any")).
+		AddHandler("v2/{resource}/{id}", writeRoute("v2-resource")).
+		AddHandler("v2/**", writeRoute("v2-any")).
+		AddHandler("event", NewJSONResolver("data.type").
+			AddHandler("user.{action}", writeRoute("event-user")).
+			Handle,
+		).
+		AddHandler("{scope}.event", NewJSONResolver("data.type").
+			AddHandler("user.{action}", writeRoute("scoped-event-user")).
+			Handle,
+		)
+
+	tests := []struct {
+		name           string
+		inputMessage   string
+		expectedResult string
+		expectedError  bool
+	}{
+		{
+			name:           "Exact Beats Pattern",
+			inputMessage:   `{"type": "order.created"}`,
+			expectedResult: "exact",
+		},
+		{
+			name:           "Named Capture",
+			inputMessage:   `{"type": "order.cancelled"}`,
+			expectedResult: "action action=cancelled",
+		},
+		{
+			name:           "Literal Beats Catch All",
+			inputMessage:   `{"type": "order.42.item"}`,
+			expectedResult: "item",
+		},
+		{
+			name:           "Catch All",
+			inputMessage:   `{"type": "order.42.item.removed"}`,
+			expectedResult: "order-any **=42.item.removed",
+		},
+		{
+			name:           "Slash Separated Captures",
+			inputMessage:   `{"type": "v2/users/7"}`,
+			expectedResult: "v2-resource id=7 resource=users",
+		},
+		{
+			name:           "Slash Separated Catch All",
+			inputMessage:   `{"type": "v2/users/7/avatar"}`,
+			expectedResult: "v2-any **=users/7/avatar",
+		},
+		{
+			name:           "Nested Resolver",
+			inputMessage:   `{"type": "event", "data": {"type": "user.joined"}}`,
+			expectedResult: "event-user action=joined",
+		},
+		{
+			name:           "Nested Resolver Merges Params",
+			inputMessage:   `{"type": "admin.event", "data": {"type": "user.left"}}`,
+			expectedResult: "scoped-event-user action=left scope=admin",
+		},
+		{
+			name:          "Catch All Requires A Segment",
+			inputMessage:  `{"type": "v2"}`,
+			expectedError: true,
+		},
+		{
+			name:          "No Match",
+			inputMessage:  `{"type": "payment.created"}`,
+			expectedError: true,
+		},
+		{
+			name:          "Separators Are Literal",
+			inputMessage:  `{"type": "order/cancelled"}`,
+			expectedError: true,
+		},
+		{
+			name:          "Empty Segment",
+			inputMessage:  `{"type": "order..cancelled"}`,
+			expectedError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rw := &testResponseWriter{}
+
+			err := resolver.Handle(context.Background(), []byte(test.inputMessage), rw)
+			if test.expectedError {
+				assert.Error(t, err, "Expected an error")
+			} else {
+				assert.NoError(t, err, "Expected no error")
+				message := rw.GetWrittenMessage()
+				assert.NotNilf(t, message, "Expected a message to be written")
+				assert.Equal(t, test.expectedResult, string(message.Message))
+			}
+		})
+	}
+
+	t.Run("Invalid Pattern", func(t *testing.T) {
+		assert.Panics(t, func() { NewJSONResolver("type").AddHandler("order.**.item", writeRoute("invalid")) })
+		assert.Panics(t, func() { NewJSONResolver("type").AddHandler("order.a*", writeRoute("invalid")) })
+		assert.Panics(t, func() { NewJSONResolver("type").AddHandler("order..{action}", writeRoute("invalid")) })
+	})
+
+	t.Run("Conflicting Pattern", func(t *testing.T) {
+		for _, pattern := range []string{"order.{id}", "order.*"} {
+			resolver := NewJSONResolver("type").AddHandler("order.{action}", writeRoute("action"))
+			assert.Panics(t, func() { resolver.AddHandler(pattern, writeRoute("conflict")) }, pattern)
+			assert.Equal(t, []RouteInfo{{Name: "order.{action}"}}, resolver.Routes(), "Expected a conflicting pattern not to be registered")
+		}
+		assert.NotPanics(t, func() {
+			NewJSONResolver("type").AddHandler("order.{action}", writeRoute("action")).AddHandler("order/{action}", writeRoute("slash"))
+		})
+	})
+
+	t.Run("Replaced Route", func(t *testing.T) {
+		resolver := NewJSONResolver("type").
+			AddHandler("order.created", writeRoute("exact")).
+			AddHandler("order.created", writeRoute("exact-replaced")).
+			AddHandler("order.{action}", writeRoute("action")).
+			AddHandler("order.{action}", writeRoute("action-replaced")).
+			AddHandler("order.**", writeRoute("any")).
+			AddHandler("order.**", writeRoute("any-replaced"))
+
+		for msg, expected := range map[string]string{
+			`{"type": "order.created"}`:      "exact-replaced",
+			`{"type": "order.cancelled"}`:    "action-replaced action=cancelled",
+			`{"type": "order.cancelled.by"}`: "any-replaced **=cancelled.by",
+		} {
+			rw := &testResponseWriter{}
+			assert.NoError(t, resolver.Handle(context.Background(), []byte(msg), rw))
+			assert.Equal(t, expected, string(rw.GetWrittenMessage().Message))
+		}
+		assert.Len(t, resolver.Routes(), 3)
+	})
+}
+
+func TestCompositeKey(t *testing.T) {
+	assert.Equal(t, "prices/update", CompositeKey("prices", "update"))
+	assert.Equal(t, "a%2Fb/c", CompositeKey("a/b", "c"))
+	assert.Equal(t, "a/b%2Fc", CompositeKey("a", "b/c"))
+	assert.Equal(t, "100%25/x%252F", CompositeKey("100%", "x%2F"))
+	assert.Equal(t, []string{"100%", "x%2F"}, splitCompositeKey(CompositeKey("100%", "x%2F"), 2))
+	assert.Equal(t, "prices%2EEURUSD/update", CompositeKey("prices.EURUSD", "update"))
+	assert.Equal(t, []string{"prices.EURUSD", "update"}, splitCompositeKey(CompositeKey("prices.EURUSD", "update"), 2))
+}
+
+func TestJSONResolver_Handle_CompositeKeys(t *testing.T) {
+	writeRoute := func(name string) Handler {
+		return func(ctx context.Context, msg []byte, rw ResponseWriter) error {
+			return rw.WriteMessage(NewTextMessage([]byte(name)))
+		}
+	}
+
+	tests := []struct {
+		name           string
+		resolver       *JSONResolver
+		inputMessage   string
+		expectedResult string
+		expectedError  bool
+	}{
+		{
+			name:           "Two Fields",
+			resolver:       NewJSONResolver("channel", "event").AddHandler("prices/update", writeRoute("prices-update")),
+			inputMessage:   `{"channel": "prices", "event": "update"}`,
+			expectedResult: "prices-update",
+		},
+		{
+			name:           "Number And Nested Field",
+			resolver:       NewJSONResolver("version", "meta.type").AddHandler("2/order", writeRoute("v2-order")),
+			inputMessage:   `{"version": 2, "meta": {"type": "order"}}`,
+			expectedResult: "v2-order",
+		},
+		{
+			name:           "Number Discriminator",
+			resolver:       NewJSONResolver("op").AddHandler("10", writeRoute("op-10")),
+			inputMessage:   `{"op": 10}`,
+			expectedResult: "op-10",
+		},
+		{
+			name:           "Boolean Discriminator",
+			resolver:       NewJSONResolver("ok").AddHandler("false", writeRoute("not-ok")),
+			inputMessage:   `{"ok": false}`,
+			expectedResult: "not-ok",
+		},
+		{
+			name:           "Field Present",
+			resolver:       NewJSONResolver("method", "?id").AddHandler("sum/present", writeRoute("request")),
+			inputMessage:   `{"method": "sum", "id": 1}`,
+			expectedResult: "request",
+		},
+		{
+			name:           "Field Absent",
+			resolver:       NewJSONResolver("method", "?id").AddHandler("sum/absent", writeRoute("notification")),
+			inputMessage:   `{"method": "sum", "id": null}`,
+			expectedResult: "notification",
+		},
+		{
+			name:           "Pattern On Composite Key",
+			resolver:       NewJSONResolver("channel", "event").AddHandler("{channel}/update", writeRoute("any-update")),
+			inputMessage:   `{"channel": "trades", "event": "update"}`,
+			expectedResult: "any-update",
+		},
+		{
+			name:           "Escaped Separator",
+			resolver:       NewJSONResolver("channel", "event").AddHandler(CompositeKey("a/b", "c"), writeRoute("a/b-c")).AddHandler(CompositeKey("a", "b/c"), writeRoute("a-b/c")),
+			inputMessage:   `{"channel": "a", "event": "b/c"}`,
+			expectedResult: "a-b/c",
+		},
+		{
+			name: "Unescaped Param",
+			resolver: NewJSONResolver("channel", "event").AddHandler("{channel}/update", func(ctx context.Context, msg []byte, rw ResponseWriter) error {
+				return rw.WriteMessage(NewTextMessage([]byte(RouteParamsFromContext(ctx)["channel"])))
+			}),
+			inputMessage:   `{"channel": "prices/fx%", "event": "update"}`,
+			expectedResult: "prices/fx%",
+		},
+		{
+			name: "Dotted Value",
+			resolver: NewJSONResolver("channel", "event").
+				AddHandler(CompositeKey("prices.EURUSD", "update"), writeRoute("eurusd-update")).
+				AddHandler("{channel}/update", func(ctx context.Context, msg []byte, rw ResponseWriter) error {
+					return rw.WriteMessage(NewTextMessage([]byte(RouteParamsFromContext(ctx)["channel"])))
+				}),
+			inputMessage:   `{"channel": "prices.GBPUSD", "event": "update"}`,
+			expectedResult: "prices.GBPUSD",
+		},
+		{
+			name:           "Dotted Value Exact",
+			resolver:       NewJSONResolver("channel", "event").AddHandler(CompositeKey("prices.EURUSD", "update"), writeRoute("eurusd-update")),
+			inputMessage:   `{"channel": "prices.EURUSD", "event": "update"}`,
+			expectedResult: "eurusd-update",
+		},
+		{
+			name:          "Missing Second Field",
+			resolver:      NewJSONResolver("channel", "event").AddHandler("prices/update", writeRoute("prices-update")),
+			inputMessage:  `{"channel": "prices"}`,
+			expectedError: true,
+		},
+		{
+			name:          "Object Discriminator",
+			resolver:      NewJSONResolver("type").AddHandler("object", writeRoute("object")),
+			inputMessage:  `{"type": {}}`,
+			expectedError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rw := &testResponseWriter{}
+
+			err := test.resolver.Handle(context.Background(), []byte(test.inputMessage), rw)
+			if test.expectedError {
+				assert.Error(t, err, "Expected an error")
+			} else {
+				assert.NoError(t, err, "Expected no error")
+				message := rw.GetWrittenMessage()
+				assert.NotNilf(t, message, "Expected a message to be written")
+				assert.Equal(t, test.expectedResult, string(message.Message))
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package wsocket
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	"github.com/valyala/fastjson"
+)
+
+// RouteOption configures a route registered with JSONResolver.AddHandler.
+type RouteOption func(*routeConfig)
+
+type routeConfig struct {
+	schema       *jsonschema.Schema
+	schemaSource string
+	request      reflect.Type
+	response     reflect.Type
+	resolver     Resolver
+	middlewares  []routeMiddleware
+	// authorizers run before the other middlewares, so denied messages don't reach them.
+	authorizers []routeMiddleware
+}
+
+// routeMiddleware wraps the handler of the route with the given name.
+type routeMiddleware func(name string, next Handler) Handler
+
+// RouteInfo describes a route registered with JSONResolver.AddHandler.
+type RouteInfo struct {
+	// Name is the message type or pattern of the route.
+	Name string
+	// Schema is the JSON Schema of the route. Empty if the route has no schema.
+	Schema string
+	// Request is the Go type of the messages handled by the route. nil if it is not declared.
+	Request reflect.Type
+	// Response is the Go type of the messages written by the route handler. nil if it is not declared.
+	Response reflect.Type
+	// Resolver is the resolver handling the messages of the route if it was added with JSONResolver.AddResolver.
+	Resolver Resolver
+}
+
+// WithSchema validates messages of the route against a JSON Schema (draft 2020-12 unless the schema declares another $schema).
+// Invalid messages are not passed to the handler, an ErrorReply with code ErrorCodeValidationFailed
+// listing the violations is sent instead and a *ValidationError is returned.
+// WithSchema panics if the schema cannot be compiled.
+func WithSchema(schema string) RouteOption {
+	compiler := jsonschema.NewCompiler()
+	compiler.Draft = jsonschema.Draft2020
+	if err := compiler.AddResource("schema.json", strings.NewReader(schema)); err != nil {
+		panic(fmt.Sprintf("wsocket: invalid schema: %v", err))
+	}
+	compiled, err := compiler.Compile("schema.json")
+	if err != nil {
+		panic(fmt.Sprintf("wsocket: invalid schema: %v", err))
+	}
+
+	return func(c *routeConfig) {
+		c.schema = compiled
+		c.schemaSource = schema
+	}
+}
+
+// WithPayload declares the Go types of the messages handled and written by the route, e.g. WithPayload(sumRequest{}, sumResponse{}).
+// The types are only used for documentation, see AsyncAPI. Pass nil if the route has no request or response.
+func WithPayload(request, response interface{}) RouteOption {
+	return func(c *routeConfig) {
+		if request != nil {
+			c.request = reflect.TypeOf(request)
+		}
+		if response != nil {
+			c.response = reflect.TypeOf(response)
+		}
+	}
+}
+
+// SchemaViolation describes a part of a message that doesn't conform to the route schema.
+type SchemaViolation struct {
+	// Path is a JSON pointer to the invalid value, e.g. "/items/0/price".
+	Path string `json:"path"`
+	// Message describes the violation.
+	Message string `json:"message"`
+}
+
+// ValidationError is returned when a message doesn't conform to the route schema.
+type ValidationError struct {
+	Route      string
+	Violations []SchemaViolation
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		messages = append(messages, fmt.Sprintf("%s: %s", v.Path, v.Message))
+	}
+	return fmt.Sprintf("message %q doesn't conform to schema: %s", e.Route, strings.Join(messages, "; "))
+}
+
+func newRouteConfig(opts []RouteOption) *routeConfig {
+	c := &routeConfig{}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// wrap applies the route configuration to the handler.
+// The authorization runs first and the schema validation last, whatever the order of the options.
+// The other route middlewares run in between, in the order the options are given.
+func (c *routeConfig) wrap(name string, handler Handler) Handler {
+	handler = c.wrapSchema(name, handler)
+	for i := len(c.middlewares) - 1; i >= 0; i-- {
+		handler = c.middlewares[i](name, handler)
+	}
+	for i := len(c.authorizers) - 1; i >= 0; i-- {
+		handler = c.authorizers[i](name, handler)
+	}
+	return handler
+}
+
+func (c *routeConfig) wrapSchema(name string, handler Handler) Handler {
+	if c.schema == nil {
+		return handler
+	}
+
+	return func(ctx context.Context, msg []byte, rw ResponseWriter) error {
+		if err := c.validate(ctx, name, msg); err != nil {
+			if replyErr := WriteErrorReply(rw, NewErrorReply(ErrorCodeValidationFailed, "message doesn't conform to schema", name, err.Violations)); replyErr != nil {
+				return fmt.Errorf("failed to write error reply: %w", replyErr)
+			}
+			return err
+		}
+
+		return handler(ctx, msg, rw)
+	}
+}
+
+func (c *routeConfig) validate(ctx context.Context, name string, msg []byte) *ValidationError {
+	jsonMsg, err := ParseJSONMessage(ctx, msg)
+	if err != nil {
+		return &ValidationError{Route: name, Violations: []SchemaViolation{{Path: "", Message: err.Error()}}}
+	}
+
+	err = c.schema.Validate(fastjsonToInterface(jsonMsg.Value()))
+	if err == nil {
+		return nil
+	}
+
+	verr := &ValidationError{Route: name}
+	schemaErr, ok := err.(*jsonschema.ValidationError)
+	if !ok {
+		verr.Violations = append(verr.Violations, SchemaViolation{Message: err.Error()})
+		return verr
+	}
+	verr.Violations = appendSchemaViolations(verr.Violations, schemaErr)
+	return verr
+}
+
+// appendSchemaViolations appends the leaf errors of err, the other errors only summarize their causes.
+func appendSchemaViolations(violations []SchemaViolation, err *jsonschema.ValidationError) []SchemaViolation {
+	if len(err.Causes) == 0 {
+		return append(violations, SchemaViolation{Path: err.InstanceLocation, Message: err.Message})
+	}
+	for _, cause := range err.Causes {
+		violations = appendSchemaViolations(violations, cause)
+	}
+	return violations
+}
+
+// fastjsonToInterface converts a parsed value to the generic representation expected by the schema validator.
+func fastjsonToInterface(v *fastjson.Value) interface{} {
+	switch v.Type() {
+	case fastjson.TypeObject:
+		obj, _ := v.Object()
+		m := make(map[string]interface{}, obj.Len())
+		obj.Visit(func(key []byte, v *fastjson.Value) {
+			m[string(key)] = fastjsonToInterface(v)
+		})
+		return m
+	case fastjson.TypeArray:
+		arr, _ := v.Array()
+		a := make([]interface{}, 0, len(arr))
+		for _, item := range arr {
+			a = append(a, fastjsonToInterface(item))
+		}
+		return a
+	case fastjson.TypeString:
+		return string(v.GetStringBytes())
+	case fastjson.TypeNumber:
+		return json.Number(v.String())
+	case fastjson.TypeTrue:
+		return true
+	case fastjson.TypeFalse:
+		return false
+	default:
+		return nil
+	}
+}
+
+func sortRoutes(routes []RouteInfo) {
+	sort.Slice(routes, func(i, j int) bool {
+		return routes[i].Name < routes[j].Name
+	})
+}
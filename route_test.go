@@ -0,0 +1,85 @@
+package wsocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const sumRequestSchema = `{
+	"$schema": "https://json-schema.org/draft/2020-12/schema",
+	"type": "object",
+	"properties": {
+		"type": {"const": "sum-request"},
+		"a": {"type": "integer"},
+		"b": {"type": "integer", "minimum": 0}
+	},
+	"required": ["a", "b"]
+}`
+
+func TestWithSchema(t *testing.T) {
+	handlerCalls := 0
+	resolver := NewJSONResolver("type").
+		AddHandler("sum-request", func(ctx context.Context, msg []byte, rw ResponseWriter) error {
+			handlerCalls++
+			return rw.WriteMessage(NewTextMessage([]byte("ok")))
+		}, WithSchema(sumRequestSchema))
+
+	t.Run("Valid Message", func(t *testing.T) {
+		rw := &testResponseWriter{}
+		err := resolver.Handle(context.Background(), []byte(`{"type": "sum-request", "a": 1, "b": 2}`), rw)
+		assert.NoError(t, err)
+		assert.Equal(t, "ok", string(rw.GetWrittenMessage().Message))
+		assert.Equal(t, 1, handlerCalls)
+	})
+
+	t.Run("Invalid Message", func(t *testing.T) {
+		rw := &testResponseWriter{}
+		err := resolver.Handle(context.Background(), []byte(`{"type": "sum-request", "a": "1", "b": -2}`), rw)
+
+		var validationErr *ValidationError
+		assert.True(t, errors.As(err, &validationErr))
+		assert.Equal(t, "sum-request", validationErr.Route)
+		assert.ElementsMatch(t, []string{"/a", "/b"}, []string{validationErr.Violations[0].Path, validationErr.Violations[1].Path})
+		assert.Equal(t, 1, handlerCalls, "Expected the handler not to be called")
+
+		reply := ErrorReply{}
+		assert.NoError(t, json.Unmarshal(rw.GetWrittenMessage().Message, &reply))
+		assert.Equal(t, "error", reply.Type)
+		assert.Equal(t, ErrorCodeValidationFailed, reply.Code)
+		assert.Equal(t, "sum-request", reply.Route)
+		assert.Len(t, reply.Details, 2)
+	})
+
+	t.Run("Missing Required Field", func(t *testing.T) {
+		rw := &testResponseWriter{}
+		err := resolver.Handle(context.Background(), []byte(`{"type": "sum-request", "a": 1}`), rw)
+
+		var validationErr *ValidationError
+		assert.True(t, errors.As(err, &validationErr))
+		assert.Len(t, validationErr.Violations, 1)
+		assert.Equal(t, 1, handlerCalls, "Expected the handler not to be called")
+	})
+
+	t.Run("Invalid Schema", func(t *testing.T) {
+		assert.Panics(t, func() { WithSchema(`{"type": 1}`) })
+	})
+}
+
+func TestJSONResolver_Routes(t *testing.T) {
+	handler := func(ctx context.Context, msg []byte, rw ResponseWriter) error { return nil }
+
+	resolver := NewJSONResolver("type").
+		AddHandler("sum-request", handler, WithSchema(sumRequestSchema)).
+		AddHandler("order.{action}", handler).
+		AddHandler("event", handler)
+
+	assert.Equal(t, []RouteInfo{
+		{Name: "event"},
+		{Name: "order.{action}"},
+		{Name: "sum-request", Schema: sumRequestSchema},
+	}, resolver.Routes())
+}
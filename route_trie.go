@@ -0,0 +1,263 @@
+package wsocket
+
+import (
+	"fmt"
+	"strings"
+)
+
+// routeTrie matches message types against route patterns.
+// A pattern consists of segments separated by '.' or '/', empty segments are not allowed.
+// A segment is either a literal, "*" matching any single segment, "{name}" matching any single segment
+// and capturing it as a parameter, or a trailing "**" matching one or more remaining segments.
+// Separators are matched literally, "order.created" doesn't match "order/created".
+// Literal segments take precedence over single segment wildcards, which take precedence over "**".
+type routeTrie struct {
+	root *routeNode
+}
+
+// routeNode is a position in the trie. Its edges are keyed by the separator preceding the next segment,
+// 0 for the first segment.
+type routeNode struct {
+	literals  map[string]*routeNode
+	wildcards map[byte]*routeNode
+	catchAlls map[byte]*routeLeaf
+
+	leaf *routeLeaf
+}
+
+type routeLeaf struct {
+	pattern string
+	params  []string
+	handler Handler
+}
+
+// routeSegment is a segment of a route name or pattern.
+type routeSegment struct {
+	value string
+	// sep is the separator preceding the segment, 0 for the first segment.
+	sep    byte
+	offset int
+}
+
+// literalKey returns the key of the literal edge to the segment.
+func (s routeSegment) literalKey() string {
+	return string(rune(s.sep)) + s.value
+}
+
+const catchAllParam = "**"
+
+func newRouteTrie() *routeTrie {
+	return &routeTrie{root: &routeNode{}}
+}
+
+func isRoutePattern(name string) bool {
+	return strings.ContainsAny(name, "*{")
+}
+
+// splitRoute splits name into segments. It returns an error if name is empty or has an empty segment,
+// e.g. "order..created" or "order.".
+func splitRoute(name string) ([]routeSegment, error) {
+	if name == "" {
+		return nil, fmt.Errorf("empty route")
+	}
+
+	segments := make([]routeSegment, 0)
+	start := 0
+	var sep byte
+	for i := 0; i <= len(name); i++ {
+		if i < len(name) && name[i] != '.' && name[i] != '/' {
+			continue
+		}
+		if i == start {
+			return nil, fmt.Errorf("empty segment in route %q", name)
+		}
+		segments = append(segments, routeSegment{value: name[start:i], sep: sep, offset: start})
+		if i < len(name) {
+			sep = name[i]
+		}
+		start = i + 1
+	}
+	return segments, nil
+}
+
+// isParamSegment reports whether the segment is a "{name}" parameter.
+func isParamSegment(value string) bool {
+	return strings.HasPrefix(value, "{") && strings.HasSuffix(value, "}") && len(value) > 2
+}
+
+// add adds the pattern. It returns an error if the pattern is invalid or conflicts with a pattern added before,
+// e.g. "order.{id}" or "order.*" after "order.{action}".
+func (t *routeTrie) add(pattern string, handler Handler) error {
+	segments, err := splitRoute(pattern)
+	if err != nil {
+		return fmt.Errorf("invalid route pattern: %w", err)
+	}
+
+	node := t.root
+	params := make([]string, 0)
+	for i, segment := range segments {
+		switch {
+		case segment.value == catchAllParam:
+			if i != len(segments)-1 {
+				return fmt.Errorf("%q must be the last segment of route pattern %q", catchAllParam, pattern)
+			}
+			if existing, ok := node.catchAlls[segment.sep]; ok {
+				return fmt.Errorf("route pattern %q conflicts with %q", pattern, existing.pattern)
+			}
+			if node.catchAlls == nil {
+				node.catchAlls = make(map[byte]*routeLeaf)
+			}
+			node.catchAlls[segment.sep] = &routeLeaf{pattern: pattern, params: params, handler: handler}
+			return nil
+		case segment.value == "*":
+			params = append(params, "")
+		case isParamSegment(segment.value):
+			params = append(params, segment.value[1:len(segment.value)-1])
+		case strings.ContainsAny(segment.value, "*{}"):
+			return fmt.Errorf("invalid segment %q in route pattern %q", segment.value, pattern)
+		default:
+			if node.literals == nil {
+				node.literals = make(map[string]*routeNode)
+			}
+			next, ok := node.literals[segment.literalKey()]
+			if !ok {
+				next = &routeNode{}
+				node.literals[segment.literalKey()] = next
+			}
+			node = next
+			continue
+		}
+
+		if node.wildcards == nil {
+			node.wildcards = make(map[byte]*routeNode)
+		}
+		next, ok := node.wildcards[segment.sep]
+		if !ok {
+			next = &routeNode{}
+			node.wildcards[segment.sep] = next
+		}
+		node = next
+	}
+
+	if node.leaf != nil {
+		return fmt.Errorf("route pattern %q conflicts with %q", pattern, node.leaf.pattern)
+	}
+	node.leaf = &routeLeaf{pattern: pattern, params: params, handler: handler}
+	return nil
+}
+
+// match returns the handler for name and the captured parameters.
+// If no pattern matches, nil is returned.
+func (t *routeTrie) match(name string) (Handler, map[string]string) {
+	segments, err := splitRoute(name)
+	if err != nil {
+		return nil, nil
+	}
+
+	leaf, values, rest := t.root.match(segments, make([]string, 0, len(segments)))
+	if leaf == nil {
+		return nil, nil
+	}
+
+	params := make(map[string]string, len(leaf.params)+1)
+	for i, param := range leaf.params {
+		if param != "" {
+			params[param] = values[i]
+		}
+	}
+	if rest > 0 {
+		params[catchAllParam] = name[segments[len(segments)-rest].offset:]
+	}
+
+	return leaf.handler, params
+}
+
+// match returns the matched leaf, the values of single segment wildcards
+// and the number of segments matched by a trailing "**".
+func (n *routeNode) match(segments []routeSegment, values []string) (*routeLeaf, []string, int) {
+	if len(segments) == 0 {
+		return n.leaf, values, 0
+	}
+
+	if next, ok := n.literals[segments[0].literalKey()]; ok {
+		if leaf, v, rest := next.match(segments[1:], values); leaf != nil {
+			return leaf, v, rest
+		}
+	}
+
+	if next, ok := n.wildcards[segments[0].sep]; ok {
+		if leaf, v, rest := next.match(segments[1:], append(values, segments[0].value)); leaf != nil {
+			return leaf, v, rest
+		}
+	}
+
+	if leaf, ok := n.catchAlls[segments[0].sep]; ok {
+		return leaf, values, len(segments)
+	}
+
+	return nil, nil, 0
+}
+
+// remove removes the pattern added before and the nodes left without patterns.
+func (t *routeTrie) remove(pattern string) {
+	segments, err := splitRoute(pattern)
+	if err != nil {
+		return
+	}
+	t.root.remove(segments)
+}
+
+// remove removes the pattern made of segments below the node and reports whether the node is left empty.
+func (n *routeNode) remove(segments []routeSegment) bool {
+	if len(segments) == 0 {
+		n.leaf = nil
+		return n.empty()
+	}
+
+	segment := segments[0]
+	switch {
+	case segment.value == catchAllParam && len(segments) == 1:
+		delete(n.catchAlls, segment.sep)
+	case segment.value == "*" || isParamSegment(segment.value):
+		if next, ok := n.wildcards[segment.sep]; ok && next.remove(segments[1:]) {
+			delete(n.wildcards, segment.sep)
+		}
+	default:
+		if next, ok := n.literals[segment.literalKey()]; ok && next.remove(segments[1:]) {
+			delete(n.literals, segment.literalKey())
+		}
+	}
+	return n.empty()
+}
+
+func (n *routeNode) empty() bool {
+	return n.leaf == nil && len(n.literals) == 0 && len(n.wildcards) == 0 && len(n.catchAlls) == 0
+}
+
+// matchAll calls visit with every pattern matching name, unlike match, which returns only the most specific one.
+func (t *routeTrie) matchAll(name string, visit func(pattern string)) {
+	segments, err := splitRoute(name)
+	if err != nil {
+		return
+	}
+	t.root.matchAll(segments, visit)
+}
+
+func (n *routeNode) matchAll(segments []routeSegment, visit func(pattern string)) {
+	if len(segments) == 0 {
+		if n.leaf != nil {
+			visit(n.leaf.pattern)
+		}
+		return
+	}
+
+	if next, ok := n.literals[segments[0].literalKey()]; ok {
+		next.matchAll(segments[1:], visit)
+	}
+	if next, ok := n.wildcards[segments[0].sep]; ok {
+		next.matchAll(segments[1:], visit)
+	}
+	if leaf, ok := n.catchAlls[segments[0].sep]; ok {
+		visit(leaf.pattern)
+	}
+}
@@ -0,0 +1,569 @@
+package wsocket
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// SessionMessageType is the type of the message sending the resume token to the peer,
+// e.g. {"type": "session", "id": "4f2a...", "token": "9c1e...", "resumed": false}.
+const SessionMessageType = "session"
+
+// ErrSessionDetached is returned when a message is written to a session without a connection.
+// It wraps ErrConnectionClosed.
+var ErrSessionDetached = fmt.Errorf("session detached: %w", ErrConnectionClosed)
+
+// SessionData is the state of a session kept by a SessionStore.
+type SessionData struct {
+	ID    string
+	Token string
+	// Attributes are the values set by Session.Set.
+	Attributes map[string]string
+	// ExpiresAt is the time the session expires if it is not resumed. It is zero while a connection is attached.
+	ExpiresAt time.Time
+}
+
+// SessionStore stores sessions by their resume token.
+// Implement it to share sessions between nodes, e.g. in Redis.
+type SessionStore interface {
+	// Save saves the session, replacing the session with the same token.
+	Save(ctx context.Context, data SessionData) error
+	// Take returns the session with the token and deletes it, so the token can be used once.
+	// It must be atomic, e.g. GETDEL in Redis, so only one of concurrent resumes with the same token gets the session.
+	// It returns false if the session doesn't exist or is expired.
+	Take(ctx context.Context, token string) (SessionData, bool, error)
+	// Delete deletes the session with the token.
+	Delete(ctx context.Context, token string) error
+}
+
+// MemorySessionStore is a SessionStore keeping sessions in memory.
+type MemorySessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]SessionData
+}
+
+// NewMemorySessionStore creates a new MemorySessionStore instance.
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{sessions: make(map[string]SessionData)}
+}
+
+func (s *MemorySessionStore) Save(_ context.Context, data SessionData) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions[data.Token] = copySessionData(data)
+	return nil
+}
+
+func (s *MemorySessionStore) Take(_ context.Context, token string) (SessionData, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, ok := s.sessions[token]
+	if !ok {
+		return SessionData{}, false, nil
+	}
+	delete(s.sessions, token)
+	if !data.ExpiresAt.IsZero() && time.Now().After(data.ExpiresAt) {
+		return SessionData{}, false, nil
+	}
+	return copySessionData(data), true, nil
+}
+
+func (s *MemorySessionStore) Delete(_ context.Context, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, token)
+	return nil
+}
+
+func copySessionData(data SessionData) SessionData {
+	attributes := make(map[string]string, len(data.Attributes))
+	for key, value := range data.Attributes {
+		attributes[key] = value
+	}
+	data.Attributes = attributes
+	return data
+}
+
+// SessionManager keeps the state of connections across reconnects.
+//
+// Every connection gets a session and a resume token, sent to the peer in a "session" message.
+// When the socket is closed, the session is kept for a grace period. A new socket connecting with the token
+// is attached to the same session, so PubSub subscriptions, presence and attributes of the session survive the reconnect.
+// The token is replaced on every resume.
+//
+// Handlers receive the session instead of the socket connection from ConnectionFromContext, so state attached to it
+// is kept for the whole session. Middlewares added to the client before NewSessionManager is called receive the socket connection.
+type SessionManager struct {
+	mu sync.Mutex
+
+	client Client
+	store  SessionStore
+	grace  time.Duration
+	logger Logger
+
+	// sessions holds the sessions of this node by their ID.
+	sessions map[string]*Session
+	// connections maps the socket connections to their sessions.
+	connections map[Connection]*Session
+}
+
+// NewSessionManager creates a new SessionManager instance and adds its middleware to the client.
+// store keeps the sessions, if nil, a MemorySessionStore is used.
+// grace is how long a session without a connection is kept.
+// logger is used to log store errors. If nil, a default logger is used.
+func NewSessionManager(client Client, store SessionStore, grace time.Duration, logger Logger) *SessionManager {
+	if store == nil {
+		store = NewMemorySessionStore()
+	}
+	if logger == nil {
+		logger = DefaultLogger()
+	}
+
+	m := &SessionManager{
+		client:      client,
+		store:       store,
+		grace:       grace,
+		logger:      logger,
+		sessions:    make(map[string]*Session),
+		connections: make(map[Connection]*Session),
+	}
+	client.AddMiddleware(m.middleware)
+
+	return m
+}
+
+type sessionContextKey struct{}
+
+// SessionFromContext returns the session of the connection the message being handled was received from.
+func SessionFromContext(ctx context.Context) (*Session, bool) {
+	session, ok := ctx.Value(sessionContextKey{}).(*Session)
+	return session, ok
+}
+
+func (m *SessionManager) middleware(ctx context.Context, msg []byte) (context.Context, []byte, error) {
+	conn, ok := ConnectionFromContext(ctx)
+	if !ok {
+		return ctx, msg, nil
+	}
+
+	m.mu.Lock()
+	session, ok := m.connections[conn]
+	m.mu.Unlock()
+	if !ok {
+		return ctx, msg, nil
+	}
+
+	ctx = context.WithValue(ctx, sessionContextKey{}, session)
+	return withConnection(ctx, session), msg, nil
+}
+
+// Connect creates a connection for the socket and attaches it to a session.
+// If token is the resume token of a session that hasn't expired, the connection is attached to it, otherwise a new session is created.
+// The token is usually passed by the peer in a query parameter of the upgrade request.
+// If the session can't be saved or sent to the peer, the connection is closed and a resumed session keeps its previous token.
+func (m *SessionManager) Connect(ctx context.Context, websocketConn *websocket.Conn, token string) (*Session, error) {
+	if websocketConn == nil {
+		return nil, fmt.Errorf("no websocket connection")
+	}
+
+	newToken, err := newRandomID()
+	if err != nil {
+		return nil, err
+	}
+
+	session, resumed, err := m.resolve(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	// The connection is registered before its first message is handled, the middleware waits for the lock.
+	m.mu.Lock()
+	conn := m.client.NewConnection(websocketConn)
+	m.connections[conn] = session
+	previous := session.attach(conn, newToken)
+	m.mu.Unlock()
+
+	if previous != nil {
+		_ = previous.Close()
+	}
+	go session.detachOnClose(conn)
+
+	if err := m.store.Save(ctx, session.data()); err != nil {
+		m.abort(session, conn, token, resumed)
+		return nil, err
+	}
+
+	reply, err := json.Marshal(struct {
+		Type    string `json:"type"`
+		ID      string `json:"id"`
+		Token   string `json:"token"`
+		Resumed bool   `json:"resumed"`
+	}{Type: SessionMessageType, ID: session.id, Token: newToken, Resumed: resumed})
+	if err != nil {
+		m.abort(session, conn, token, resumed)
+		return nil, err
+	}
+	if err := conn.WriteMessage(NewTextMessage(reply).WithPriority(PriorityHigh)); err != nil {
+		m.abort(session, conn, token, resumed)
+		return nil, err
+	}
+
+	return session, nil
+}
+
+// abort undoes the registration of conn when its session can't be sent to the peer and closes it.
+// A new session is ended. A resumed session gets its previous token back, it is saved when the connection is detached,
+// so the peer can retry the resume.
+func (m *SessionManager) abort(session *Session, conn Connection, previousToken string, resumed bool) {
+	m.mu.Lock()
+	delete(m.connections, conn)
+	m.mu.Unlock()
+
+	if resumed {
+		session.mu.Lock()
+		if session.conn == conn {
+			session.token = previousToken
+		}
+		session.mu.Unlock()
+	}
+
+	_ = conn.Close()
+	if !resumed {
+		session.end()
+	}
+}
+
+// resolve returns the session of the token, or a new session.
+// The grace period of a resumed session is stopped, so it doesn't end before the connection is attached.
+func (m *SessionManager) resolve(ctx context.Context, token string) (*Session, bool, error) {
+	if token != "" {
+		data, ok, err := m.store.Take(ctx, token)
+		if err != nil {
+			return nil, false, err
+		}
+		if ok {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+
+			if session, ok := m.sessions[data.ID]; ok && session.claim() {
+				return session, true, nil
+			}
+			// The session was created by another node, before a restart or it has just expired,
+			// only its attributes are restored.
+			session := newSession(m, data.ID, data.Attributes)
+			m.sessions[session.id] = session
+			return session, true, nil
+		}
+	}
+
+	id, err := newRandomID()
+	if err != nil {
+		return nil, false, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	session := newSession(m, id, nil)
+	m.sessions[session.id] = session
+	return session, false, nil
+}
+
+// Session returns the session with the ID if it is kept by this node.
+func (m *SessionManager) Session(id string) (*Session, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	session, ok := m.sessions[id]
+	return session, ok
+}
+
+func (m *SessionManager) remove(session *Session) {
+	m.mu.Lock()
+	if m.sessions[session.id] == session {
+		delete(m.sessions, session.id)
+	}
+	m.mu.Unlock()
+
+	if err := m.store.Delete(context.Background(), session.Token()); err != nil {
+		m.logger.Printf("failed to delete session %s: %v", session.id, err)
+	}
+}
+
+// Session is a connection that survives reconnects. It implements Connection, writes go to the socket connection currently attached.
+// Wait returns a channel that is closed when the session ends: it is closed or its grace period expires without a resume.
+type Session struct {
+	mu sync.Mutex
+
+	manager    *SessionManager
+	id         string
+	token      string
+	attributes map[string]string
+
+	conn   Connection
+	expiry *time.Timer
+	// expiryGen identifies the current grace period, a timer of a stopped one doesn't end the session.
+	expiryGen uint64
+	// expiresAt is the end of the grace period while no connection is attached.
+	expiresAt time.Time
+	// ended is set when the session is about to end, so it isn't resumed anymore.
+	ended      bool
+	closedChan chan struct{}
+	closeOnce  sync.Once
+}
+
+func newSession(manager *SessionManager, id string, attributes map[string]string) *Session {
+	if attributes == nil {
+		attributes = make(map[string]string)
+	}
+
+	return &Session{
+		manager:    manager,
+		id:         id,
+		attributes: attributes,
+		closedChan: make(chan struct{}),
+	}
+}
+
+// ID returns the ID of the session. Unlike the token, it doesn't change on resume.
+func (s *Session) ID() string {
+	return s.id
+}
+
+// Token returns the current resume token of the session.
+func (s *Session) Token() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.token
+}
+
+// Get returns the value of the attribute.
+func (s *Session) Get(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.attributes[key]
+	return value, ok
+}
+
+// Set sets the value of the attribute and saves the session to the store.
+func (s *Session) Set(ctx context.Context, key, value string) error {
+	s.mu.Lock()
+	s.attributes[key] = value
+	data := s.dataLocked()
+	s.mu.Unlock()
+
+	return s.manager.store.Save(ctx, data)
+}
+
+// Connected reports whether a socket connection is attached to the session.
+func (s *Session) Connected() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conn != nil
+}
+
+func (s *Session) WriteMessage(msg Message) error {
+	conn, err := s.current()
+	if err != nil {
+		return err
+	}
+	return conn.WriteMessage(msg)
+}
+
+func (s *Session) tryWriteMessage(msg Message) error {
+	conn, err := s.current()
+	if err != nil {
+		return err
+	}
+	return writeNonBlocking(conn, msg)
+}
+
+func (s *Session) NextWriter(msgType int) (io.WriteCloser, error) {
+	conn, err := s.current()
+	if err != nil {
+		return nil, err
+	}
+	return NextWriter(conn, msgType)
+}
+
+// Close ends the session and closes its connection.
+func (s *Session) Close() error {
+	return s.CloseWithReason(websocket.CloseNormalClosure, "")
+}
+
+// CloseWithReason ends the session and closes its connection with the close code and reason.
+func (s *Session) CloseWithReason(code int, reason string) error {
+	s.mu.Lock()
+	conn := s.conn
+	s.mu.Unlock()
+
+	s.end()
+	if conn != nil {
+		return CloseWithReason(conn, code, reason)
+	}
+	return nil
+}
+
+func (s *Session) Wait() <-chan struct{} {
+	return s.closedChan
+}
+
+func (s *Session) Compression() CompressionState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return CompressionState{}
+	}
+	return ConnectionCompression(s.conn)
+}
+
+// SendWithAck sends the JSON message and waits until the peer acknowledges it.
+// The message is resent to the connection attached at the time of the retry, so it survives reconnects.
+func (s *Session) SendWithAck(ctx context.Context, msg Message) error {
+	conn, err := s.current()
+	if err != nil {
+		return err
+	}
+	provider, ok := conn.(ackDeliveryProvider)
+	if !ok || provider.ackDelivery() == nil {
+		return ErrAckDeliveryDisabled
+	}
+	return provider.ackDelivery().Send(ctx, s, msg)
+}
+
+func (s *Session) current() (Connection, error) {
+	select {
+	case <-s.closedChan:
+		return nil, ErrConnectionClosed
+	default:
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil, ErrSessionDetached
+	}
+	return s.conn, nil
+}
+
+// claim stops the grace period of the session to resume it. It returns false if the session has ended or is ending.
+func (s *Session) claim() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ended {
+		return false
+	}
+	s.stopExpiryLocked()
+	return true
+}
+
+// attach attaches the socket connection to the session and returns the previous one.
+func (s *Session) attach(conn Connection, token string) Connection {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	previous := s.conn
+	s.conn = conn
+	s.token = token
+	s.stopExpiryLocked()
+	return previous
+}
+
+func (s *Session) stopExpiryLocked() {
+	if s.expiry != nil {
+		s.expiry.Stop()
+		s.expiry = nil
+	}
+	s.expiryGen++
+	s.expiresAt = time.Time{}
+}
+
+func (s *Session) detachOnClose(conn Connection) {
+	<-conn.Wait()
+
+	s.manager.mu.Lock()
+	delete(s.manager.connections, conn)
+	s.manager.mu.Unlock()
+
+	s.mu.Lock()
+	if s.conn != conn {
+		s.mu.Unlock()
+		return
+	}
+	s.conn = nil
+
+	select {
+	case <-s.closedChan:
+		s.mu.Unlock()
+		return
+	default:
+	}
+
+	s.expiryGen++
+	gen := s.expiryGen
+	s.expiresAt = time.Now().Add(s.manager.grace)
+	s.expiry = time.AfterFunc(s.manager.grace, func() {
+		s.expire(gen)
+	})
+	data := s.dataLocked()
+	s.mu.Unlock()
+
+	if err := s.manager.store.Save(context.Background(), data); err != nil {
+		s.manager.logger.Printf("failed to save session %s: %v", s.id, err)
+	}
+}
+
+// expire ends the session unless the grace period gen was stopped by a resume.
+func (s *Session) expire(gen uint64) {
+	s.mu.Lock()
+	if s.conn != nil || s.expiryGen != gen || s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.mu.Unlock()
+
+	s.end()
+}
+
+// end ends the session, removing it from the manager and the store.
+func (s *Session) end() {
+	s.closeOnce.Do(func() {
+		s.mu.Lock()
+		s.ended = true
+		if s.expiry != nil {
+			s.expiry.Stop()
+		}
+		s.mu.Unlock()
+
+		s.manager.remove(s)
+		close(s.closedChan)
+	})
+}
+
+func (s *Session) data() SessionData {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dataLocked()
+}
+
+func (s *Session) dataLocked() SessionData {
+	return copySessionData(SessionData{ID: s.id, Token: s.token, Attributes: s.attributes, ExpiresAt: s.expiresAt})
+}
+
+// newRandomID returns a random ID that can't be guessed, used for session IDs and tokens.
+func newRandomID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,281 @@
+package wsocket
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemorySessionStore(t *testing.T) {
+	store := NewMemorySessionStore()
+	ctx := context.Background()
+
+	data := SessionData{ID: "id", Token: "token", Attributes: map[string]string{"user": "alice"}}
+	assert.NoError(t, store.Save(ctx, data))
+
+	data.Attributes["user"] = "bob"
+	taken, ok, err := store.Take(ctx, "token")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "alice", taken.Attributes["user"], "Expected the stored attributes to be copied")
+	assert.Equal(t, "id", taken.ID)
+
+	_, ok, err = store.Take(ctx, "token")
+	assert.NoError(t, err)
+	assert.False(t, ok, "Expected a token to be taken once")
+
+	data.ExpiresAt = time.Now().Add(-time.Second)
+	assert.NoError(t, store.Save(ctx, data))
+	_, ok, err = store.Take(ctx, "token")
+	assert.NoError(t, err)
+	assert.False(t, ok, "Expected an expired session not to be taken")
+
+	assert.NoError(t, store.Save(ctx, SessionData{ID: "id", Token: "other"}))
+	assert.NoError(t, store.Delete(ctx, "other"))
+	_, ok, _ = store.Take(ctx, "other")
+	assert.False(t, ok)
+}
+
+// failingSessionStore is a MemorySessionStore failing the number of saves set in failures.
+type failingSessionStore struct {
+	*MemorySessionStore
+	failures int32
+}
+
+func (s *failingSessionStore) Save(ctx context.Context, data SessionData) error {
+	if atomic.AddInt32(&s.failures, -1) >= 0 {
+		return errors.New("store unavailable")
+	}
+	return s.MemorySessionStore.Save(ctx, data)
+}
+
+type sessionReply struct {
+	Type    string `json:"type"`
+	ID      string `json:"id"`
+	Token   string `json:"token"`
+	Resumed bool   `json:"resumed"`
+}
+
+func TestSessionManager(t *testing.T) {
+	ps := NewPubSub(NoLogger())
+	resolver := ps.AddHandlers(NewJSONResolver("type"))
+	client := NewClient(context.Background(), resolver, NoLogger(), 10)
+	manager := NewSessionManager(client, nil, 200*time.Millisecond, NoLogger())
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := websocket.Upgrader{}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		assert.NoError(t, err)
+
+		_, err = manager.Connect(r.Context(), conn, r.URL.Query().Get("token"))
+		assert.NoError(t, err)
+	}))
+	defer server.Close()
+
+	dial := func(token string) (*websocket.Conn, sessionReply) {
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+server.URL[4:]+"?token="+token, nil)
+		assert.NoError(t, err)
+
+		var reply sessionReply
+		assert.NoError(t, conn.ReadJSON(&reply))
+		assert.Equal(t, SessionMessageType, reply.Type)
+		return conn, reply
+	}
+
+	conn, first := dial("")
+	assert.False(t, first.Resumed)
+	session, ok := manager.Session(first.ID)
+	assert.True(t, ok)
+
+	assert.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"subscribe","topic":"news"}`)))
+	_, _, err := conn.ReadMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, ps.SubscriberCount("news"))
+	assert.NoError(t, session.Set(context.Background(), "user", "alice"))
+
+	// The socket drops, the session and its subscriptions are kept for the grace period.
+	assert.NoError(t, conn.Close())
+	assert.Eventually(t, func() bool { return !session.Connected() }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, 0, ps.Publish("news", NewTextMessage([]byte(`{"type":"lost"}`))))
+	assert.ErrorIs(t, session.WriteMessage(NewTextMessage([]byte(`{}`))), ErrSessionDetached)
+
+	conn, resumed := dial(first.Token)
+	defer conn.Close()
+	assert.True(t, resumed.Resumed)
+	assert.Equal(t, first.ID, resumed.ID)
+	assert.NotEqual(t, first.Token, resumed.Token, "Expected the token to be replaced on resume")
+	user, _ := session.Get("user")
+	assert.Equal(t, "alice", user)
+
+	assert.Equal(t, 1, ps.Publish("news", NewTextMessage([]byte(`{"type":"news"}`))))
+	_, msg, err := conn.ReadMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, `{"type":"news"}`, string(msg))
+
+	// A used token can't be resumed again.
+	conn2, other := dial(first.Token)
+	defer conn2.Close()
+	assert.False(t, other.Resumed)
+	assert.NotEqual(t, first.ID, other.ID)
+
+	// Without a resume, the session ends after the grace period.
+	assert.NoError(t, conn.Close())
+	select {
+	case <-session.Wait():
+	case <-time.After(time.Second):
+		t.Fatal("Expected the session to end")
+	}
+	assert.Eventually(t, func() bool { return ps.SubscriberCount("news") == 0 }, time.Second, 10*time.Millisecond)
+	_, ok = manager.Session(first.ID)
+	assert.False(t, ok)
+
+	conn3, expired := dial(resumed.Token)
+	defer conn3.Close()
+	assert.False(t, expired.Resumed)
+}
+
+func TestSessionManager_ConnectError(t *testing.T) {
+	client := NewClient(context.Background(), NewJSONResolver("type"), NoLogger(), 10)
+	store := &failingSessionStore{MemorySessionStore: NewMemorySessionStore()}
+	manager := NewSessionManager(client, store, time.Second, NoLogger())
+
+	sessions := make(chan *Session, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := websocket.Upgrader{}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		assert.NoError(t, err)
+
+		session, _ := manager.Connect(r.Context(), conn, r.URL.Query().Get("token"))
+		sessions <- session
+	}))
+	defer server.Close()
+
+	dial := func(token string) *websocket.Conn {
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+server.URL[4:]+"?token="+token, nil)
+		assert.NoError(t, err)
+		return conn
+	}
+	expectClosed := func(conn *websocket.Conn) {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				assert.True(t, websocket.IsCloseError(err, websocket.CloseNormalClosure), "Expected the connection to be closed, got %v", err)
+				return
+			}
+		}
+	}
+
+	conn := dial("")
+	var reply sessionReply
+	assert.NoError(t, conn.ReadJSON(&reply))
+	session := <-sessions
+	assert.NoError(t, conn.Close())
+	assert.Eventually(t, func() bool { return !session.Connected() }, time.Second, 10*time.Millisecond)
+
+	// A new session that can't be saved is ended.
+	atomic.StoreInt32(&store.failures, 1)
+	conn2 := dial("")
+	defer conn2.Close()
+	assert.Nil(t, <-sessions)
+	expectClosed(conn2)
+
+	// A resume that can't be saved keeps the previous token, the session is saved with it when the connection is detached.
+	atomic.StoreInt32(&store.failures, 1)
+	conn3 := dial(reply.Token)
+	defer conn3.Close()
+	assert.Nil(t, <-sessions)
+	expectClosed(conn3)
+	assert.Equal(t, reply.Token, session.Token())
+
+	assert.Eventually(t, func() bool {
+		manager.mu.Lock()
+		defer manager.mu.Unlock()
+		return len(manager.connections) == 0 && len(manager.sessions) == 1
+	}, time.Second, 10*time.Millisecond, "Expected the failed connections to be unregistered")
+	assert.Eventually(t, func() bool {
+		store.mu.Lock()
+		defer store.mu.Unlock()
+		_, ok := store.sessions[reply.Token]
+		return ok
+	}, time.Second, 10*time.Millisecond, "Expected the session to be saved with the previous token")
+
+	conn4 := dial(reply.Token)
+	defer conn4.Close()
+	var resumed sessionReply
+	assert.NoError(t, conn4.ReadJSON(&resumed))
+	assert.True(t, resumed.Resumed, "Expected the previous token to resume the session")
+	assert.Equal(t, reply.ID, resumed.ID)
+	<-sessions
+}
+
+func TestSessionManager_ResumeEndingSession(t *testing.T) {
+	client := NewClient(context.Background(), NewJSONResolver("type"), NoLogger(), 10)
+	store := NewMemorySessionStore()
+	manager := NewSessionManager(client, store, time.Hour, NoLogger())
+
+	session := newSession(manager, "id", map[string]string{"user": "alice"})
+	session.token = "token"
+	manager.sessions[session.id] = session
+	assert.NoError(t, store.Save(context.Background(), session.data()))
+
+	// The grace period expired, but the session hasn't ended yet when the token is taken.
+	session.ended = true
+	resolved, resumed, err := manager.resolve(context.Background(), "token")
+	assert.NoError(t, err)
+	assert.True(t, resumed)
+	assert.NotSame(t, session, resolved, "Expected a fresh session instead of the ending one")
+	assert.Equal(t, "id", resolved.ID())
+	user, _ := resolved.Get("user")
+	assert.Equal(t, "alice", user)
+
+	// A resume stops the grace period, its timer doesn't end the session anymore.
+	resolved.mu.Lock()
+	resolved.expiryGen++
+	gen := resolved.expiryGen
+	resolved.mu.Unlock()
+	assert.True(t, resolved.claim())
+	resolved.expire(gen)
+	select {
+	case <-resolved.Wait():
+		t.Fatal("Expected the resumed session not to end")
+	default:
+	}
+}
+
+func TestSession_SetWhileDetached(t *testing.T) {
+	client := NewClient(context.Background(), NewJSONResolver("type"), NoLogger(), 10)
+	store := NewMemorySessionStore()
+	manager := NewSessionManager(client, store, time.Hour, NoLogger())
+
+	session := newSession(manager, "id", nil)
+	session.token = "token"
+	conn := newTestConnection()
+	session.attach(conn, "token")
+	go session.detachOnClose(conn)
+	assert.NoError(t, conn.Close())
+	assert.Eventually(t, func() bool { return !session.Connected() }, time.Second, 10*time.Millisecond)
+
+	assert.NoError(t, session.Set(context.Background(), "user", "alice"))
+	store.mu.Lock()
+	data := store.sessions["token"]
+	store.mu.Unlock()
+	assert.Equal(t, "alice", data.Attributes["user"])
+	assert.WithinDuration(t, time.Now().Add(time.Hour), data.ExpiresAt, time.Minute, "Expected the session to keep its expiry")
+}
+
+func TestSessionFromContext(t *testing.T) {
+	_, ok := SessionFromContext(context.Background())
+	assert.False(t, ok)
+
+	session := &Session{id: "id"}
+	ctx := context.WithValue(context.Background(), sessionContextKey{}, session)
+	actual, ok := SessionFromContext(ctx)
+	assert.True(t, ok)
+	assert.Same(t, session, actual)
+}
@@ -0,0 +1,77 @@
+package wsocket
+
+import (
+	"context"
+	"io"
+
+	"github.com/gorilla/websocket"
+)
+
+// StreamHandler handles a message read as a stream instead of a []byte.
+// r returns websocket.ErrReadLimit if the message exceeds the streaming read limit.
+// r is valid only until the handler returns, the remaining part of the message is discarded.
+type StreamHandler func(ctx context.Context, r io.Reader, rw ResponseWriter) error
+
+// StreamSelector selects the messages that are read as a stream.
+// Streamed messages are handled one at a time by the reading goroutine of the connection, and middlewares aren't run for them.
+type StreamSelector interface {
+	// HeaderSize returns the number of bytes at the beginning of the message passed to Select.
+	HeaderSize() int
+	// Select returns the handler of the message, or nil if the message should be read as a whole and passed to the resolver.
+	// header is shorter than HeaderSize if the message is shorter.
+	Select(msgType int, header []byte) StreamHandler
+}
+
+// StreamByType returns a StreamSelector streaming all messages of the type to the handler.
+// For example, StreamByType(websocket.BinaryMessage, handleUpload) streams binary messages and passes text messages to the resolver.
+func StreamByType(msgType int, handler StreamHandler) StreamSelector {
+	return streamByType{msgType: msgType, handler: handler}
+}
+
+type streamByType struct {
+	msgType int
+	handler StreamHandler
+}
+
+func (s streamByType) HeaderSize() int {
+	return 0
+}
+
+func (s streamByType) Select(msgType int, _ []byte) StreamHandler {
+	if msgType != s.msgType {
+		return nil
+	}
+	return s.handler
+}
+
+// limitedReader returns websocket.ErrReadLimit once more than n bytes are read.
+// Unlike io.LimitedReader it reports the exceeded limit instead of io.EOF.
+type limitedReader struct {
+	r        io.Reader
+	n        int64
+	exceeded bool
+}
+
+func newLimitedReader(r io.Reader, limit int64) *limitedReader {
+	return &limitedReader{r: r, n: limit}
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.n <= 0 {
+		// Read a single byte to distinguish a message of exactly the limit size from a bigger one.
+		var b [1]byte
+		n, err := l.r.Read(b[:])
+		if n > 0 {
+			l.exceeded = true
+			return 0, websocket.ErrReadLimit
+		}
+		return 0, err
+	}
+
+	if int64(len(p)) > l.n {
+		p = p[:l.n]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	return n, err
+}
@@ -0,0 +1,62 @@
+package wsocket
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStreamByType(t *testing.T) {
+	handler := func(ctx context.Context, r io.Reader, rw ResponseWriter) error {
+		return nil
+	}
+	selector := StreamByType(websocket.BinaryMessage, handler)
+
+	assert.Equal(t, 0, selector.HeaderSize())
+	assert.NotNil(t, selector.Select(websocket.BinaryMessage, nil))
+	assert.Nil(t, selector.Select(websocket.TextMessage, nil))
+}
+
+func TestLimitedReader(t *testing.T) {
+	tests := []struct {
+		name             string
+		msg              string
+		limit            int64
+		expectedErr      error
+		expectedExceeded bool
+	}{
+		{
+			name:  "under limit",
+			msg:   "hello",
+			limit: 10,
+		},
+		{
+			name:  "exactly limit",
+			msg:   "hello",
+			limit: 5,
+		},
+		{
+			name:             "over limit",
+			msg:              "hello, world",
+			limit:            5,
+			expectedErr:      websocket.ErrReadLimit,
+			expectedExceeded: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newLimitedReader(strings.NewReader(tt.msg), tt.limit)
+			msg, err := io.ReadAll(r)
+			assert.Equal(t, tt.expectedErr, err)
+			assert.Equal(t, tt.expectedExceeded, r.exceeded)
+			if tt.expectedErr == nil {
+				assert.Equal(t, tt.msg, string(msg))
+			}
+		})
+	}
+}
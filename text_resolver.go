@@ -0,0 +1,207 @@
+package wsocket
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// TextResolver resolves line-oriented text commands such as "SUBSCRIBE prices EURUSD".
+// Commands are matched by the first token, by prefix or by a regular expression, in that order.
+// The arguments of the matched command are available to the handler via TextArgsFromContext.
+type TextResolver struct {
+	mu sync.RWMutex
+
+	caseInsensitive bool
+	helpReply       bool
+
+	commands       map[string]Handler
+	usages         map[string]string
+	prefixHandlers []textPrefixHandler
+	regexpHandlers []textRegexpHandler
+}
+
+type textPrefixHandler struct {
+	prefix  string
+	handler Handler
+}
+
+type textRegexpHandler struct {
+	re      *regexp.Regexp
+	handler Handler
+}
+
+type textArgsContextKey struct{}
+
+// NewTextResolver creates a new TextResolver instance.
+// If caseInsensitive is true, commands and prefixes are matched regardless of case.
+// Regular expressions are not affected, use the (?i) flag instead.
+func NewTextResolver(caseInsensitive bool) *TextResolver {
+	return &TextResolver{
+		caseInsensitive: caseInsensitive,
+		commands:        make(map[string]Handler),
+		usages:          make(map[string]string),
+	}
+}
+
+// AddHandler adds a handler for a command.
+// command is matched against the first whitespace separated token of the message.
+// The remaining tokens are passed to the handler as arguments.
+func (r *TextResolver) AddHandler(command string, handler Handler) *TextResolver {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := r.normalize(command)
+	r.commands[key] = handler
+	if _, ok := r.usages[key]; !ok {
+		r.usages[key] = command
+	}
+
+	return r
+}
+
+// AddPrefixHandler adds a handler for messages starting with prefix.
+// The rest of the message is split by whitespace and passed to the handler as arguments.
+// If several prefixes match, the longest one is used.
+func (r *TextResolver) AddPrefixHandler(prefix string, handler Handler) *TextResolver {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.prefixHandlers = append(r.prefixHandlers, textPrefixHandler{prefix: prefix, handler: handler})
+	sort.SliceStable(r.prefixHandlers, func(i, j int) bool {
+		return utf8.RuneCountInString(r.prefixHandlers[i].prefix) > utf8.RuneCountInString(r.prefixHandlers[j].prefix)
+	})
+
+	return r
+}
+
+// AddRegexpHandler adds a handler for messages matching re.
+// The submatches of re are passed to the handler as arguments.
+// Regular expressions are checked in the order they are added.
+func (r *TextResolver) AddRegexpHandler(re *regexp.Regexp, handler Handler) *TextResolver {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.regexpHandlers = append(r.regexpHandlers, textRegexpHandler{re: re, handler: handler})
+
+	return r
+}
+
+// SetUsage sets the usage line of a command, e.g. "SUBSCRIBE <topic> <symbol>".
+// Usage lines are sent in the help reply if it is enabled.
+func (r *TextResolver) SetUsage(command, usage string) *TextResolver {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.usages[r.normalize(command)] = usage
+
+	return r
+}
+
+// SetHelpReply enables or disables the help reply.
+// If enabled, an unknown command is answered with a text message listing the available commands
+// instead of returning an error.
+func (r *TextResolver) SetHelpReply(enabled bool) *TextResolver {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.helpReply = enabled
+
+	return r
+}
+
+func (r *TextResolver) Handle(ctx context.Context, msg []byte, rw ResponseWriter) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	line := strings.TrimSpace(string(msg))
+	if line == "" {
+		return fmt.Errorf("empty command")
+	}
+
+	handler, args := r.resolve(line)
+	if handler == nil {
+		command := strings.Fields(line)[0]
+		if r.helpReply {
+			return rw.WriteMessage(NewTextMessage([]byte(r.help(command))))
+		}
+		return fmt.Errorf("unknown command %q", command)
+	}
+
+	return handler(context.WithValue(ctx, textArgsContextKey{}, args), msg, rw)
+}
+
+func (r *TextResolver) resolve(line string) (Handler, []string) {
+	fields := strings.Fields(line)
+	if handler, ok := r.commands[r.normalize(fields[0])]; ok {
+		return handler, fields[1:]
+	}
+
+	for _, ph := range r.prefixHandlers {
+		if rest, ok := r.trimPrefix(line, ph.prefix); ok {
+			return ph.handler, strings.Fields(rest)
+		}
+	}
+
+	for _, rh := range r.regexpHandlers {
+		if match := rh.re.FindStringSubmatch(line); match != nil {
+			return rh.handler, match[1:]
+		}
+	}
+
+	return nil, nil
+}
+
+// help lists the commands, then the prefixes and the regular expressions.
+func (r *TextResolver) help(command string) string {
+	lines := make([]string, 0, len(r.commands)+len(r.prefixHandlers)+len(r.regexpHandlers))
+	for name := range r.commands {
+		lines = append(lines, r.usages[name])
+	}
+	sort.Strings(lines)
+
+	prefixes := make([]string, 0, len(r.prefixHandlers))
+	for _, ph := range r.prefixHandlers {
+		prefixes = append(prefixes, ph.prefix+"...")
+	}
+	sort.Strings(prefixes)
+	lines = append(lines, prefixes...)
+
+	for _, rh := range r.regexpHandlers {
+		lines = append(lines, rh.re.String())
+	}
+
+	return fmt.Sprintf("unknown command %q, available commands:\n%s", command, strings.Join(lines, "\n"))
+}
+
+// trimPrefix returns s without prefix and true if s starts with prefix.
+// Without case sensitivity, prefix is compared rune by rune, as case folding can change the byte length of a rune.
+func (r *TextResolver) trimPrefix(s, prefix string) (string, bool) {
+	if !r.caseInsensitive {
+		if !strings.HasPrefix(s, prefix) {
+			return "", false
+		}
+		return s[len(prefix):], true
+	}
+
+	for _, want := range prefix {
+		got, size := utf8.DecodeRuneInString(s)
+		if size == 0 || !strings.EqualFold(string(got), string(want)) {
+			return "", false
+		}
+		s = s[size:]
+	}
+	return s, true
+}
+
+func (r *TextResolver) normalize(s string) string {
+	if r.caseInsensitive {
+		return strings.ToLower(s)
+	}
+	return s
+}
+
+// TextArgsFromContext returns the arguments of a command resolved by a TextResolver.
+func TextArgsFromContext(ctx context.Context) []string {
+	args, _ := ctx.Value(textArgsContextKey{}).([]string)
+	return args
+}
@@ -0,0 +1,120 @@
+package wsocket
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTextResolver_Handle(t *testing.T) {
+	writeArgs := func(name string) Handler {
+		return func(ctx context.Context, msg []byte, rw ResponseWriter) error {
+			return rw.WriteMessage(NewTextMessage([]byte(name + ":" + strings.Join(TextArgsFromContext(ctx), ","))))
+		}
+	}
+
+	resolver := NewTextResolver(true).
+		AddHandler("SUBSCRIBE", writeArgs("subscribe")).
+		AddHandler("PING", writeArgs("ping")).
+		AddPrefixHandler("GET ", writeArgs("get")).
+		AddPrefixHandler("GET /v2/", writeArgs("get-v2")).
+		AddRegexpHandler(regexp.MustCompile(`^(\d+)\+(\d+)$`), writeArgs("sum")).
+		SetUsage("SUBSCRIBE", "SUBSCRIBE <topic> <symbol>")
+
+	tests := []struct {
+		name           string
+		inputMessage   string
+		expectedResult string
+		expectedError  bool
+	}{
+		{
+			name:           "Command With Arguments",
+			inputMessage:   "SUBSCRIBE prices EURUSD",
+			expectedResult: "subscribe:prices,EURUSD",
+		},
+		{
+			name:           "Case Insensitive Command",
+			inputMessage:   "  subscribe   prices  EURUSD\n",
+			expectedResult: "subscribe:prices,EURUSD",
+		},
+		{
+			name:           "Command Without Arguments",
+			inputMessage:   "Ping",
+			expectedResult: "ping:",
+		},
+		{
+			name:           "Longest Prefix",
+			inputMessage:   "get /v2/prices EURUSD",
+			expectedResult: "get-v2:prices,EURUSD",
+		},
+		{
+			name:           "Prefix",
+			inputMessage:   "GET /prices",
+			expectedResult: "get:/prices",
+		},
+		{
+			name:           "Regexp",
+			inputMessage:   "1+2",
+			expectedResult: "sum:1,2",
+		},
+		{
+			name:          "Unknown Command",
+			inputMessage:  "UNSUBSCRIBE prices",
+			expectedError: true,
+		},
+		{
+			name:          "Empty Message",
+			inputMessage:  "  ",
+			expectedError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rw := &testResponseWriter{}
+
+			err := resolver.Handle(context.Background(), []byte(test.inputMessage), rw)
+			if test.expectedError {
+				assert.Error(t, err, "Expected an error")
+			} else {
+				assert.NoError(t, err, "Expected no error")
+				message := rw.GetWrittenMessage()
+				assert.NotNilf(t, message, "Expected a message to be written")
+				assert.Equal(t, test.expectedResult, string(message.Message))
+			}
+		})
+	}
+
+	t.Run("Help Reply", func(t *testing.T) {
+		resolver.SetHelpReply(true)
+		defer resolver.SetHelpReply(false)
+
+		rw := &testResponseWriter{}
+		err := resolver.Handle(context.Background(), []byte("UNSUBSCRIBE prices"), rw)
+		assert.NoError(t, err)
+		assert.Equal(t, "unknown command \"UNSUBSCRIBE\", available commands:\nPING\nSUBSCRIBE <topic> <symbol>\nGET ...\nGET /v2/...\n^(\\d+)\\+(\\d+)$", string(rw.GetWrittenMessage().Message))
+	})
+}
+
+func TestTextResolver_UnicodePrefix(t *testing.T) {
+	writeArgs := func(ctx context.Context, msg []byte, rw ResponseWriter) error {
+		return rw.WriteMessage(NewTextMessage([]byte(strings.Join(TextArgsFromContext(ctx), ","))))
+	}
+	// "ſ" (U+017F) folds to "s" and is two bytes long, "K" (U+212A, Kelvin sign) folds to "k" and is three bytes long.
+	resolver := NewTextResolver(true).AddPrefixHandler("ſet:", writeArgs)
+
+	for _, msg := range []string{"SET: a b", "ſet: a b", "set: a b"} {
+		rw := &testResponseWriter{}
+		assert.NoError(t, resolver.Handle(context.Background(), []byte(msg), rw), msg)
+		assert.Equal(t, "a,b", string(rw.GetWrittenMessage().Message), msg)
+	}
+
+	resolver = NewTextResolver(true).AddPrefixHandler("K:", writeArgs)
+	rw := &testResponseWriter{}
+	assert.NoError(t, resolver.Handle(context.Background(), []byte("k: x"), rw))
+	assert.Equal(t, "x", string(rw.GetWrittenMessage().Message))
+	assert.Error(t, resolver.Handle(context.Background(), []byte("k"), &testResponseWriter{}))
+}
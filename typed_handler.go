@@ -0,0 +1,40 @@
+package wsocket
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// TypedHandler handles a JSON message decoded into Req.
+// If the returned response is not nil, it is encoded to JSON and written to the connection as a text message.
+type TypedHandler[Req, Resp any] func(ctx context.Context, req *Req, rw ResponseWriter) (*Resp, error)
+
+// AddTypedHandler adds a TypedHandler to the resolver.
+// It behaves like JSONResolver.AddHandler and additionally declares Req and Resp as the route payload, see WithPayload.
+func AddTypedHandler[Req, Resp any](r *JSONResolver, name string, handler TypedHandler[Req, Resp], opts ...RouteOption) *JSONResolver {
+	var req Req
+	var resp Resp
+	opts = append([]RouteOption{WithPayload(req, resp)}, opts...)
+
+	return r.AddHandler(name, func(ctx context.Context, msg []byte, rw ResponseWriter) error {
+		req := new(Req)
+		if err := json.Unmarshal(msg, req); err != nil {
+			return fmt.Errorf("failed to decode message: %w", err)
+		}
+
+		resp, err := handler(ctx, req, rw)
+		if err != nil {
+			return err
+		}
+		if resp == nil {
+			return nil
+		}
+
+		respMsg, err := json.Marshal(resp)
+		if err != nil {
+			return fmt.Errorf("failed to encode response: %w", err)
+		}
+		return rw.WriteMessage(NewTextMessage(respMsg))
+	}, opts...)
+}
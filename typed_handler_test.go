@@ -0,0 +1,84 @@
+package wsocket
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testSumRequest struct {
+	Type string `json:"type"`
+	A    int    `json:"a"`
+	B    int    `json:"b"`
+}
+
+type testSumResponse struct {
+	Type   string `json:"type"`
+	Result int    `json:"result"`
+}
+
+func TestAddTypedHandler(t *testing.T) {
+	resolver := NewJSONResolver("type")
+	AddTypedHandler(resolver, "sum-request", func(ctx context.Context, req *testSumRequest, rw ResponseWriter) (*testSumResponse, error) {
+		if req.A < 0 {
+			return nil, errors.New("negative")
+		}
+		if req.A == 0 {
+			return nil, nil
+		}
+		return &testSumResponse{Type: "sum-response", Result: req.A + req.B}, nil
+	})
+
+	tests := []struct {
+		name           string
+		inputMessage   string
+		expectedResult string
+		expectedError  bool
+	}{
+		{
+			name:           "Response",
+			inputMessage:   `{"type": "sum-request", "a": 1, "b": 2}`,
+			expectedResult: `{"type":"sum-response","result":3}`,
+		},
+		{
+			name:         "No Response",
+			inputMessage: `{"type": "sum-request", "a": 0, "b": 2}`,
+		},
+		{
+			name:          "Handler Error",
+			inputMessage:  `{"type": "sum-request", "a": -1, "b": 2}`,
+			expectedError: true,
+		},
+		{
+			name:          "Invalid Request",
+			inputMessage:  `{"type": "sum-request", "a": "1"}`,
+			expectedError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rw := &testResponseWriter{}
+
+			err := resolver.Handle(context.Background(), []byte(test.inputMessage), rw)
+			if test.expectedError {
+				assert.Error(t, err, "Expected an error")
+				return
+			}
+			assert.NoError(t, err, "Expected no error")
+			if test.expectedResult == "" {
+				assert.Nil(t, rw.GetWrittenMessage())
+			} else {
+				assert.Equal(t, test.expectedResult, string(rw.GetWrittenMessage().Message))
+			}
+		})
+	}
+
+	routes := resolver.Routes()
+	assert.Len(t, routes, 1)
+	assert.Equal(t, reflect.TypeOf(testSumRequest{}), routes[0].Request)
+	assert.Equal(t, reflect.TypeOf(testSumResponse{}), routes[0].Response)
+}